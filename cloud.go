@@ -1,17 +1,45 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	"github.com/ripple-cloud/cloud/data"
 	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/mailer"
 	"github.com/ripple-cloud/cloud/router"
+	"golang.org/x/crypto/bcrypt"
 )
 
-var dbURL, tokenSecret, addr string
+var dbURL, addr, deviceVerificationURI, publicURL string
+var keys *keyring.Keyring
+var mail mailer.Mailer
+var passwordPolicy = &data.PasswordPolicy{MinLength: data.DefaultPasswordPolicy.MinLength}
+
+// how long deleted accounts can be restored
+var deletionGrace = 30 * 24 * time.Hour
+
+// how long the old slug of a renamed hub keeps pointing at it
+var hubSlugHold = 30 * 24 * time.Hour
+
+// how long a user offered a hub has to accept it
+var hubTransferExpiry = 7 * 24 * time.Hour
+
+// proxies whose X-Forwarded-For header is believed
+var trustedProxies []*net.IPNet
+
+// clients allowed to introspect tokens and to administer them
+// (client id => client secret)
+var introspectionClients, adminClients map[string]string
 
 func init() {
 	dbURL = os.Getenv("DB_URL")
@@ -19,9 +47,122 @@ func init() {
 		panic("DB_URL not set")
 	}
 
-	tokenSecret = os.Getenv("TOKEN_SECRET")
-	if tokenSecret == "" {
-		panic("TOKEN_SECRET is not set")
+	// tokens are signed with the keys in KEY_DIR if set, TOKEN_SECRET otherwise.
+	// TOKEN_SECRET keeps verifying tokens issued before switching to KEY_DIR.
+	tokenSecret := os.Getenv("TOKEN_SECRET")
+	if keyDir := os.Getenv("KEY_DIR"); keyDir != "" {
+		var err error
+		keys, err = keyring.Load(keyDir, os.Getenv("SIGNING_KEY_ID"), []byte(tokenSecret))
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		if tokenSecret == "" {
+			panic("KEY_DIR or TOKEN_SECRET must be set")
+		}
+		keys = keyring.NewHMAC([]byte(tokenSecret))
+	}
+
+	// eg: INTROSPECTION_CLIENTS=relay:s3cret,dashboard:s3cret
+	introspectionClients = parseClients("INTROSPECTION_CLIENTS")
+	adminClients = parseClients("ADMIN_CLIENTS")
+
+	// eg: TRUSTED_PROXIES=10.0.0.0/8,192.0.2.1
+	trustedProxies = parseTrustedProxies("TRUSTED_PROXIES")
+
+	// where hub owners enter the user code shown by a hub being paired
+	deviceVerificationURI = os.Getenv("DEVICE_VERIFICATION_URI")
+	if deviceVerificationURI == "" {
+		deviceVerificationURI = "https://ripple.io/device"
+	}
+
+	// emails are sent with SMTP_ADDR if set, or written to MAIL_DIR otherwise,
+	// for development. Without either, users could never verify their email.
+	// Links in emails point at PUBLIC_URL.
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		var err error
+		mail, err = mailer.NewSMTP(smtpAddr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("MAIL_FROM"))
+		if err != nil {
+			panic(err)
+		}
+	} else if mailDir := os.Getenv("MAIL_DIR"); mailDir != "" {
+		mail = &mailer.Outbox{Dir: mailDir}
+	} else {
+		panic("SMTP_ADDR or MAIL_DIR must be set")
+	}
+	publicURL = os.Getenv("PUBLIC_URL")
+
+	// eg: ACCOUNT_DELETION_GRACE=168h
+	if grace := os.Getenv("ACCOUNT_DELETION_GRACE"); grace != "" {
+		var err error
+		deletionGrace, err = time.ParseDuration(grace)
+		if err != nil {
+			panic("ACCOUNT_DELETION_GRACE must be a duration: " + err.Error())
+		}
+	}
+
+	// eg: HUB_SLUG_HOLD=168h
+	if hold := os.Getenv("HUB_SLUG_HOLD"); hold != "" {
+		var err error
+		hubSlugHold, err = time.ParseDuration(hold)
+		if err != nil {
+			panic("HUB_SLUG_HOLD must be a duration: " + err.Error())
+		}
+	}
+
+	// eg: HUB_TRANSFER_EXPIRY=72h
+	if expiry := os.Getenv("HUB_TRANSFER_EXPIRY"); expiry != "" {
+		var err error
+		hubTransferExpiry, err = time.ParseDuration(expiry)
+		if err != nil {
+			panic("HUB_TRANSFER_EXPIRY must be a duration: " + err.Error())
+		}
+	}
+
+	// eg: HUB_STALE_AFTER=90s HUB_OFFLINE_AFTER=5m
+	for env, d := range map[string]*time.Duration{
+		"HUB_STALE_AFTER":   &data.HubPresence.StaleAfter,
+		"HUB_OFFLINE_AFTER": &data.HubPresence.OfflineAfter,
+	} {
+		if v := os.Getenv(env); v != "" {
+			var err error
+			*d, err = time.ParseDuration(v)
+			if err != nil {
+				panic(env + " must be a duration: " + err.Error())
+			}
+		}
+	}
+	if data.HubPresence.StaleAfter > data.HubPresence.OfflineAfter {
+		panic("HUB_STALE_AFTER must not be longer than HUB_OFFLINE_AFTER")
+	}
+
+	// eg: PASSWORD_MIN_LENGTH=10 PASSWORD_LIST=/etc/ripple/common-passwords.txt
+	if minLength := os.Getenv("PASSWORD_MIN_LENGTH"); minLength != "" {
+		var err error
+		passwordPolicy.MinLength, err = strconv.Atoi(minLength)
+		if err != nil {
+			panic("PASSWORD_MIN_LENGTH must be a number: " + err.Error())
+		}
+	}
+	if list := os.Getenv("PASSWORD_LIST"); list != "" {
+		var err error
+		passwordPolicy.Common, err = data.LoadPasswordList(list)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// passwords encrypted with a lower cost are upgraded on login
+	if cost := os.Getenv("BCRYPT_COST"); cost != "" {
+		var err error
+		data.PasswordCost, err = strconv.Atoi(cost)
+		if err != nil {
+			panic("BCRYPT_COST must be a number: " + err.Error())
+		}
+		// bcrypt fails above its max cost, and silently uses its default below its min
+		if data.PasswordCost < bcrypt.MinCost || data.PasswordCost > bcrypt.MaxCost {
+			panic(fmt.Sprintf("BCRYPT_COST must be from %d to %d", bcrypt.MinCost, bcrypt.MaxCost))
+		}
 	}
 
 	port := os.Getenv("PORT")
@@ -31,6 +172,46 @@ func init() {
 	addr = "0.0.0.0:" + port
 }
 
+// parseClients reads a list of client_id:client_secret from an env variable.
+func parseClients(env string) map[string]string {
+	clients := map[string]string{}
+	for _, c := range strings.Split(os.Getenv(env), ",") {
+		if c == "" {
+			continue
+		}
+		idSecret := strings.SplitN(c, ":", 2)
+		if len(idSecret) != 2 || idSecret[0] == "" || idSecret[1] == "" {
+			panic(env + " must be a list of client_id:client_secret")
+		}
+		clients[idSecret[0]] = idSecret[1]
+	}
+	return clients
+}
+
+// parseTrustedProxies reads a list of IP addresses and CIDR ranges from an env variable.
+func parseTrustedProxies(env string) []*net.IPNet {
+	proxies := []*net.IPNet{}
+	for _, p := range strings.Split(os.Getenv(env), ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			panic(env + " must be a list of IP addresses or CIDR ranges")
+		}
+		proxies = append(proxies, n)
+	}
+	return proxies
+}
+
 func main() {
 	db, err := sqlx.Open("postgres", dbURL)
 	if err != nil {
@@ -41,17 +222,100 @@ func main() {
 	r := router.New()
 
 	// default handlers are applied to all routes
-	r.Default(handlers.SetConfig(db, []byte(tokenSecret)))
+	r.Default(handlers.SetConfig(db, keys), handlers.SetMailer(mail, publicURL), handlers.SetPasswordPolicy(passwordPolicy), handlers.SetTrustedProxies(trustedProxies))
 
 	// unauthenticated routes
+	r.GET("/.well-known/jwks.json", handlers.JWKS)
 	r.POST("/signup", handlers.Signup)
+	r.GET("/signup/verify", handlers.VerifyEmail)
+	r.POST("/signup/verify", handlers.VerifyEmail)
+	r.POST("/signup/verify/resend", handlers.ResendVerification)
+	r.POST("/password/forgot", handlers.ForgotPassword)
+	r.POST("/password/reset", handlers.ResetPassword)
 	r.POST("/oauth/token", handlers.UserToken)
+	r.POST("/oauth/revoke", handlers.RevokeToken)
+	r.POST("/oauth/device_authorization", handlers.DeviceAuthorization(deviceVerificationURI))
+
+	// client authenticated routes
+	r.POST("/oauth/introspect", handlers.ClientAuth(introspectionClients), handlers.IntrospectToken)
+	r.POST("/admin/tokens/:id/expire", handlers.ClientAuth(adminClients), handlers.ExpireToken)
 
 	// authenticated routes
-	r.POST("/api/v0/hub", handlers.Auth, handlers.AddHub)
-	r.GET("/api/v0/hub", handlers.Auth, handlers.ShowHub)
-	r.DELETE("/api/v0/hub", handlers.Auth, handlers.DeleteHub)
+	r.GET("/oauth/authorize", handlers.Auth("user"), handlers.ShowAuthorize)
+	r.POST("/oauth/authorize", handlers.Auth("user"), handlers.Authorize)
+
+	r.GET("/api/v0/user", handlers.Auth("user"), handlers.ShowUser)
+	r.PATCH("/api/v0/user", handlers.Auth("user"), handlers.UpdateUser)
+	r.PATCH("/api/v0/user/password", handlers.Auth("user"), handlers.ChangePassword)
+	r.DELETE("/api/v0/user", handlers.Auth("user"), handlers.DeleteUser(deletionGrace))
+	r.POST("/api/v0/user/restore", handlers.Auth("user"), handlers.RestoreUser)
+	r.POST("/api/v0/user/otp", handlers.Auth("user"), handlers.EnrollOTP)
+	r.POST("/api/v0/user/otp/confirm", handlers.Auth("user"), handlers.ConfirmOTP)
+	r.DELETE("/api/v0/user/otp", handlers.Auth("user"), handlers.DisableOTP)
+	r.GET("/api/v0/user/tokens", handlers.Auth("user"), handlers.ShowSessions)
+	r.DELETE("/api/v0/user/tokens", handlers.Auth("user"), handlers.RevokeTokens)
+	r.DELETE("/api/v0/user/tokens/:id", handlers.Auth("user"), handlers.RevokeSession)
+	r.POST("/api/v0/user/personal_tokens", handlers.Auth("user"), handlers.AddPersonalToken)
+	r.GET("/api/v0/user/personal_tokens", handlers.Auth("user"), handlers.ShowPersonalTokens)
+	r.DELETE("/api/v0/user/personal_tokens/:id", handlers.Auth("user"), handlers.RevokePersonalToken)
+
+	r.POST("/api/v0/clients", handlers.Auth("user"), handlers.AddClient)
+	r.GET("/api/v0/clients", handlers.Auth("user"), handlers.ShowClients)
+	r.POST("/api/v0/clients/:client_id/secret", handlers.Auth("user"), handlers.RotateClientSecret)
+
+	r.POST("/api/v0/hub", handlers.Auth("hub"), handlers.AddHub)
+	r.DELETE("/api/v0/hub", handlers.Auth("hub"), handlers.DeleteHub)
+	r.GET("/api/v0/hubs", handlers.Auth("hub"), handlers.ShowHubs)
+	r.GET("/api/v0/hubs/:slug", handlers.Auth("hub"), handlers.ShowHub)
+	r.PATCH("/api/v0/hubs/:slug", handlers.Auth("hub"), handlers.UpdateHub(hubSlugHold))
+	r.GET("/api/v0/hubs/:slug/presence", handlers.Auth("hub"), handlers.ShowHubPresence)
+	r.GET("/api/v0/hubs/:slug/inventory", handlers.Auth("hub"), handlers.ShowHubInventory)
+	r.GET("/api/v0/hubs/:slug/inventory/history", handlers.Auth("hub"), handlers.ShowHubInventoryHistory)
+	r.GET("/api/v0/hubs/:slug/audit", handlers.Auth("hub"), handlers.ShowHubAudit)
+	r.POST("/api/v0/hubs/:slug/transfers", handlers.Auth("hub"), handlers.OfferHub(hubTransferExpiry))
+	r.GET("/api/v0/hub_transfers", handlers.Auth("hub"), handlers.ShowHubTransfers)
+	r.POST("/api/v0/hub_transfers/:id/accept", handlers.Auth("hub"), handlers.AcceptHubTransfer)
+	r.POST("/api/v0/hub_transfers/:id/decline", handlers.Auth("hub"), handlers.DeclineHubTransfer)
+	r.DELETE("/api/v0/hub_transfers/:id", handlers.Auth("hub"), handlers.CancelHubTransfer)
+	r.POST("/api/v0/device", handlers.Auth("hub"), handlers.ApproveDevice)
+
+	// hub authenticated routes
+	r.GET("/api/v0/hub/me", handlers.Auth(data.DeviceScope), handlers.ShowHubSelf)
+	r.POST("/api/v0/hub/me/heartbeat", handlers.Auth(data.DeviceScope), handlers.HubHeartbeat)
+	r.PUT("/api/v0/hub/me/inventory", handlers.Auth(data.DeviceScope), handlers.ReportHubInventory)
+
+	go purgeDeletedUsers(db)
+	go markOfflineHubs(db)
 
 	log.Print("[info] Starting server on ", addr)
 	log.Fatal(http.ListenAndServe(addr, r))
 }
+
+// purgeDeletedUsers deletes the accounts whose grace period has passed, every hour.
+func purgeDeletedUsers(db *sqlx.DB) {
+	for range time.Tick(time.Hour) {
+		n, err := data.DeleteScheduledUsers(db)
+		if err != nil {
+			log.Print("[error] Failed to delete scheduled users: ", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("[info] Deleted %d scheduled users", n)
+		}
+	}
+}
+
+// markOfflineHubs records the hubs that stopped sending heartbeats as
+// offline, every minute.
+func markOfflineHubs(db *sqlx.DB) {
+	for range time.Tick(time.Minute) {
+		events, err := data.MarkOfflineHubs(db)
+		if err != nil {
+			log.Print("[error] Failed to mark offline hubs: ", err)
+			continue
+		}
+		for _, e := range events {
+			log.Printf("[info] Hub %d went offline", e.HubID)
+		}
+	}
+}
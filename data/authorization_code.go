@@ -0,0 +1,135 @@
+package data
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// AuthorizationCode is issued when a user approves a client's request and
+// is exchanged once by that client for an access token. Codes are bound to
+// a PKCE (RFC 7636) S256 code challenge.
+type AuthorizationCode struct {
+	ID            int64  `db:"id"`
+	HashedCode    string `db:"hashed_code"`
+	ClientID      int64  `db:"client_id"`
+	UserID        int64  `db:"user_id"`
+	RedirectURI   string `db:"redirect_uri"` // empty if not given at authorization
+	Scope         string `db:"scope"`
+	CodeChallenge string `db:"code_challenge"`
+	ExpiresIn     int64  `db:"expires_in"`
+	TokenID       *int64 `db:"token_id"` // set once exchanged
+
+	CreatedAt *time.Time `db:"created_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+// Generate creates a new random code and sets its hash.
+// The returned plain code is not persisted and must be handed to the client.
+func (ac *AuthorizationCode) Generate() (string, error) {
+	code, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+	ac.HashedCode = hashSecret(code)
+	return code, nil
+}
+
+func (ac *AuthorizationCode) Insert(db *sqlx.DB) error {
+	nstmt, err := db.PrepareNamed(`INSERT INTO authorization_codes
+	(hashed_code, client_id, user_id, redirect_uri, scope, code_challenge, expires_in)
+	VALUES (:hashed_code, :client_id, :user_id, :redirect_uri, :scope, :code_challenge, :expires_in)
+	RETURNING *;
+	`)
+	if err != nil {
+		return err
+	}
+	defer nstmt.Close()
+
+	err = nstmt.QueryRow(ac).StructScan(ac)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	return err
+}
+
+// GetByCode finds an authorization code by its plain value.
+func (ac *AuthorizationCode) GetByCode(db *sqlx.DB, code string) error {
+	err := db.Get(ac, "SELECT * FROM authorization_codes WHERE hashed_code = $1 LIMIT 1;", hashSecret(code))
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "authorization code not found"}
+	}
+	return err
+}
+
+func (ac *AuthorizationCode) Expired() bool {
+	return !time.Now().Before(expiresAt(ac.CreatedAt, ac.ExpiresIn))
+}
+
+// VerifyChallenge checks the PKCE code verifier against the S256 challenge.
+func (ac *AuthorizationCode) VerifyChallenge(verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := strings.TrimRight(base64.URLEncoding.EncodeToString(sum[:]), "=")
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(ac.CodeChallenge)) == 1
+}
+
+// Use marks the code as exchanged. It fails with a token_reused error if the
+// code was already exchanged.
+func (ac *AuthorizationCode) Use(db *sqlx.DB) error {
+	err := db.Get(ac, `UPDATE authorization_codes
+	SET used_at = now()
+	WHERE id = $1 AND used_at IS NULL
+	RETURNING *;
+	`, ac.ID)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"token_reused", "authorization code was already used"}
+	}
+	return err
+}
+
+// SetToken records the access token issued for the code, so that it can be
+// revoked if the code is ever replayed.
+func (ac *AuthorizationCode) SetToken(db *sqlx.DB, tokenID int64) error {
+	_, err := db.Exec("UPDATE authorization_codes SET token_id = $2 WHERE id = $1;", ac.ID, tokenID)
+	if err == nil {
+		ac.TokenID = &tokenID
+	}
+	return err
+}
+
+// RevokeTokens revokes the tokens issued for the code, including every token
+// later obtained from its refresh tokens.
+func (ac *AuthorizationCode) RevokeTokens(db *sqlx.DB) error {
+	if ac.TokenID == nil {
+		return nil
+	}
+	t := Token{ID: *ac.TokenID}
+	if err := t.Revoke(db); err != nil {
+		return err
+	}
+	rt := RefreshToken{FamilyID: *ac.TokenID}
+	return rt.RevokeFamily(db)
+}
@@ -0,0 +1,92 @@
+package data_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestAuthorizationCode(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	// insert a client for the user
+	cl := &data.Client{
+		Name:         "partner",
+		Scope:        "hub",
+		UserID:       u.ID,
+		RedirectURIs: "https://partner.example.com/callback",
+	}
+	if _, err := cl.GenerateCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Insert(db); err != nil {
+		t.Error("Failed to insert client to db: %v", cl)
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+
+	ac := &data.AuthorizationCode{
+		ClientID:      cl.ID,
+		UserID:        u.ID,
+		RedirectURI:   "https://partner.example.com/callback",
+		Scope:         "hub",
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+		ExpiresIn:     (10 * time.Minute).Nanoseconds(),
+	}
+	code, err := ac.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code == "" || ac.HashedCode == code {
+		t.Error("Generate must return a code and only keep its hash")
+	}
+	if err := ac.Insert(db); err != nil {
+		t.Error("Failed to insert authorization code to db: %v", ac)
+	}
+
+	// query for the inserted code
+	ac1 := &data.AuthorizationCode{}
+	if err := ac1.GetByCode(db, code); err != nil {
+		t.Error("Failed to get authorization code: ", err)
+	}
+	if ac1.ID != ac.ID {
+		t.Error("Unexpected authorization code returned: %v", ac1)
+	}
+	if ac1.Expired() {
+		t.Error("Authorization code must not be expired")
+	}
+	if !ac1.VerifyChallenge(verifier) {
+		t.Error("Expected VerifyChallenge to return true")
+	}
+	if ac1.VerifyChallenge("wrong-verifier") {
+		t.Error("Expected VerifyChallenge to return false")
+	}
+
+	// a code can be used only once
+	if err := ac1.Use(db); err != nil {
+		t.Error("Failed to use authorization code: ", err)
+	}
+	err = ac1.Use(db)
+	e, ok := err.(*data.Error)
+	if !ok || e.Code != "token_reused" {
+		t.Error("Using a code twice must return a 'token_reused' error, Got %v", err)
+	}
+
+	db.Close()
+}
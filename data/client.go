@@ -0,0 +1,145 @@
+package data
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Client is a registered OAuth client, owned by a user. Tokens issued to a
+// client act on behalf of its owner, limited to the client's allowed scope.
+type Client struct {
+	ID           int64      `db:"id" json:"-"`
+	ClientID     string     `db:"client_id" json:"client_id"`
+	HashedSecret string     `db:"hashed_secret" json:"-"`
+	Name         string     `db:"name" json:"name"`
+	Scope        string     `db:"scope" json:"scope"`
+	UserID       int64      `db:"user_id" json:"user_id"`
+	RedirectURIs string     `db:"redirect_uris" json:"redirect_uris"` // space-delimited
+	CreatedAt    *time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    *time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type Clients []Client
+
+// GenerateCredentials sets a new random client id and secret.
+// The returned plain secret is not persisted and must be handed to the owner.
+func (c *Client) GenerateCredentials() (string, error) {
+	id, err := generateSecret(16)
+	if err != nil {
+		return "", err
+	}
+	c.ClientID = id
+	return c.generateSecret()
+}
+
+func (c *Client) generateSecret() (string, error) {
+	secret, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+	c.HashedSecret = hashSecret(secret)
+	return secret, nil
+}
+
+func (c *Client) VerifySecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(c.HashedSecret)) == 1
+}
+
+// HasRedirectURI reports whether the URI exactly matches a registered one.
+func (c *Client) HasRedirectURI(uri string) bool {
+	for _, u := range strings.Fields(c.RedirectURIs) {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) Insert(db *sqlx.DB) error {
+	nstmt, err := db.PrepareNamed(`INSERT INTO oauth_clients
+	(client_id, hashed_secret, name, scope, user_id, redirect_uris, created_at, updated_at)
+	VALUES (:client_id, :hashed_secret, :name, :scope, :user_id, :redirect_uris, now(), now())
+	RETURNING *;
+	`)
+	if err != nil {
+		return err
+	}
+	defer nstmt.Close()
+
+	err = nstmt.QueryRow(c).StructScan(c)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		case "unique_violation":
+			return &Error{"unique_violation", "client exists"}
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	return err
+}
+
+func (c *Client) GetByClientID(db *sqlx.DB, clientID string) error {
+	err := db.Get(c, "SELECT * FROM oauth_clients WHERE client_id = $1 LIMIT 1;", clientID)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "client not found"}
+	}
+	return err
+}
+
+func (c *Clients) SelectByUserID(db *sqlx.DB, userID int64) error {
+	err := db.Select(c, "SELECT * FROM oauth_clients WHERE user_id = $1 ORDER BY id;", userID)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	return err
+}
+
+// RotateSecret replaces the client secret and revokes every token issued
+// with the old one. Returns the new plain secret.
+func (c *Client) RotateSecret(db *sqlx.DB) (string, error) {
+	secret, err := c.generateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Get(c, `UPDATE oauth_clients
+	SET hashed_secret = $2, updated_at = now()
+	WHERE id = $1
+	RETURNING *;
+	`, c.ID, c.HashedSecret)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return "", &Error{"record_not_found", "client not found"}
+		}
+		return "", err
+	}
+
+	_, err = tx.Exec("UPDATE tokens SET revoked_at = now() WHERE client_id = $1 AND revoked_at IS NULL;", c.ID)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	return secret, tx.Commit()
+}
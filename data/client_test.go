@@ -0,0 +1,147 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestClientInsert(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	// insert a new client for the user
+	cl := &data.Client{
+		Name:   "ci",
+		Scope:  "hub",
+		UserID: u.ID,
+	}
+	secret, err := cl.GenerateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.ClientID == "" || secret == "" || cl.HashedSecret == secret {
+		t.Error("GenerateCredentials must set a client id and only keep the secret hash")
+	}
+	if err := cl.Insert(db); err != nil {
+		t.Error("Failed to insert client to db: %v", cl)
+	}
+
+	// check if returned values are scanned back to the struct
+	if cl.ID == 0 {
+		t.Error("ID must be set")
+	}
+
+	if cl.CreatedAt == nil {
+		t.Error("CreatedAt must be set")
+	}
+
+	// query for the inserted client by client id
+	cl1 := &data.Client{}
+	if err := cl1.GetByClientID(db, cl.ClientID); err != nil {
+		t.Error("Failed to get client with client id: ", cl.ClientID)
+	}
+	if cl1.ID != cl.ID {
+		t.Error("Unexpected client record returned: %v", cl1)
+	}
+	if !cl1.VerifySecret(secret) {
+		t.Error("Expected VerifySecret to return true")
+	}
+	if cl1.VerifySecret("such-secret-very-secure") {
+		t.Error("Expected VerifySecret to return false")
+	}
+
+	// query for the clients of the user
+	var cls data.Clients
+	if err := cls.SelectByUserID(db, u.ID); err != nil {
+		t.Error("Failed to get clients with userid: ", u.ID)
+	}
+	if len(cls) != 1 || cls[0].ID != cl.ID {
+		t.Error("Unexpected clients returned: %v", cls)
+	}
+
+	// query for a non-existing client
+	cl2 := &data.Client{}
+	err = cl2.GetByClientID(db, "unknown")
+	e, ok := err.(*data.Error)
+	if !ok {
+		t.Error("Returned error must be of type `data.Error`")
+	}
+	if e.Code != "record_not_found" {
+		t.Error("Error code must be 'record_not_found' but received %s", e.Code)
+	}
+	if e.Desc != "client not found" {
+		t.Error("Error desc must be 'client not found' but received %s", e.Desc)
+	}
+
+	db.Close()
+}
+
+func TestClientRotateSecret(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	cl := &data.Client{
+		Name:   "ci",
+		Scope:  "hub",
+		UserID: u.ID,
+	}
+	secret, err := cl.GenerateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Insert(db); err != nil {
+		t.Error("Failed to insert client to db: %v", cl)
+	}
+
+	// insert a token issued to the client
+	tok := &data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "hub",
+		ClientID:  &cl.ID,
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Error("Failed to insert token to db: %v", tok)
+	}
+
+	newSecret, err := cl.RotateSecret(db)
+	if err != nil {
+		t.Error("Failed to rotate client secret: ", err)
+	}
+	if cl.VerifySecret(secret) || !cl.VerifySecret(newSecret) {
+		t.Error("Only the new secret must be valid after rotation")
+	}
+
+	// tokens issued with the old secret are revoked
+	if err := tok.Get(db, tok.ID); err != nil {
+		t.Error("Failed to find token for id: ", tok.ID)
+	}
+	if tok.RevokedAt == nil {
+		t.Error("Token issued to the client must be revoked")
+	}
+
+	db.Close()
+}
@@ -0,0 +1,263 @@
+package data
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// userCodeChars leaves out vowels and lookalikes so user codes are easy to
+// type and never spell words (RFC 8628 6.1).
+const userCodeChars = "BCDFGHJKLMNPQRSTVWXZ"
+
+const userCodeLength = 8
+
+// DeviceCode is issued to a headless hub asking to be paired (RFC 8628).
+// The hub polls with the device code while its owner approves the user code.
+// On approval the hub is registered, and it gets its credential on the next poll.
+type DeviceCode struct {
+	ID               int64  `db:"id"`
+	HashedDeviceCode string `db:"hashed_device_code"`
+	UserCode         string `db:"user_code"` // normalized, see NormalizeUserCode
+	ExpiresIn        int64  `db:"expires_in"`
+	PollInterval     int64  `db:"poll_interval"` // seconds
+	UserID           *int64 `db:"user_id"`       // set once approved or denied
+	HubID            *int64 `db:"hub_id"`        // set once approved
+
+	CreatedAt *time.Time `db:"created_at"`
+	PolledAt  *time.Time `db:"polled_at"`
+	DeniedAt  *time.Time `db:"denied_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+// Generate creates a new random device code and user code, and sets the
+// hash of the device code. The returned device code is not persisted.
+func (dc *DeviceCode) Generate() (string, error) {
+	code, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 0, userCodeLength)
+	buf := make([]byte, 1)
+	for len(b) < userCodeLength {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		// reject bytes that would bias the modulo
+		if int(buf[0]) >= 256-256%len(userCodeChars) {
+			continue
+		}
+		b = append(b, userCodeChars[int(buf[0])%len(userCodeChars)])
+	}
+
+	dc.HashedDeviceCode = hashSecret(code)
+	dc.UserCode = string(b)
+	return code, nil
+}
+
+// FormattedUserCode returns the user code as shown to the user (eg: BDFG-HJKL).
+func (dc *DeviceCode) FormattedUserCode() string {
+	return dc.UserCode[:userCodeLength/2] + "-" + dc.UserCode[userCodeLength/2:]
+}
+
+// NormalizeUserCode ignores case and any separators typed by the user.
+func NormalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		if strings.ContainsRune(userCodeChars, r) {
+			return r
+		}
+		return -1
+	}, code)
+}
+
+func (dc *DeviceCode) Insert(db *sqlx.DB) error {
+	nstmt, err := db.PrepareNamed(`INSERT INTO device_codes
+	(hashed_device_code, user_code, expires_in, poll_interval)
+	VALUES (:hashed_device_code, :user_code, :expires_in, :poll_interval)
+	RETURNING *;
+	`)
+	if err != nil {
+		return err
+	}
+	defer nstmt.Close()
+
+	err = nstmt.QueryRow(dc).StructScan(dc)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	return err
+}
+
+// GetByDeviceCode finds a device code by its plain value.
+func (dc *DeviceCode) GetByDeviceCode(db *sqlx.DB, code string) error {
+	err := db.Get(dc, "SELECT * FROM device_codes WHERE hashed_device_code = $1 LIMIT 1;", hashSecret(code))
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "device code not found"}
+	}
+	return err
+}
+
+// GetByUserCode finds a device code by the user code typed by the user.
+func (dc *DeviceCode) GetByUserCode(db *sqlx.DB, code string) error {
+	err := db.Get(dc, "SELECT * FROM device_codes WHERE user_code = $1 LIMIT 1;", NormalizeUserCode(code))
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "device code not found"}
+	}
+	return err
+}
+
+func (dc *DeviceCode) Expired() bool {
+	return !time.Now().Before(expiresAt(dc.CreatedAt, dc.ExpiresIn))
+}
+
+// Poll records a poll by the hub. It fails with a slow_down error, and
+// increases the interval by 5 seconds, if the hub polls more often than the
+// interval allows (RFC 8628 3.5).
+func (dc *DeviceCode) Poll(db *sqlx.DB) error {
+	err := db.Get(dc, `UPDATE device_codes
+	SET polled_at = now()
+	WHERE id = $1 AND (polled_at IS NULL OR polled_at <= now() - poll_interval * interval '1 second')
+	RETURNING *;
+	`, dc.ID)
+	if err != sql.ErrNoRows {
+		if err, ok := err.(*pq.Error); ok {
+			return &Error{err.Code.Name(), "pq error"}
+		}
+		return err
+	}
+
+	err = db.Get(dc, `UPDATE device_codes
+	SET polled_at = now(), poll_interval = poll_interval + 5
+	WHERE id = $1
+	RETURNING *;
+	`, dc.ID)
+	if err, ok := err.(*pq.Error); ok {
+		return &Error{err.Code.Name(), "pq error"}
+	}
+	if err != nil {
+		return err
+	}
+	return &Error{"slow_down", "polling too frequently"}
+}
+
+// Approve registers the hub for the approving user and binds it to the
+// device code. It fails with a code_handled error if the code was already
+// approved or denied.
+func (dc *DeviceCode) Approve(db *sqlx.DB, h *Hub) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if err := claimSlug(tx, h.Slug, h.UserID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	nstmt, err := tx.PrepareNamed(`INSERT INTO hubs
+	(slug, user_id, created_at, updated_at)
+	VALUES (:slug, :user_id, now(), now())
+	RETURNING *;
+	`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer nstmt.Close()
+
+	err = nstmt.QueryRow(h).StructScan(h)
+	if err != nil {
+		tx.Rollback()
+		if err, ok := err.(*pq.Error); ok {
+			switch err.Code.Name() {
+			case "unique_violation":
+				return &Error{"unique_violation", "hub exists"}
+			default:
+				return &Error{err.Code.Name(), "pq error"}
+			}
+		}
+		return err
+	}
+
+	err = tx.Get(dc, `UPDATE device_codes
+	SET user_id = $2, hub_id = $3
+	WHERE id = $1 AND user_id IS NULL
+	RETURNING *;
+	`, dc.ID, h.UserID, h.ID)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return &Error{"code_handled", "device code was already handled"}
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Deny records that the user refused to pair the hub. It fails with a
+// code_handled error if the code was already approved or denied.
+func (dc *DeviceCode) Deny(db *sqlx.DB, userID int64) error {
+	err := db.Get(dc, `UPDATE device_codes
+	SET user_id = $2, denied_at = now()
+	WHERE id = $1 AND user_id IS NULL
+	RETURNING *;
+	`, dc.ID, userID)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"code_handled", "device code was already handled"}
+	}
+	return err
+}
+
+// Use marks the code as exchanged. It fails with a token_reused error if the
+// code was already exchanged.
+func (dc *DeviceCode) Use(db *sqlx.DB) error {
+	err := db.Get(dc, `UPDATE device_codes
+	SET used_at = now()
+	WHERE id = $1 AND used_at IS NULL
+	RETURNING *;
+	`, dc.ID)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"token_reused", "device code was already used"}
+	}
+	return err
+}
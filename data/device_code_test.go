@@ -0,0 +1,108 @@
+package data_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestDeviceCodeGenerate(t *testing.T) {
+	dc := &data.DeviceCode{}
+	code, err := dc.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code == "" || dc.HashedDeviceCode == code {
+		t.Error("Generate must return a device code and only keep its hash")
+	}
+	if len(dc.UserCode) != 8 || strings.Trim(dc.UserCode, "BCDFGHJKLMNPQRSTVWXZ") != "" {
+		t.Errorf("Unexpected user code %s", dc.UserCode)
+	}
+
+	formatted := dc.FormattedUserCode()
+	if formatted != dc.UserCode[:4]+"-"+dc.UserCode[4:] {
+		t.Errorf("Unexpected formatted user code %s", formatted)
+	}
+	if n := data.NormalizeUserCode(strings.ToLower(formatted)); n != dc.UserCode {
+		t.Errorf("Expected %s to normalize to %s, Got %s", formatted, dc.UserCode, n)
+	}
+}
+
+func TestDeviceCodeApprove(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	dc := &data.DeviceCode{
+		ExpiresIn:    (10 * time.Minute).Nanoseconds(),
+		PollInterval: 5,
+	}
+	code, err := dc.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dc.Insert(db); err != nil {
+		t.Error("Failed to insert device code to db: %v", dc)
+	}
+
+	// the first poll is always allowed, the next one is too early
+	if err := dc.Poll(db); err != nil {
+		t.Error("Failed to poll device code: ", err)
+	}
+	err = dc.Poll(db)
+	if e, ok := err.(*data.Error); !ok || e.Code != "slow_down" {
+		t.Error("Polling too early must return a 'slow_down' error, Got %v", err)
+	}
+	if dc.PollInterval != 10 {
+		t.Error("Polling too early must increase the interval, Got %d", dc.PollInterval)
+	}
+
+	// approve the code typed by the user
+	dc1 := &data.DeviceCode{}
+	if err := dc1.GetByUserCode(db, dc.FormattedUserCode()); err != nil {
+		t.Error("Failed to get device code by user code: ", err)
+	}
+	h := &data.Hub{
+		Slug:   "earthworm",
+		UserID: u.ID,
+	}
+	if err := dc1.Approve(db, h); err != nil {
+		t.Error("Failed to approve device code: ", err)
+	}
+	if h.ID == 0 || dc1.HubID == nil || *dc1.HubID != h.ID {
+		t.Error("Approve must register the hub and bind it to the code")
+	}
+
+	// a code is handled only once
+	err = dc1.Deny(db, u.ID)
+	if e, ok := err.(*data.Error); !ok || e.Code != "code_handled" {
+		t.Error("Denying an approved code must return a 'code_handled' error, Got %v", err)
+	}
+
+	// the hub exchanges the code only once
+	dc2 := &data.DeviceCode{}
+	if err := dc2.GetByDeviceCode(db, code); err != nil {
+		t.Error("Failed to get device code: ", err)
+	}
+	if err := dc2.Use(db); err != nil {
+		t.Error("Failed to use device code: ", err)
+	}
+	err = dc2.Use(db)
+	if e, ok := err.(*data.Error); !ok || e.Code != "token_reused" {
+		t.Error("Using a code twice must return a 'token_reused' error, Got %v", err)
+	}
+
+	db.Close()
+}
@@ -0,0 +1,112 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// EmailVerification is sent to a user's email address to prove they own it.
+// It only verifies the address it was sent to.
+type EmailVerification struct {
+	ID          int64  `db:"id"`
+	UserID      int64  `db:"user_id"`
+	HashedToken string `db:"hashed_token"`
+	Email       string `db:"email"`
+	ExpiresIn   int64  `db:"expires_in"`
+
+	CreatedAt *time.Time `db:"created_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+// Generate creates a new random token and sets its hash.
+// The returned plain token is not persisted and must be mailed to the user.
+func (ev *EmailVerification) Generate() (string, error) {
+	token, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+	ev.HashedToken = hashSecret(token)
+	return token, nil
+}
+
+func (ev *EmailVerification) Insert(db *sqlx.DB) error {
+	nstmt, err := db.PrepareNamed(`INSERT INTO email_verifications
+	(user_id, hashed_token, email, expires_in)
+	VALUES (:user_id, :hashed_token, :email, :expires_in)
+	RETURNING *;
+	`)
+	if err != nil {
+		return err
+	}
+	defer nstmt.Close()
+
+	err = nstmt.QueryRow(ev).StructScan(ev)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	return err
+}
+
+// GetByToken finds a verification by its plain token.
+func (ev *EmailVerification) GetByToken(db *sqlx.DB, token string) error {
+	err := db.Get(ev, "SELECT * FROM email_verifications WHERE hashed_token = $1 LIMIT 1;", hashSecret(token))
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "verification not found"}
+	}
+	return err
+}
+
+func (ev *EmailVerification) Expired() bool {
+	return !time.Now().Before(expiresAt(ev.CreatedAt, ev.ExpiresIn))
+}
+
+// Verify uses the verification and marks the user's email as verified.
+// It fails with a token_reused error if the verification was already used,
+// and with an invalid_token error if the user changed email since.
+func (ev *EmailVerification) Verify(db *sqlx.DB, u *User) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Get(ev, `UPDATE email_verifications
+	SET used_at = now()
+	WHERE id = $1 AND used_at IS NULL
+	RETURNING *;
+	`, ev.ID)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return &Error{"token_reused", "verification was already used"}
+		}
+		return err
+	}
+
+	err = tx.Get(u, `UPDATE users
+	SET email_verified_at = COALESCE(email_verified_at, now()), updated_at = now()
+	WHERE id = $1 AND email = $2
+	RETURNING *;
+	`, ev.UserID, ev.Email)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return &Error{"invalid_token", "email address has changed"}
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,69 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestEmailVerification(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+	if u.EmailVerified() {
+		t.Error("A new user must not be verified")
+	}
+
+	ev := &data.EmailVerification{
+		UserID:    u.ID,
+		Email:     u.Email,
+		ExpiresIn: (24 * time.Hour).Nanoseconds(),
+	}
+	token, err := ev.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" || ev.HashedToken == token {
+		t.Error("Generate must return a token and only keep its hash")
+	}
+	if err := ev.Insert(db); err != nil {
+		t.Error("Failed to insert verification to db: %v", ev)
+	}
+
+	// query for the verification by token
+	ev1 := &data.EmailVerification{}
+	if err := ev1.GetByToken(db, token); err != nil {
+		t.Error("Failed to get verification: ", err)
+	}
+	if ev1.ID != ev.ID || ev1.Expired() {
+		t.Error("Unexpected verification returned: %v", ev1)
+	}
+
+	// verify the email
+	u1 := &data.User{}
+	if err := ev1.Verify(db, u1); err != nil {
+		t.Error("Failed to verify email: ", err)
+	}
+	if u1.ID != u.ID || !u1.EmailVerified() {
+		t.Error("Verify must mark the user's email as verified: %v", u1)
+	}
+
+	// a verification is used only once
+	err = ev1.Verify(db, u1)
+	if e, ok := err.(*data.Error); !ok || e.Code != "token_reused" {
+		t.Error("Verifying twice must return a 'token_reused' error, Got %v", err)
+	}
+
+	db.Close()
+}
@@ -0,0 +1,50 @@
+package data
+
+import "time"
+
+// Tokens store when they were created and how long they live (ExpiresIn, in
+// nanoseconds); their expiry is always derived from the stored row, so a
+// token shortened in the database expires even if its JWT says otherwise.
+
+func expiresAt(createdAt *time.Time, expiresIn int64) time.Time {
+	return createdAt.Add(time.Duration(expiresIn))
+}
+
+// ExpiresAt returns when the token stops being valid.
+func (t *Token) ExpiresAt() time.Time {
+	return expiresAt(t.CreatedAt, t.ExpiresIn)
+}
+
+// ExpiresInSeconds returns the remaining lifetime of the token in whole
+// seconds, as used by the OAuth2 `expires_in` field. It is 0 once expired.
+func (t *Token) ExpiresInSeconds() int64 {
+	d := t.ExpiresAt().Sub(time.Now())
+	if d < 0 {
+		return 0
+	}
+	return int64(d / time.Second)
+}
+
+func (t *Token) Expired() bool {
+	return !time.Now().Before(t.ExpiresAt())
+}
+
+// Validate returns an invalid_token error if the token was revoked or has expired.
+func (t *Token) Validate() error {
+	if t.RevokedAt != nil {
+		return &Error{"invalid_token", "token is not valid"}
+	}
+	if t.Expired() {
+		return &Error{"invalid_token", "token is expired"}
+	}
+	return nil
+}
+
+// ExpiresAt returns when the refresh token stops being valid.
+func (rt *RefreshToken) ExpiresAt() time.Time {
+	return expiresAt(rt.CreatedAt, rt.ExpiresIn)
+}
+
+func (rt *RefreshToken) Expired() bool {
+	return !time.Now().Before(rt.ExpiresAt())
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"time"
 
@@ -9,40 +10,131 @@ import (
 )
 
 type Hub struct {
-	ID        int64      `db:"id" json:"id"`
-	Slug      string     `db:"slug" json:"slug"`
-	UserID    int64      `db:"user_id" json:"user_id"`
-	CreatedAt *time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt *time.Time `db:"updated_at" json:"updated_at"`
+	ID           int64      `db:"id" json:"id"`
+	Slug         string     `db:"slug" json:"slug"`
+	Name         string     `db:"name" json:"name"`
+	Description  string     `db:"description" json:"description"`
+	UserID       int64      `db:"user_id" json:"user_id"`
+	HashedSecret string     `db:"hashed_secret" json:"-"`
+	CreatedAt    *time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    *time.Time `db:"updated_at" json:"updated_at"`
+
+	// as of the last heartbeat (see Heartbeat)
+	LastSeenAt *time.Time `db:"last_seen_at" json:"last_seen_at"`
+	LastSeenIP *string    `db:"last_seen_ip" json:"last_seen_ip"`
+	Uptime     *int64     `db:"uptime" json:"uptime"` // seconds
+	Version    *string    `db:"version" json:"version"`
+	Load       *float64   `db:"load" json:"load"`
+	Presence   string     `db:"presence" json:"-"` // last presence change, online or offline
+
+	Inventory []byte `db:"inventory" json:"-"` // latest inventory document (see Inventory)
+}
+
+type Hubs []Hub
+
+// GenerateSecret sets a new hub credential. Only its hash is kept, so the
+// returned secret must be handed to the hub right away.
+func (h *Hub) GenerateSecret() (string, error) {
+	secret, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+	h.HashedSecret = hashSecret(secret)
+	return secret, nil
+}
+
+func (h *Hub) VerifySecret(secret string) bool {
+	// hubs registered before credentials were issued have none
+	if h.HashedSecret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(h.HashedSecret)) == 1
+}
+
+// RotateSecret sets a new hub credential and revokes the tokens the hub got
+// with the old one. Returns the new plain secret.
+func (h *Hub) RotateSecret(db *sqlx.DB) (string, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := rotateSecret(tx, h)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	return secret, tx.Commit()
 }
 
-type Hubs []string
+func rotateSecret(tx *sqlx.Tx, h *Hub) (string, error) {
+	secret, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Get(h, `UPDATE hubs
+	SET hashed_secret = $2, updated_at = now()
+	WHERE id = $1
+	RETURNING *;
+	`, h.ID, hashSecret(secret))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", &Error{"record_not_found", "hub not found"}
+		}
+		return "", err
+	}
+
+	_, err = tx.Exec("UPDATE tokens SET revoked_at = now() WHERE hub_id = $1 AND revoked_at IS NULL;", h.ID)
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
 
 func (h *Hub) Insert(db *sqlx.DB) error {
-	nstmt, err := db.PrepareNamed(`INSERT INTO hubs 
-	(slug, user_id, created_at, updated_at)
-	VALUES (:slug, :user_id, now(), now())
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if err := claimSlug(tx, h.Slug, h.UserID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	nstmt, err := tx.PrepareNamed(`INSERT INTO hubs 
+	(slug, name, description, user_id, hashed_secret, created_at, updated_at)
+	VALUES (:slug, :name, :description, :user_id, :hashed_secret, now(), now())
 	RETURNING *;
 	`)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 	defer nstmt.Close()
 
 	err = nstmt.QueryRow(h).StructScan(h)
-	if err, ok := err.(*pq.Error); ok {
-		switch err.Code.Name() {
-		case "unique_violation":
-			return &Error{"unique_violation", "hub exists"}
-		default:
-			return &Error{err.Code.Name(), "pq error"}
+	if err != nil {
+		tx.Rollback()
+		if err, ok := err.(*pq.Error); ok {
+			switch err.Code.Name() {
+			case "unique_violation":
+				return &Error{"unique_violation", "hub exists"}
+			default:
+				return &Error{err.Code.Name(), "pq error"}
+			}
 		}
+		return err
 	}
-	return err
+
+	return tx.Commit()
 }
 
-func (h *Hubs) SelectByUserId(db *sqlx.DB, userid int64) error {
-	err := db.Select(h, "SELECT slug FROM hubs WHERE user_id = $1;", userid)
+func (h *Hub) Get(db *sqlx.DB, slug string) error {
+	err := db.Get(h, "SELECT * FROM hubs WHERE slug = $1;", slug)
 	if err, ok := err.(*pq.Error); ok {
 		switch err.Code.Name() {
 		default:
@@ -50,14 +142,14 @@ func (h *Hubs) SelectByUserId(db *sqlx.DB, userid int64) error {
 		}
 	}
 
-	if *h == nil {
+	if err == sql.ErrNoRows {
 		return &Error{"record_not_found", "hub not found"}
 	}
 	return err
 }
 
-func (h *Hub) Get(db *sqlx.DB, slug string) error {
-	err := db.Get(h, "SELECT user_id FROM hubs WHERE slug = $1;", slug)
+func (h *Hub) GetByID(db *sqlx.DB, id int64) error {
+	err := db.Get(h, "SELECT * FROM hubs WHERE id = $1;", id)
 	if err, ok := err.(*pq.Error); ok {
 		switch err.Code.Name() {
 		default:
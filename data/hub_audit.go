@@ -0,0 +1,47 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Hub audit actions.
+const (
+	HubTransferred = "transferred" // details: transfer_id, from_user_id, to_user_id
+)
+
+// HubAuditEvent records a change to a hub and the user who made it. Events
+// are kept after the hub is deleted.
+type HubAuditEvent struct {
+	ID        int64      `db:"id"`
+	HubID     int64      `db:"hub_id"`  // the hub may have been deleted since
+	UserID    *int64     `db:"user_id"` // unset once the user is deleted
+	Action    string     `db:"action"`
+	Details   []byte     `db:"details"` // JSON object, depending on the action
+	CreatedAt *time.Time `db:"created_at"`
+}
+
+func addAuditEvent(tx *sqlx.Tx, hubID, userID int64, action string, details interface{}) error {
+	b, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO hub_audit_events
+	(hub_id, user_id, action, details)
+	VALUES ($1, $2, $3, $4);
+	`, hubID, userID, action, b)
+	return err
+}
+
+// AuditEvents returns the latest audit events of the hub, newest first.
+func (h *Hub) AuditEvents(db *sqlx.DB, limit int) ([]HubAuditEvent, error) {
+	events := []HubAuditEvent{}
+	err := db.Select(&events, "SELECT * FROM hub_audit_events WHERE hub_id = $1 ORDER BY id DESC LIMIT $2;", h.ID, limit)
+	if err, ok := err.(*pq.Error); ok {
+		return nil, &Error{err.Code.Name(), "pq error"}
+	}
+	return events, err
+}
@@ -0,0 +1,265 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Inventory is the hardware and software a hub reports having.
+type Inventory struct {
+	OS          string         `json:"os"`
+	Arch        string         `json:"arch"`
+	HubVersion  string         `json:"hub_version"` // Ripple Hub version, eg: 1.4.2
+	Peripherals []Peripheral   `json:"peripherals"`
+	Apps        []InstalledApp `json:"apps"`
+	Disk        *Capacity      `json:"disk,omitempty"`
+	Memory      *Capacity      `json:"memory,omitempty"`
+}
+
+type Peripheral struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind,omitempty"` // eg: usb, zigbee, bluetooth
+	Vendor string `json:"vendor,omitempty"`
+}
+
+type InstalledApp struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// Capacity is in bytes.
+type Capacity struct {
+	Total int64 `json:"total"`
+	Free  int64 `json:"free"`
+}
+
+// Hub versions are numeric so hubs can be queried by version ranges.
+var hubVersionPattern = regexp.MustCompile(`^[0-9]{1,6}(\.[0-9]{1,6}){0,3}$`)
+
+// ValidHubVersion reports whether v is a Ripple Hub version, eg: 1.4 or 1.4.2.
+func ValidHubVersion(v string) bool {
+	return hubVersionPattern.MatchString(v)
+}
+
+// Validate returns an invalid_inventory error describing the first problem
+// with the inventory.
+func (inv *Inventory) Validate() error {
+	invalid := func(desc string) error {
+		return &Error{"invalid_inventory", desc}
+	}
+
+	if inv.OS == "" || inv.Arch == "" {
+		return invalid("os and arch required")
+	}
+	if !ValidHubVersion(inv.HubVersion) {
+		return invalid("hub_version must be numbers separated by dots, eg: 1.4.2")
+	}
+
+	// peripherals and apps are told apart by name when diffing inventories
+	peripherals := map[string]bool{}
+	for _, p := range inv.Peripherals {
+		if p.Name == "" || peripherals[p.Name] {
+			return invalid("peripherals must have distinct names")
+		}
+		peripherals[p.Name] = true
+	}
+	apps := map[string]bool{}
+	for _, a := range inv.Apps {
+		if a.Name == "" || apps[a.Name] {
+			return invalid("apps must have distinct names")
+		}
+		apps[a.Name] = true
+	}
+
+	for _, c := range []*Capacity{inv.Disk, inv.Memory} {
+		if c != nil && (c.Total < 0 || c.Free < 0 || c.Free > c.Total) {
+			return invalid("free capacity must be between 0 and the total capacity")
+		}
+	}
+	return nil
+}
+
+// HubInventory is an inventory reported by a hub. Versions are numbered
+// from 1 for each hub.
+type HubInventory struct {
+	ID        int64      `db:"id"`
+	HubID     int64      `db:"hub_id"`
+	Version   int64      `db:"version"`
+	Document  []byte     `db:"document"` // Inventory as JSON
+	Changes   []byte     `db:"changes"`  // []InventoryChange as JSON
+	CreatedAt *time.Time `db:"created_at"`
+}
+
+// InventoryChange is a value that differs between two inventories.
+// Paths are like apps[zwave].version; array items are named by their name.
+type InventoryChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"` // added, removed or changed
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// ReportInventory records the inventory reported by the hub, along with the
+// changes from the previous one. Reporting the same inventory again records
+// nothing and returns the latest version.
+func (h *Hub) ReportInventory(db *sqlx.DB, inv *Inventory) (*HubInventory, error) {
+	doc, err := json.Marshal(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	var prev []byte
+	if err := tx.Get(&prev, "SELECT inventory FROM hubs WHERE id = $1 FOR UPDATE;", h.ID); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, &Error{"record_not_found", "hub not found"}
+		}
+		return nil, err
+	}
+
+	changes, err := diffInventories(prev, doc)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	hi := &HubInventory{}
+	if prev != nil && len(changes) == 0 {
+		err := tx.Get(hi, "SELECT * FROM hub_inventories WHERE hub_id = $1 ORDER BY version DESC LIMIT 1;", h.ID)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		return hi, tx.Commit()
+	}
+
+	changesDoc, err := json.Marshal(changes)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Get(hi, `INSERT INTO hub_inventories
+	(hub_id, version, document, changes)
+	SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3 FROM hub_inventories WHERE hub_id = $1
+	RETURNING *;
+	`, h.ID, doc, changesDoc)
+	if err != nil {
+		tx.Rollback()
+		if err, ok := err.(*pq.Error); ok {
+			return nil, &Error{err.Code.Name(), "pq error"}
+		}
+		return nil, err
+	}
+
+	if _, err := tx.Exec("UPDATE hubs SET inventory = $2 WHERE id = $1;", h.ID, doc); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	h.Inventory = doc
+
+	return hi, tx.Commit()
+}
+
+// GetInventory gets the given version of the hub's inventory, or the latest
+// one if version is 0.
+func (h *Hub) GetInventory(db *sqlx.DB, version int64) (*HubInventory, error) {
+	hi := &HubInventory{}
+	err := db.Get(hi, `SELECT * FROM hub_inventories
+	WHERE hub_id = $1 AND ($2 = 0 OR version = $2)
+	ORDER BY version DESC LIMIT 1;
+	`, h.ID, version)
+	if err, ok := err.(*pq.Error); ok {
+		return nil, &Error{err.Code.Name(), "pq error"}
+	}
+
+	if err == sql.ErrNoRows {
+		return nil, &Error{"record_not_found", "inventory not found"}
+	}
+	return hi, err
+}
+
+// InventoryHistory returns the latest inventories of the hub, newest first.
+func (h *Hub) InventoryHistory(db *sqlx.DB, limit int) ([]HubInventory, error) {
+	history := []HubInventory{}
+	err := db.Select(&history, "SELECT * FROM hub_inventories WHERE hub_id = $1 ORDER BY version DESC LIMIT $2;", h.ID, limit)
+	if err, ok := err.(*pq.Error); ok {
+		return nil, &Error{err.Code.Name(), "pq error"}
+	}
+	return history, err
+}
+
+// diffInventories returns the changes between two inventory documents.
+// A nil old document is empty.
+func diffInventories(old, new []byte) ([]InventoryChange, error) {
+	before := map[string]interface{}{}
+	if old != nil {
+		var v interface{}
+		if err := json.Unmarshal(old, &v); err != nil {
+			return nil, err
+		}
+		flatten("", v, before)
+	}
+
+	after := map[string]interface{}{}
+	var v interface{}
+	if err := json.Unmarshal(new, &v); err != nil {
+		return nil, err
+	}
+	flatten("", v, after)
+
+	changes := []InventoryChange{}
+	for path, o := range before {
+		n, ok := after[path]
+		switch {
+		case !ok:
+			changes = append(changes, InventoryChange{path, "removed", o, nil})
+		case !reflect.DeepEqual(o, n):
+			changes = append(changes, InventoryChange{path, "changed", o, n})
+		}
+	}
+	for path, n := range after {
+		if _, ok := before[path]; !ok {
+			changes = append(changes, InventoryChange{path, "added", nil, n})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// flatten sets the scalar values of v in values by their path.
+func flatten(path string, v interface{}, values map[string]interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			flatten(strings.TrimPrefix(path+"."+k, "."), item, values)
+		}
+	case []interface{}:
+		for i, item := range v {
+			key := fmt.Sprint(i)
+			if m, ok := item.(map[string]interface{}); ok {
+				if name, ok := m["name"].(string); ok {
+					key = name
+				}
+			}
+			flatten(path+"["+key+"]", item, values)
+		}
+	default:
+		values[path] = v
+	}
+}
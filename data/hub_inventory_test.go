@@ -0,0 +1,140 @@
+package data_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestInventoryValidate(t *testing.T) {
+	type testCase struct {
+		inv   data.Inventory
+		valid bool
+	}
+
+	tCases := []testCase{
+		{data.Inventory{OS: "linux", Arch: "arm64", HubVersion: "1.4.2"}, true},
+		{data.Inventory{OS: "linux", Arch: "arm64", HubVersion: "1"}, true},
+		{data.Inventory{Arch: "arm64", HubVersion: "1.4.2"}, false},
+		{data.Inventory{OS: "linux", Arch: "arm64", HubVersion: "1.4-beta"}, false},
+		{data.Inventory{OS: "linux", Arch: "arm64", HubVersion: "1.4", Apps: []data.InstalledApp{{Name: "zwave"}, {Name: "zwave"}}}, false},
+		{data.Inventory{OS: "linux", Arch: "arm64", HubVersion: "1.4", Peripherals: []data.Peripheral{{Kind: "usb"}}}, false},
+		{data.Inventory{OS: "linux", Arch: "arm64", HubVersion: "1.4", Disk: &data.Capacity{Total: 10, Free: 20}}, false},
+	}
+
+	for _, tc := range tCases {
+		if err := tc.inv.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%+v - Expected valid %v, Got %v", tc.inv, tc.valid, err)
+		}
+	}
+}
+
+func TestReportInventory(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	h := &data.Hub{
+		Slug:   "earthworm",
+		UserID: u.ID,
+	}
+	if err := h.Insert(db); err != nil {
+		t.Fatal("Failed to insert hub to db: ", err)
+	}
+
+	inv := &data.Inventory{
+		OS:         "linux",
+		Arch:       "arm64",
+		HubVersion: "1.3.9",
+		Apps:       []data.InstalledApp{{"zwave", "2.0"}, {"lights", "1.1"}},
+	}
+	hi, err := h.ReportInventory(db, inv)
+	if err != nil {
+		t.Fatal("Failed to report inventory: ", err)
+	}
+	if hi.Version != 1 {
+		t.Errorf("Expected version 1, Got %d", hi.Version)
+	}
+
+	// reporting the same inventory records nothing
+	if hi, err = h.ReportInventory(db, inv); err != nil {
+		t.Fatal("Failed to report inventory: ", err)
+	}
+	if hi.Version != 1 {
+		t.Errorf("Expected version 1, Got %d", hi.Version)
+	}
+
+	// changes are recorded by path, apps by name
+	inv.HubVersion = "1.10"
+	inv.Apps = []data.InstalledApp{{"lights", "1.1"}, {"zwave", "2.1"}}
+	if hi, err = h.ReportInventory(db, inv); err != nil {
+		t.Fatal("Failed to report inventory: ", err)
+	}
+	changes := []data.InventoryChange{}
+	if err := json.Unmarshal(hi.Changes, &changes); err != nil {
+		t.Fatal(err)
+	}
+	expected := []data.InventoryChange{
+		{"apps[zwave].version", "changed", "2.0", "2.1"},
+		{"hub_version", "changed", "1.3.9", "1.10"},
+	}
+	if hi.Version != 2 || len(changes) != len(expected) {
+		t.Fatalf("Unexpected inventory version %d with changes %s", hi.Version, hi.Changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected change %+v, Got %+v", expected[i], changes[i])
+		}
+	}
+
+	history, err := h.InventoryHistory(db, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Version != 2 {
+		t.Errorf("Unexpected inventory history %+v", history)
+	}
+	if _, err := h.GetInventory(db, 3); err == nil {
+		t.Error("Expected an error getting a version that was not reported")
+	}
+
+	// hubs are filtered by their latest inventory; versions compare by number
+	type testCase struct {
+		q     data.HubQuery
+		found bool
+	}
+
+	tCases := []testCase{
+		{data.HubQuery{Arch: "arm64", HubVersionBelow: "1.4"}, false},
+		{data.HubQuery{Arch: "arm64", HubVersionAtLeast: "1.4"}, true},
+		{data.HubQuery{OS: "linux", App: "zwave"}, true},
+		{data.HubQuery{Arch: "amd64"}, false},
+		{data.HubQuery{App: "sprinklers"}, false},
+	}
+	for _, tc := range tCases {
+		tc.q.UserID, tc.q.Limit = u.ID, 10
+		hubs := data.Hubs{}
+		if err := hubs.Select(db, tc.q); err != nil {
+			t.Fatal(err)
+		}
+		if found := len(hubs) == 1; found != tc.found {
+			t.Errorf("%+v - Expected found %v, Got %v", tc.q, tc.found, found)
+		}
+	}
+
+	hubs := data.Hubs{}
+	if err := hubs.Select(db, data.HubQuery{UserID: u.ID, HubVersionBelow: "1.4; --", Limit: 10}); err == nil {
+		t.Error("Expected an error for an invalid hub version")
+	}
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Hub statuses, derived from their last heartbeat.
+const (
+	HubOnline  = "online"
+	HubStale   = "stale" // missed heartbeats, but not for long enough to be offline
+	HubOffline = "offline"
+)
+
+// PresenceThresholds tells how long after its last heartbeat a hub is stale,
+// then offline.
+type PresenceThresholds struct {
+	StaleAfter   time.Duration
+	OfflineAfter time.Duration
+}
+
+// HubPresence are the thresholds hub statuses are derived with.
+var HubPresence = PresenceThresholds{StaleAfter: 2 * time.Minute, OfflineAfter: 10 * time.Minute}
+
+// Heartbeat is what a hub reports about itself every so often.
+type Heartbeat struct {
+	IP      string
+	Uptime  *int64 // seconds
+	Version *string
+	Load    *float64
+}
+
+// PresenceEvent records a hub going online or offline.
+type PresenceEvent struct {
+	ID        int64      `db:"id" json:"id"`
+	HubID     int64      `db:"hub_id" json:"hub_id"`
+	Status    string     `db:"status" json:"status"`
+	CreatedAt *time.Time `db:"created_at" json:"created_at"`
+}
+
+// Status returns whether the hub is online, stale or offline.
+func (h *Hub) Status() string {
+	if h.LastSeenAt == nil {
+		return HubOffline
+	}
+	switch since := time.Since(*h.LastSeenAt); {
+	case since < HubPresence.StaleAfter:
+		return HubOnline
+	case since < HubPresence.OfflineAfter:
+		return HubStale
+	default:
+		return HubOffline
+	}
+}
+
+// Heartbeat records that the hub is alive. A hub that was offline goes
+// online, which is recorded as a presence event.
+func (h *Hub) Heartbeat(db *sqlx.DB, hb Heartbeat) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	var was string
+	if err := tx.Get(&was, "SELECT presence FROM hubs WHERE id = $1 FOR UPDATE;", h.ID); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return &Error{"record_not_found", "hub not found"}
+		}
+		return err
+	}
+
+	err = tx.Get(h, `UPDATE hubs
+	SET last_seen_at = now(), last_seen_ip = $2, uptime = $3, version = $4, load = $5, presence = $6
+	WHERE id = $1
+	RETURNING *;
+	`, h.ID, hb.IP, hb.Uptime, hb.Version, hb.Load, HubOnline)
+	if err != nil {
+		tx.Rollback()
+		if err, ok := err.(*pq.Error); ok {
+			return &Error{err.Code.Name(), "pq error"}
+		}
+		return err
+	}
+
+	if was != HubOnline {
+		_, err = tx.Exec("INSERT INTO hub_presence_events (hub_id, status) VALUES ($1, $2);", h.ID, HubOnline)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MarkOfflineHubs records that the hubs last seen online more than
+// HubPresence.OfflineAfter ago went offline. Returns the presence events
+// recorded.
+func MarkOfflineHubs(db *sqlx.DB) ([]PresenceEvent, error) {
+	events := []PresenceEvent{}
+	err := db.Select(&events, `WITH offline AS (
+		UPDATE hubs SET presence = $1
+		WHERE presence = $2 AND last_seen_at <= now() - $3::float8 * interval '1 second'
+		RETURNING id
+	)
+	INSERT INTO hub_presence_events (hub_id, status)
+	SELECT id, $1 FROM offline
+	RETURNING *;
+	`, HubOffline, HubOnline, HubPresence.OfflineAfter.Seconds())
+	if err, ok := err.(*pq.Error); ok {
+		return nil, &Error{err.Code.Name(), "pq error"}
+	}
+	return events, err
+}
+
+// PresenceEvents returns the latest presence events of the hub, newest first.
+func (h *Hub) PresenceEvents(db *sqlx.DB, limit int) ([]PresenceEvent, error) {
+	events := []PresenceEvent{}
+	err := db.Select(&events, "SELECT * FROM hub_presence_events WHERE hub_id = $1 ORDER BY id DESC LIMIT $2;", h.ID, limit)
+	if err, ok := err.(*pq.Error); ok {
+		return nil, &Error{err.Code.Name(), "pq error"}
+	}
+	return events, err
+}
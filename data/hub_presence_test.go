@@ -0,0 +1,99 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestHubStatus(t *testing.T) {
+	ago := func(d time.Duration) *time.Time {
+		t := time.Now().Add(-d)
+		return &t
+	}
+
+	type testCase struct {
+		lastSeenAt *time.Time
+		status     string
+	}
+
+	tCases := []testCase{
+		{nil, data.HubOffline},
+		{ago(time.Second), data.HubOnline},
+		{ago(data.HubPresence.StaleAfter + time.Second), data.HubStale},
+		{ago(data.HubPresence.OfflineAfter + time.Second), data.HubOffline},
+	}
+
+	for _, tc := range tCases {
+		h := &data.Hub{LastSeenAt: tc.lastSeenAt}
+		if s := h.Status(); s != tc.status {
+			t.Errorf("%v - Expected status %s, Got %s", tc.lastSeenAt, tc.status, s)
+		}
+	}
+}
+
+func TestHubHeartbeat(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	h := &data.Hub{
+		Slug:   "earthworm",
+		UserID: u.ID,
+	}
+	if err := h.Insert(db); err != nil {
+		t.Fatal("Failed to insert hub to db: ", err)
+	}
+
+	// heartbeats only record going online once
+	version := "1.3.2"
+	for i := 0; i < 2; i++ {
+		if err := h.Heartbeat(db, data.Heartbeat{IP: "203.0.113.7", Version: &version}); err != nil {
+			t.Fatal("Failed to record heartbeat: ", err)
+		}
+	}
+	if h.Status() != data.HubOnline || h.Version == nil || *h.Version != version {
+		t.Errorf("Unexpected hub %+v", h)
+	}
+
+	// the hub is marked offline once it missed heartbeats for long enough
+	events, err := data.MarkOfflineHubs(db)
+	if err != nil {
+		t.Fatal("Failed to mark offline hubs: ", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no hub to go offline, Got %+v", events)
+	}
+
+	thresholds := data.HubPresence
+	data.HubPresence.OfflineAfter = 0
+	events, err = data.MarkOfflineHubs(db)
+	data.HubPresence = thresholds
+	if err != nil {
+		t.Fatal("Failed to mark offline hubs: ", err)
+	}
+	if len(events) != 1 || events[0].HubID != h.ID || events[0].Status != data.HubOffline {
+		t.Errorf("Expected the hub to go offline, Got %+v", events)
+	}
+
+	events, err = h.PresenceEvents(db, 10)
+	if err != nil {
+		t.Fatal("Failed to get presence events: ", err)
+	}
+	if len(events) != 2 || events[0].Status != data.HubOffline || events[1].Status != data.HubOnline {
+		t.Errorf("Unexpected presence events %+v", events)
+	}
+
+	db.Close()
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// HubSorts lists the orders hubs can be listed in. A leading - sorts in
+// descending order.
+var HubSorts = []string{"created_at", "-created_at", "slug", "-slug"}
+
+// HubQuery selects a page of a user's hubs. Pages are keyed by a cursor
+// rather than an offset, so hubs added meanwhile don't shift them.
+type HubQuery struct {
+	UserID int64
+	Search string     // only hubs whose slug contains it, ignoring case
+	Sort   string     // one of HubSorts; defaults to created_at
+	After  *HubCursor // only hubs after this one in the sort order
+	Limit  int
+
+	// filters on the latest inventory the hubs reported (see Inventory)
+	OS                string
+	Arch              string
+	HubVersionBelow   string // only hubs running an older Ripple Hub version
+	HubVersionAtLeast string
+	App               string // only hubs with this app installed
+}
+
+// HubCursor points at the last hub of a page.
+type HubCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"` // the hub's value of the sort column
+	ID    int64  `json:"id"`
+}
+
+// cursorTime is how timestamps are kept in cursors. It has no time zone, as
+// timestamps in the database.
+const cursorTime = "2006-01-02T15:04:05.999999"
+
+// NewHubCursor returns the cursor pointing at the hub in the given order.
+func NewHubCursor(h *Hub, sort string) *HubCursor {
+	c := &HubCursor{Sort: sort, ID: h.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case "slug":
+		c.Value = h.Slug
+	default:
+		c.Value = h.CreatedAt.Format(cursorTime)
+	}
+	return c
+}
+
+// Encode returns the cursor as an opaque string.
+func (c *HubCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeHubCursor reads a cursor returned by Encode.
+func DecodeHubCursor(s string) (*HubCursor, error) {
+	invalid := &Error{"invalid_cursor", "cursor is not valid"}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalid
+	}
+	c := &HubCursor{}
+	if err := json.Unmarshal(b, c); err != nil || !validHubSort(c.Sort) {
+		return nil, invalid
+	}
+	if strings.TrimPrefix(c.Sort, "-") == "created_at" {
+		if _, err := time.Parse(cursorTime, c.Value); err != nil {
+			return nil, invalid
+		}
+	}
+	return c, nil
+}
+
+func validHubSort(sort string) bool {
+	for _, s := range HubSorts {
+		if s == sort {
+			return true
+		}
+	}
+	return false
+}
+
+// Select selects the hubs matching the query.
+func (h *Hubs) Select(db *sqlx.DB, q HubQuery) error {
+	if q.Sort == "" {
+		q.Sort = HubSorts[0]
+	}
+	if !validHubSort(q.Sort) {
+		return &Error{"invalid_sort", "sort must be one of " + strings.Join(HubSorts, ", ")}
+	}
+	for _, v := range []string{q.HubVersionBelow, q.HubVersionAtLeast} {
+		if v != "" && !ValidHubVersion(v) {
+			return &Error{"invalid_hub_version", "hub version must be numbers separated by dots, eg: 1.4.2"}
+		}
+	}
+	if q.After != nil && q.After.Sort != q.Sort {
+		return &Error{"invalid_cursor", "cursor is not valid for this sort"}
+	}
+
+	// the column comes from HubSorts, never from the request as is
+	column, order, cmp, cast := strings.TrimPrefix(q.Sort, "-"), "ASC", ">", "text"
+	if strings.HasPrefix(q.Sort, "-") {
+		order, cmp = "DESC", "<"
+	}
+	if column == "created_at" {
+		cast = "timestamp"
+	}
+
+	where := []string{"user_id = $1"}
+	args := []interface{}{q.UserID}
+	if q.Search != "" {
+		args = append(args, "%"+escapeLike(q.Search)+"%")
+		where = append(where, fmt.Sprintf("slug ILIKE $%d", len(args)))
+	}
+	for _, f := range []struct{ value, cond string }{
+		{q.OS, "inventory->>'os' = $%d"},
+		{q.Arch, "inventory->>'arch' = $%d"},
+		{q.HubVersionBelow, hubVersionArray("inventory->>'hub_version'") + " < " + hubVersionArray("$%d")},
+		{q.HubVersionAtLeast, hubVersionArray("inventory->>'hub_version'") + " >= " + hubVersionArray("$%d")},
+	} {
+		if f.value != "" {
+			args = append(args, f.value)
+			where = append(where, fmt.Sprintf(f.cond, len(args)))
+		}
+	}
+	if q.App != "" {
+		app, _ := json.Marshal([]InstalledApp{{Name: q.App}})
+		args = append(args, string(app))
+		where = append(where, fmt.Sprintf("inventory->'apps' @> $%d::jsonb", len(args)))
+	}
+	if q.After != nil {
+		args = append(args, q.After.Value, q.After.ID)
+		where = append(where, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)", column, cmp, len(args)-1, cast, len(args)))
+	}
+	args = append(args, q.Limit)
+
+	query := fmt.Sprintf("SELECT * FROM hubs WHERE %s ORDER BY %s %s, id %s LIMIT $%d;",
+		strings.Join(where, " AND "), column, order, order, len(args))
+
+	*h = Hubs{}
+	err := db.Select(h, query, args...)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	return err
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// hubVersionArray returns the SQL comparing a hub version by its numbers,
+// so that 1.10 comes after 1.9.
+func hubVersionArray(expr string) string {
+	return "string_to_array(" + expr + ", '.')::int[]"
+}
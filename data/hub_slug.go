@@ -0,0 +1,110 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// A renamed hub keeps its old slug for a while (see Hub.Update): the slug
+// still resolves to the hub (see GetByOldSlug) and only its owner can use it
+// again.
+
+// claimSlug makes sure the slug is not held for a hub of another user, and
+// releases it if it is held for one of the user's hubs.
+func claimSlug(tx *sqlx.Tx, slug string, userID int64) error {
+	var holder int64
+	err := tx.Get(&holder, "SELECT user_id FROM hub_slugs WHERE slug = $1 AND expires_at > now();", slug)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && holder != userID {
+		return &Error{"unique_violation", "hub exists"}
+	}
+
+	_, err = tx.Exec("DELETE FROM hub_slugs WHERE slug = $1;", slug)
+	return err
+}
+
+// GetByOldSlug gets the hub that was renamed from the slug, as long as the
+// slug is held for it.
+func (h *Hub) GetByOldSlug(db *sqlx.DB, slug string) error {
+	err := db.Get(h, `SELECT hubs.* FROM hubs
+	JOIN hub_slugs ON hub_slugs.hub_id = hubs.id
+	WHERE hub_slugs.slug = $1 AND hub_slugs.expires_at > now();
+	`, slug)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "hub not found"}
+	}
+	return err
+}
+
+// Update saves the hub's slug, name and description. If the slug changed,
+// the old one is held for the hub for hold.
+func (h *Hub) Update(db *sqlx.DB, hold time.Duration) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	var oldSlug string
+	if err := tx.Get(&oldSlug, "SELECT slug FROM hubs WHERE id = $1 FOR UPDATE;", h.ID); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return &Error{"record_not_found", "hub not found"}
+		}
+		return err
+	}
+
+	if h.Slug != oldSlug {
+		if err := claimSlug(tx, h.Slug, h.UserID); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO hub_slugs
+		(slug, hub_id, user_id, expires_at)
+		VALUES ($1, $2, $3, now() + $4::float8 * interval '1 second');
+		`, oldSlug, h.ID, h.UserID, hold.Seconds())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	nstmt, err := tx.PrepareNamed(`UPDATE hubs
+	SET slug = :slug, name = :name, description = :description, updated_at = now()
+	WHERE id = :id
+	RETURNING *;
+	`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer nstmt.Close()
+
+	err = nstmt.QueryRow(h).StructScan(h)
+	if err != nil {
+		tx.Rollback()
+		if err, ok := err.(*pq.Error); ok {
+			switch err.Code.Name() {
+			case "unique_violation":
+				return &Error{"unique_violation", "hub exists"}
+			default:
+				return &Error{err.Code.Name(), "pq error"}
+			}
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,92 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestHubUpdate(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new users
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+	u2 := &data.User{
+		Username:          "brucelee",
+		Email:             "gmail@brucelee.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u2.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u2)
+	}
+
+	h := &data.Hub{
+		Slug:   "earthworm",
+		UserID: u.ID,
+	}
+	if err := h.Insert(db); err != nil {
+		t.Fatal("Failed to insert hub to db: ", err)
+	}
+	updatedAt := *h.UpdatedAt
+
+	// rename the hub
+	h.Slug, h.Name, h.Description = "caterpillar", "Caterpillar", "In the garden"
+	if err := h.Update(db, time.Hour); err != nil {
+		t.Fatal("Failed to update hub: ", err)
+	}
+	if h.Slug != "caterpillar" || h.Name != "Caterpillar" || h.Description != "In the garden" || !h.UpdatedAt.After(updatedAt) {
+		t.Errorf("Unexpected hub %+v", h)
+	}
+
+	// the old slug still resolves to the hub
+	h1 := &data.Hub{}
+	if err := h1.GetByOldSlug(db, "earthworm"); err != nil || h1.ID != h.ID {
+		t.Error("Failed to get hub by old slug: ", err)
+	}
+
+	// another user can't take the old slug
+	h2 := &data.Hub{
+		Slug:   "earthworm",
+		UserID: u2.ID,
+	}
+	err := h2.Insert(db)
+	if e, ok := err.(*data.Error); !ok || e.Code != "unique_violation" {
+		t.Errorf("Expected a unique_violation error, Got %v", err)
+	}
+
+	// the owner can take it back, which releases it
+	h.Slug = "earthworm"
+	if err := h.Update(db, time.Hour); err != nil {
+		t.Fatal("Failed to update hub: ", err)
+	}
+	if err := h1.GetByOldSlug(db, "earthworm"); err == nil {
+		t.Error("Expected the old slug to be released")
+	}
+	if err := h1.GetByOldSlug(db, "caterpillar"); err != nil || h1.ID != h.ID {
+		t.Error("Failed to get hub by old slug: ", err)
+	}
+
+	// once the hold is over, the slug is free
+	h.Slug = "butterfly"
+	if err := h.Update(db, 0); err != nil {
+		t.Fatal("Failed to update hub: ", err)
+	}
+	if err := h1.GetByOldSlug(db, "earthworm"); err == nil {
+		t.Error("Expected the old slug to have expired")
+	}
+	if err := h2.Insert(db); err != nil {
+		t.Error("Failed to insert hub with a released slug: ", err)
+	}
+
+	db.Close()
+}
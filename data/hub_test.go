@@ -1,7 +1,9 @@
 package data_test
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ripple-cloud/cloud/data"
 	"github.com/ripple-cloud/cloud/testhelpers"
@@ -66,7 +68,7 @@ func TestHubInsert(t *testing.T) {
 	db.Close()
 }
 
-func TestHubSelectByUserId(t *testing.T) {
+func TestHubSelect(t *testing.T) {
 	// setup database
 	db := testhelpers.SetupDB(t)
 
@@ -80,44 +82,110 @@ func TestHubSelectByUserId(t *testing.T) {
 		t.Error("Failed to insert user to db: %v", u)
 	}
 
-	h := &data.Hub{
-		Slug:   "earthworm",
-		UserID: 1,
+	for _, slug := range []string{"earthworm", "caterpillar", "earwig", "ant"} {
+		h := &data.Hub{
+			Slug:   slug,
+			UserID: u.ID,
+		}
+		if err := h.Insert(db); err != nil {
+			t.Error("Failed to insert h to db: %v", h)
+		}
 	}
-	if err := h.Insert(db); err != nil {
-		t.Error("Failed to insert h to db: %v", h)
+
+	slugs := func(h data.Hubs) string {
+		s := []string{}
+		for _, hub := range h {
+			s = append(s, hub.Slug)
+		}
+		return strings.Join(s, " ")
 	}
 
-	// query for the inserted hub by userid
+	// page through the hubs by slug, 3 at a time
 	var h1 data.Hubs
-	if err := h1.SelectByUserId(db, 1); err != nil {
-		t.Error("Failed to get hubs with userid: 1")
+	if err := h1.Select(db, data.HubQuery{UserID: u.ID, Sort: "slug", Limit: 3}); err != nil {
+		t.Fatal("Failed to select hubs: ", err)
 	}
-	if h1[0] != h.Slug {
-		t.Error("Unexpected user record returned: %v", h1)
+	if s := slugs(h1); s != "ant caterpillar earthworm" {
+		t.Errorf("Unexpected first page %s", s)
+	}
+	cursor, err := data.DecodeHubCursor(data.NewHubCursor(&h1[2], "slug").Encode())
+	if err != nil {
+		t.Fatal("Failed to decode cursor: ", err)
+	}
+	if err := h1.Select(db, data.HubQuery{UserID: u.ID, Sort: "slug", After: cursor, Limit: 3}); err != nil {
+		t.Fatal("Failed to select hubs: ", err)
+	}
+	if s := slugs(h1); s != "earwig" {
+		t.Errorf("Unexpected second page %s", s)
 	}
 
-	// query for a non-existing hub
+	// page through the hubs by creation, newest first
+	if err := h1.Select(db, data.HubQuery{UserID: u.ID, Sort: "-created_at", Limit: 2}); err != nil {
+		t.Fatal("Failed to select hubs: ", err)
+	}
+	if s := slugs(h1); s != "ant earwig" {
+		t.Errorf("Unexpected first page %s", s)
+	}
+	cursor, err = data.DecodeHubCursor(data.NewHubCursor(&h1[1], "-created_at").Encode())
+	if err != nil {
+		t.Fatal("Failed to decode cursor: ", err)
+	}
+	if err := h1.Select(db, data.HubQuery{UserID: u.ID, Sort: "-created_at", After: cursor, Limit: 2}); err != nil {
+		t.Fatal("Failed to select hubs: ", err)
+	}
+	if s := slugs(h1); s != "caterpillar earthworm" {
+		t.Errorf("Unexpected second page %s", s)
+	}
+
+	// filter the hubs by slug
+	if err := h1.Select(db, data.HubQuery{UserID: u.ID, Search: "EAR", Limit: 10}); err != nil {
+		t.Fatal("Failed to select hubs: ", err)
+	}
+	if s := slugs(h1); s != "earthworm earwig" {
+		t.Errorf("Unexpected hubs %s", s)
+	}
+
+	// a user without hubs has none
 	var h2 data.Hubs
-	err := h2.SelectByUserId(db, 9999)
-	if err == nil {
-		t.Error("Get should return an error")
+	if err := h2.Select(db, data.HubQuery{UserID: 9999, Limit: 10}); err != nil {
+		t.Error("Select should not return an error: ", err)
 	}
-	e, ok := err.(*data.Error)
-	if !ok {
-		t.Error("Returned error must be of type `data.Error`")
+	if h2 == nil || len(h2) != 0 {
+		t.Errorf("Expected an empty list, Got %v", h2)
 	}
-	if e.Code != "record_not_found" {
-		t.Error("Error code must be 'record_not_found' but received %s", e.Code)
+
+	// a cursor only works with the sort it was made for
+	if err := h2.Select(db, data.HubQuery{UserID: u.ID, Sort: "slug", After: cursor, Limit: 10}); err == nil {
+		t.Error("Expected the cursor to be rejected")
 	}
-	if e.Desc != "hub not found" {
-		t.Error("Error desc must be 'user not found' but received %s", e.Desc)
+	if err := h2.Select(db, data.HubQuery{UserID: u.ID, Sort: "user_id", Limit: 10}); err == nil {
+		t.Error("Expected the sort to be rejected")
 	}
 
-	// TODO: Add a test case of other errors (eg: db already closed)
 	db.Close()
 }
 
+func TestDecodeHubCursor(t *testing.T) {
+	now := time.Now()
+	c := data.NewHubCursor(&data.Hub{ID: 1, Slug: "x", CreatedAt: &now}, "-created_at")
+	if d, err := data.DecodeHubCursor(c.Encode()); err != nil || *d != *c {
+		t.Errorf("Expected %+v, Got %+v (%v)", c, d, err)
+	}
+
+	invalid := []string{
+		"",
+		"not base64!",
+		"e30", // {}
+		(&data.HubCursor{Sort: "user_id", Value: "1", ID: 1}).Encode(),
+		(&data.HubCursor{Sort: "created_at", Value: "yesterday", ID: 1}).Encode(),
+	}
+	for _, s := range invalid {
+		if _, err := data.DecodeHubCursor(s); err == nil {
+			t.Errorf("%q - Expected the cursor to be rejected", s)
+		}
+	}
+}
+
 func TestHubGet(t *testing.T) {
 	// setup database
 	db := testhelpers.SetupDB(t)
@@ -228,3 +296,53 @@ func TestHubDelete(t *testing.T) {
 	//TODO: Add a test case of other errors (eg: db already closed)
 	db.Close()
 }
+
+func TestHubSecret(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	h := &data.Hub{
+		Slug:   "earthworm",
+		UserID: u.ID,
+	}
+	secret, err := h.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == "" || h.HashedSecret == secret {
+		t.Error("GenerateSecret must return a secret and only keep its hash")
+	}
+	if err := h.Insert(db); err != nil {
+		t.Error("Failed to insert hub to db: %v", h)
+	}
+
+	// query for the inserted hub by id
+	h1 := &data.Hub{}
+	if err := h1.GetByID(db, h.ID); err != nil {
+		t.Error("Failed to get hub with id: ", h.ID)
+	}
+	if !h1.VerifySecret(secret) {
+		t.Error("Expected VerifySecret to return true")
+	}
+	if h1.VerifySecret("such-secret-very-secure") {
+		t.Error("Expected VerifySecret to return false")
+	}
+
+	// hubs without a credential can't authenticate
+	h2 := &data.Hub{}
+	if h2.VerifySecret("") {
+		t.Error("Expected VerifySecret to return false for a hub without a secret")
+	}
+
+	db.Close()
+}
@@ -0,0 +1,261 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// HubTransfer is an offer from the owner of a hub to hand it over to another
+// user. The recipient accepts or declines it before it expires, and the
+// owner can cancel it meanwhile.
+type HubTransfer struct {
+	ID          int64      `db:"id"`
+	HubID       int64      `db:"hub_id"`
+	FromUserID  int64      `db:"from_user_id"`
+	ToUserID    int64      `db:"to_user_id"`
+	ExpiresIn   int64      `db:"expires_in"` // nanoseconds
+	CreatedAt   *time.Time `db:"created_at"`
+	AcceptedAt  *time.Time `db:"accepted_at"`
+	DeclinedAt  *time.Time `db:"declined_at"`
+	CancelledAt *time.Time `db:"cancelled_at"`
+}
+
+// PendingHubTransfer is a transfer along with the names of the hub and
+// users involved.
+type PendingHubTransfer struct {
+	HubTransfer
+	HubSlug      string `db:"hub_slug"`
+	FromUsername string `db:"from_username"`
+	ToUsername   string `db:"to_username"`
+}
+
+type PendingHubTransfers []PendingHubTransfer
+
+// ExpiresAt returns when the offer stops being valid.
+func (ht *HubTransfer) ExpiresAt() time.Time {
+	return expiresAt(ht.CreatedAt, ht.ExpiresIn)
+}
+
+func (ht *HubTransfer) Expired() bool {
+	return !time.Now().Before(ht.ExpiresAt())
+}
+
+func (ht *HubTransfer) handled() bool {
+	return ht.AcceptedAt != nil || ht.DeclinedAt != nil || ht.CancelledAt != nil
+}
+
+// Validate returns a transfer_handled error if the offer was already
+// accepted, declined or cancelled, or a transfer_expired error if it expired.
+func (ht *HubTransfer) Validate() error {
+	if ht.handled() {
+		return &Error{"transfer_handled", "hub transfer was already handled"}
+	}
+	if ht.Expired() {
+		return &Error{"transfer_expired", "hub transfer has expired"}
+	}
+	return nil
+}
+
+// Insert offers the hub, cancelling any offer still pending for it.
+func (ht *HubTransfer) Insert(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE hub_transfers
+	SET cancelled_at = now()
+	WHERE hub_id = $1 AND accepted_at IS NULL AND declined_at IS NULL AND cancelled_at IS NULL;
+	`, ht.HubID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	nstmt, err := tx.PrepareNamed(`INSERT INTO hub_transfers
+	(hub_id, from_user_id, to_user_id, expires_in, created_at)
+	VALUES (:hub_id, :from_user_id, :to_user_id, :expires_in, now())
+	RETURNING *;
+	`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer nstmt.Close()
+
+	err = nstmt.QueryRow(ht).StructScan(ht)
+	if err != nil {
+		tx.Rollback()
+		if err, ok := err.(*pq.Error); ok {
+			switch err.Code.Name() {
+			default:
+				return &Error{err.Code.Name(), "pq error"}
+			}
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ht *HubTransfer) Get(db *sqlx.DB, id int64) error {
+	err := db.Get(ht, "SELECT * FROM hub_transfers WHERE id = $1;", id)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "hub transfer not found"}
+	}
+	return err
+}
+
+// SelectByUserID selects the pending offers made to or by the user, most
+// recently created first.
+func (p *PendingHubTransfers) SelectByUserID(db *sqlx.DB, userID int64) error {
+	all := PendingHubTransfers{}
+	err := db.Select(&all, `SELECT hub_transfers.*, hubs.slug AS hub_slug,
+	from_users.username AS from_username, to_users.username AS to_username
+	FROM hub_transfers
+	JOIN hubs ON hubs.id = hub_transfers.hub_id
+	JOIN users from_users ON from_users.id = hub_transfers.from_user_id
+	JOIN users to_users ON to_users.id = hub_transfers.to_user_id
+	WHERE (hub_transfers.from_user_id = $1 OR hub_transfers.to_user_id = $1)
+	AND accepted_at IS NULL AND declined_at IS NULL AND cancelled_at IS NULL
+	ORDER BY hub_transfers.created_at DESC, hub_transfers.id DESC;
+	`, userID)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// expiry is derived from the row (see expiry.go)
+	*p = PendingHubTransfers{}
+	for _, ht := range all {
+		if !ht.Expired() {
+			*p = append(*p, ht)
+		}
+	}
+	return nil
+}
+
+// Accept hands the hub over to the recipient. The hub gets a new credential
+// and its tokens are revoked, so it must be set up again by its new owner;
+// the slugs it was renamed from are released, and the transfer is recorded
+// as an audit event of the hub. Returns the new hub secret.
+// Recipients scheduled for deletion can't accept, as the hub would be deleted
+// along with them.
+func (ht *HubTransfer) Accept(db *sqlx.DB, h *Hub) (string, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+
+	// lock the offer so it can't be accepted twice, or cancelled meanwhile
+	if err := tx.Get(ht, "SELECT * FROM hub_transfers WHERE id = $1 FOR UPDATE;", ht.ID); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return "", &Error{"record_not_found", "hub transfer not found"}
+		}
+		return "", err
+	}
+	if err := ht.Validate(); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	// lock the recipient so their deletion can't be scheduled meanwhile
+	to := User{}
+	if err := tx.Get(&to, "SELECT * FROM users WHERE id = $1 FOR SHARE;", ht.ToUserID); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return "", &Error{"record_not_found", "user not found"}
+		}
+		return "", err
+	}
+	if to.DeletionScheduled() {
+		tx.Rollback()
+		return "", &Error{"invalid_request", "account is scheduled for deletion"}
+	}
+
+	err = tx.Get(h, `UPDATE hubs
+	SET user_id = $2
+	WHERE id = $1 AND user_id = $3
+	RETURNING *;
+	`, ht.HubID, ht.ToUserID, ht.FromUserID)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return "", &Error{"record_not_found", "hub not found"}
+		}
+		return "", err
+	}
+
+	if _, err := tx.Exec("DELETE FROM hub_slugs WHERE hub_id = $1;", h.ID); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	secret, err := rotateSecret(tx, h)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	if err := tx.Get(ht, "UPDATE hub_transfers SET accepted_at = now() WHERE id = $1 RETURNING *;", ht.ID); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	details := map[string]int64{"transfer_id": ht.ID, "from_user_id": ht.FromUserID, "to_user_id": ht.ToUserID}
+	if err := addAuditEvent(tx, h.ID, ht.ToUserID, HubTransferred, details); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	return secret, tx.Commit()
+}
+
+// Decline records that the recipient refused the hub. It fails with a
+// transfer_handled error if the offer was already handled.
+func (ht *HubTransfer) Decline(db *sqlx.DB) error {
+	return ht.close(db, "declined_at")
+}
+
+// Cancel withdraws the offer. It fails with a transfer_handled error if the
+// offer was already handled.
+func (ht *HubTransfer) Cancel(db *sqlx.DB) error {
+	return ht.close(db, "cancelled_at")
+}
+
+// close sets column, either declined_at or cancelled_at, if the offer is
+// still pending.
+func (ht *HubTransfer) close(db *sqlx.DB, column string) error {
+	err := db.Get(ht, `UPDATE hub_transfers
+	SET `+column+` = now()
+	WHERE id = $1 AND accepted_at IS NULL AND declined_at IS NULL AND cancelled_at IS NULL
+	RETURNING *;
+	`, ht.ID)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"transfer_handled", "hub transfer was already handled"}
+	}
+	return err
+}
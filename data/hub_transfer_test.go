@@ -0,0 +1,135 @@
+package data_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestHubTransfer(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new users
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+	u2 := &data.User{
+		Username:          "brucelee",
+		Email:             "gmail@brucelee.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u2.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u2)
+	}
+
+	h := &data.Hub{
+		Slug:   "earthworm",
+		UserID: u.ID,
+	}
+	if _, err := h.GenerateSecret(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Insert(db); err != nil {
+		t.Fatal("Failed to insert hub to db: ", err)
+	}
+	hubToken := &data.Token{UserID: u.ID, HubID: &h.ID, ExpiresIn: time.Hour.Nanoseconds(), Scope: data.DeviceScope}
+	if err := hubToken.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new offer replaces the pending one
+	first := &data.HubTransfer{HubID: h.ID, FromUserID: u.ID, ToUserID: u2.ID, ExpiresIn: time.Hour.Nanoseconds()}
+	if err := first.Insert(db); err != nil {
+		t.Fatal("Failed to offer hub: ", err)
+	}
+	ht := &data.HubTransfer{HubID: h.ID, FromUserID: u.ID, ToUserID: u2.ID, ExpiresIn: time.Hour.Nanoseconds()}
+	if err := ht.Insert(db); err != nil {
+		t.Fatal("Failed to offer hub: ", err)
+	}
+	pending := data.PendingHubTransfers{}
+	if err := pending.SelectByUserID(db, u2.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != ht.ID || pending[0].HubSlug != "earthworm" || pending[0].FromUsername != "chucknorris" {
+		t.Errorf("Unexpected pending transfers %+v", pending)
+	}
+	if err := first.Get(db, first.ID); err != nil || first.Validate() == nil {
+		t.Errorf("Expected the first offer to be cancelled, Got %+v", first)
+	}
+
+	// a recipient scheduled for deletion can't accept
+	if err := u2.ScheduleDeletion(db, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ht.Accept(db, h)
+	if e, ok := err.(*data.Error); !ok || e.Desc != "account is scheduled for deletion" {
+		t.Errorf("Expected an error accepting while scheduled for deletion, Got %v", err)
+	}
+	if err := u2.CancelDeletion(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// accepting hands the hub over with a new secret
+	hashedSecret := h.HashedSecret
+	secret, err := ht.Accept(db, h)
+	if err != nil {
+		t.Fatal("Failed to accept transfer: ", err)
+	}
+	if h.UserID != u2.ID || h.HashedSecret == hashedSecret || !h.VerifySecret(secret) || ht.AcceptedAt == nil {
+		t.Errorf("Unexpected hub %+v after transfer %+v", h, ht)
+	}
+	if err := hubToken.Get(db, hubToken.ID); err != nil || hubToken.RevokedAt == nil {
+		t.Error("Expected the hub token to be revoked")
+	}
+
+	// it can't be accepted twice
+	if _, err := ht.Accept(db, &data.Hub{}); err == nil {
+		t.Error("Expected an error accepting a transfer twice")
+	}
+	if err := ht.Decline(db); err == nil {
+		t.Error("Expected an error declining an accepted transfer")
+	}
+
+	// the transfer is audited
+	events, err := h.AuditEvents(db, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Action != data.HubTransferred || events[0].UserID == nil || *events[0].UserID != u2.ID {
+		t.Fatalf("Unexpected audit events %+v", events)
+	}
+	details := map[string]int64{}
+	if err := json.Unmarshal(events[0].Details, &details); err != nil {
+		t.Fatal(err)
+	}
+	if details["from_user_id"] != u.ID || details["to_user_id"] != u2.ID || details["transfer_id"] != ht.ID {
+		t.Errorf("Unexpected audit details %s", events[0].Details)
+	}
+
+	// expired offers can't be accepted
+	back := &data.HubTransfer{HubID: h.ID, FromUserID: u2.ID, ToUserID: u.ID, ExpiresIn: 0}
+	if err := back.Insert(db); err != nil {
+		t.Fatal("Failed to offer hub: ", err)
+	}
+	_, err = back.Accept(db, &data.Hub{})
+	if e, ok := err.(*data.Error); !ok || e.Code != "transfer_expired" {
+		t.Errorf("Expected a transfer_expired error, Got %v", err)
+	}
+
+	// the audit trail outlives the hub
+	if err := h.Delete(db); err != nil {
+		t.Fatal("Failed to delete hub: ", err)
+	}
+	if events, err = h.AuditEvents(db, 50); err != nil || len(events) != 1 {
+		t.Errorf("Expected the audit events to be kept, Got %+v, %v", events, err)
+	}
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Kinds of login throttles.
+const (
+	ThrottleLogin = "login" // keyed by the login (username or email) tried
+	ThrottleIP    = "ip"    // keyed by the client IP address
+)
+
+// ThrottlePolicy tells when failed logins lock a key, and for how long.
+// Once Threshold failures happened within Window of each other, each failure
+// locks the key for twice as long as the previous one, from Base up to Max.
+type ThrottlePolicy struct {
+	Threshold int64
+	Base      time.Duration
+	Max       time.Duration
+	Window    time.Duration
+}
+
+// lockout returns how long the given number of failures locks a key.
+func (p ThrottlePolicy) lockout(failures int64) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	d := p.Base
+	for i := p.Threshold; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	if d > p.Max {
+		d = p.Max
+	}
+	return d
+}
+
+// LoginThrottle counts failed logins for a key. It is kept in the database so
+// that every instance of the service sees the same counts.
+type LoginThrottle struct {
+	Kind        string     `db:"kind"`
+	Key         string     `db:"key"`
+	Failures    int64      `db:"failures"`
+	LockedUntil *time.Time `db:"locked_until"`
+	UpdatedAt   *time.Time `db:"updated_at"`
+}
+
+func (lt *LoginThrottle) Get(db *sqlx.DB, kind, key string) error {
+	err := db.Get(lt, "SELECT * FROM login_throttles WHERE kind = $1 AND key = $2 LIMIT 1;", kind, key)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "login throttle not found"}
+	}
+	return err
+}
+
+// RetryAfter returns how long the key stays locked, or 0 if it is not locked.
+func (lt *LoginThrottle) RetryAfter() time.Duration {
+	if lt.LockedUntil == nil {
+		return 0
+	}
+	if d := lt.LockedUntil.Sub(time.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Fail records a failed login for the key and locks it as per the policy.
+// Failures older than the policy window are forgotten. Each lockout is
+// recorded as a lockout event.
+func (lt *LoginThrottle) Fail(db *sqlx.DB, kind, key string, p ThrottlePolicy) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Get(lt, `INSERT INTO login_throttles AS t
+	(kind, key, failures, updated_at)
+	VALUES ($1, $2, 1, now())
+	ON CONFLICT (kind, key) DO UPDATE
+	SET failures = CASE WHEN t.updated_at < now() - $3::float8 * interval '1 second' THEN 1 ELSE t.failures + 1 END,
+	updated_at = now()
+	RETURNING *;
+	`, kind, key, p.Window.Seconds())
+	if err != nil {
+		tx.Rollback()
+		if err, ok := err.(*pq.Error); ok {
+			return &Error{err.Code.Name(), "pq error"}
+		}
+		return err
+	}
+
+	if d := p.lockout(lt.Failures); d > 0 {
+		err = tx.Get(lt, `UPDATE login_throttles
+		SET locked_until = now() + $3::float8 * interval '1 second'
+		WHERE kind = $1 AND key = $2
+		RETURNING *;
+		`, kind, key, d.Seconds())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO lockout_events
+		(kind, key, failures, locked_until)
+		VALUES ($1, $2, $3, $4);
+		`, kind, key, lt.Failures, lt.LockedUntil)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ResetLoginThrottle forgets the failed logins of a key.
+func ResetLoginThrottle(db *sqlx.DB, kind, key string) error {
+	_, err := db.Exec("DELETE FROM login_throttles WHERE kind = $1 AND key = $2;", kind, key)
+	return err
+}
@@ -0,0 +1,55 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestLoginThrottleFail(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	p := data.ThrottlePolicy{Threshold: 2, Base: time.Minute, Max: 3 * time.Minute, Window: time.Hour}
+
+	lt := &data.LoginThrottle{}
+	if err := lt.Fail(db, data.ThrottleLogin, "foo", p); err != nil {
+		t.Fatal(err)
+	}
+	if lt.Failures != 1 || lt.RetryAfter() != 0 {
+		t.Errorf("Expected 1 failure without lockout, Got %d failures and %v", lt.Failures, lt.RetryAfter())
+	}
+
+	// the lockout doubles with each failure past the threshold, up to the max
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		if err := lt.Fail(db, data.ThrottleLogin, "foo", p); err != nil {
+			t.Fatal(err)
+		}
+		if d := lt.RetryAfter(); d <= want-5*time.Second || d > want {
+			t.Errorf("failure %d - Expected a lockout of %v, Got %v", i+2, want, d)
+		}
+	}
+
+	var events int
+	if err := db.Get(&events, "SELECT count(*) FROM lockout_events WHERE kind = $1 AND key = $2;", data.ThrottleLogin, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if events != 4 {
+		t.Errorf("Expected 4 lockout events, Got %d", events)
+	}
+
+	// other keys are not affected
+	other := &data.LoginThrottle{}
+	if err := other.Get(db, data.ThrottleIP, "foo"); err == nil {
+		t.Error("Expected no throttle for another kind")
+	}
+
+	if err := data.ResetLoginThrottle(db, data.ThrottleLogin, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lt.Get(db, data.ThrottleLogin, "foo"); err == nil {
+		t.Error("Expected the throttle to be reset")
+	}
+}
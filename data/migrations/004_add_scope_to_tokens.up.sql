@@ -0,0 +1 @@
+ALTER TABLE tokens ADD COLUMN scope varchar(255) NOT NULL DEFAULT 'user hub app';
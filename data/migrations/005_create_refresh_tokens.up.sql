@@ -0,0 +1,14 @@
+CREATE TABLE refresh_tokens (
+  id bigserial PRIMARY KEY NOT NULL,
+  token_id bigint REFERENCES tokens(id) NOT NULL,
+  user_id bigint REFERENCES users(id) NOT NULL,
+  family_id bigint NOT NULL,
+  hashed_token varchar(255) NOT NULL UNIQUE,
+  scope varchar(255) NOT NULL,
+  expires_in bigint,
+  created_at timestamp without time zone DEFAULT now(),
+  used_at timestamp without time zone,
+  revoked_at timestamp without time zone
+);
+CREATE UNIQUE INDEX index_refresh_tokens_on_hashed_token ON refresh_tokens USING btree (hashed_token);
+CREATE INDEX index_refresh_tokens_on_family_id ON refresh_tokens USING btree (family_id);
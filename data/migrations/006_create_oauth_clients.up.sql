@@ -0,0 +1,14 @@
+CREATE TABLE oauth_clients (
+  id bigserial PRIMARY KEY NOT NULL,
+  client_id varchar(255) NOT NULL UNIQUE,
+  hashed_secret varchar(255) NOT NULL,
+  name varchar(255) NOT NULL,
+  scope varchar(255) NOT NULL,
+  user_id bigint REFERENCES users(id) NOT NULL,
+  created_at timestamp without time zone DEFAULT now(),
+  updated_at timestamp without time zone DEFAULT now()
+);
+CREATE UNIQUE INDEX index_oauth_clients_on_client_id ON oauth_clients USING btree (client_id);
+CREATE INDEX index_oauth_clients_on_user_id ON oauth_clients USING btree (user_id);
+ALTER TABLE tokens ADD COLUMN client_id bigint REFERENCES oauth_clients(id);
+ALTER TABLE refresh_tokens ADD COLUMN client_id bigint REFERENCES oauth_clients(id);
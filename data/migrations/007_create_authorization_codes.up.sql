@@ -0,0 +1,15 @@
+ALTER TABLE oauth_clients ADD COLUMN redirect_uris text NOT NULL DEFAULT '';
+CREATE TABLE authorization_codes (
+  id bigserial PRIMARY KEY NOT NULL,
+  hashed_code varchar(255) NOT NULL UNIQUE,
+  client_id bigint REFERENCES oauth_clients(id) NOT NULL,
+  user_id bigint REFERENCES users(id) NOT NULL,
+  redirect_uri text NOT NULL,
+  scope varchar(255) NOT NULL,
+  code_challenge varchar(255) NOT NULL,
+  expires_in bigint,
+  token_id bigint REFERENCES tokens(id),
+  created_at timestamp without time zone DEFAULT now(),
+  used_at timestamp without time zone
+);
+CREATE UNIQUE INDEX index_authorization_codes_on_hashed_code ON authorization_codes USING btree (hashed_code);
@@ -0,0 +1,3 @@
+ALTER TABLE hubs ADD COLUMN hashed_secret varchar(255) NOT NULL DEFAULT '';
+
+ALTER TABLE tokens ADD COLUMN hub_id int REFERENCES hubs(id) ON DELETE CASCADE;
@@ -0,0 +1,13 @@
+CREATE TABLE device_codes (
+  id bigserial PRIMARY KEY NOT NULL,
+  hashed_device_code varchar(255) NOT NULL UNIQUE,
+  user_code varchar(255) NOT NULL UNIQUE,
+  expires_in bigint,
+  poll_interval int NOT NULL,
+  user_id int REFERENCES users(id) ON DELETE CASCADE,
+  hub_id int REFERENCES hubs(id) ON DELETE CASCADE,
+  created_at timestamp without time zone DEFAULT now(),
+  polled_at timestamp without time zone,
+  denied_at timestamp without time zone,
+  used_at timestamp without time zone
+);
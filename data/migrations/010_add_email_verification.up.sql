@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN email_verified_at timestamp without time zone;
+
+-- users who signed up before verification was required keep their access
+UPDATE users SET email_verified_at = created_at;
+
+CREATE TABLE email_verifications (
+  id bigserial PRIMARY KEY NOT NULL,
+  user_id bigint REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  hashed_token varchar(255) NOT NULL UNIQUE,
+  email varchar(255) NOT NULL,
+  expires_in bigint,
+  created_at timestamp without time zone DEFAULT now(),
+  used_at timestamp without time zone
+);
@@ -0,0 +1,8 @@
+CREATE TABLE password_resets (
+  id bigserial PRIMARY KEY NOT NULL,
+  user_id bigint REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  hashed_token varchar(255) NOT NULL UNIQUE,
+  expires_in bigint,
+  created_at timestamp without time zone DEFAULT now(),
+  used_at timestamp without time zone
+);
@@ -0,0 +1,28 @@
+ALTER TABLE users ADD COLUMN delete_after timestamp without time zone;
+CREATE INDEX index_users_on_delete_after ON users USING btree (delete_after) WHERE delete_after IS NOT NULL;
+
+-- deleting a user deletes everything they own
+ALTER TABLE tokens DROP CONSTRAINT tokens_user_id_fkey,
+  ADD CONSTRAINT tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE tokens DROP CONSTRAINT tokens_client_id_fkey,
+  ADD CONSTRAINT tokens_client_id_fkey FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE;
+
+ALTER TABLE hubs DROP CONSTRAINT hubs_user_id_fkey,
+  ADD CONSTRAINT hubs_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE refresh_tokens DROP CONSTRAINT refresh_tokens_token_id_fkey,
+  ADD CONSTRAINT refresh_tokens_token_id_fkey FOREIGN KEY (token_id) REFERENCES tokens(id) ON DELETE CASCADE;
+ALTER TABLE refresh_tokens DROP CONSTRAINT refresh_tokens_user_id_fkey,
+  ADD CONSTRAINT refresh_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE refresh_tokens DROP CONSTRAINT refresh_tokens_client_id_fkey,
+  ADD CONSTRAINT refresh_tokens_client_id_fkey FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE;
+
+ALTER TABLE oauth_clients DROP CONSTRAINT oauth_clients_user_id_fkey,
+  ADD CONSTRAINT oauth_clients_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE authorization_codes DROP CONSTRAINT authorization_codes_client_id_fkey,
+  ADD CONSTRAINT authorization_codes_client_id_fkey FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE;
+ALTER TABLE authorization_codes DROP CONSTRAINT authorization_codes_user_id_fkey,
+  ADD CONSTRAINT authorization_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE authorization_codes DROP CONSTRAINT authorization_codes_token_id_fkey,
+  ADD CONSTRAINT authorization_codes_token_id_fkey FOREIGN KEY (token_id) REFERENCES tokens(id) ON DELETE SET NULL;
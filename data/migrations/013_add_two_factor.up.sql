@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN otp_secret varchar(255);
+ALTER TABLE users ADD COLUMN otp_enabled_at timestamp without time zone;
+ALTER TABLE users ADD COLUMN otp_last_counter bigint;
+
+CREATE TABLE recovery_codes (
+  id bigserial PRIMARY KEY NOT NULL,
+  user_id bigint REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  hashed_code varchar(255) NOT NULL,
+  created_at timestamp without time zone DEFAULT now(),
+  used_at timestamp without time zone
+);
+CREATE INDEX index_recovery_codes_on_user_id ON recovery_codes USING btree (user_id);
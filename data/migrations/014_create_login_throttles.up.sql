@@ -0,0 +1,18 @@
+CREATE TABLE login_throttles (
+  kind varchar(32) NOT NULL,
+  key varchar(255) NOT NULL,
+  failures bigint NOT NULL DEFAULT 0,
+  locked_until timestamp without time zone,
+  updated_at timestamp without time zone DEFAULT now(),
+  PRIMARY KEY (kind, key)
+);
+
+CREATE TABLE lockout_events (
+  id bigserial PRIMARY KEY NOT NULL,
+  kind varchar(32) NOT NULL,
+  key varchar(255) NOT NULL,
+  failures bigint NOT NULL,
+  locked_until timestamp without time zone NOT NULL,
+  created_at timestamp without time zone DEFAULT now()
+);
+CREATE INDEX index_lockout_events_on_kind_and_key ON lockout_events USING btree (kind, key);
@@ -0,0 +1,54 @@
+-- usernames and emails are unique regardless of case.
+
+-- users whose username only differs by case from an older user's get their
+-- id appended (eg: Bob becomes Bob-42), cut to fit the 32 characters of a
+-- valid username. If that name is taken too, a counter is appended as well
+-- (eg: Bob-42-1). They can still log in with their email.
+DO $$
+DECLARE
+  u record;
+  suffix text;
+  candidate text;
+  n int;
+BEGIN
+  FOR u IN
+    SELECT id, username FROM users
+    WHERE id NOT IN (SELECT min(id) FROM users GROUP BY lower(username))
+    ORDER BY id
+  LOOP
+    suffix := '-' || u.id;
+    n := 0;
+    LOOP
+      candidate := left(u.username, 32 - length(suffix)) || suffix;
+      EXIT WHEN NOT EXISTS (SELECT 1 FROM users WHERE lower(username) = lower(candidate));
+      n := n + 1;
+      suffix := '-' || u.id || '-' || n;
+    END LOOP;
+
+    UPDATE users SET username = candidate, updated_at = now() WHERE id = u.id;
+  END LOOP;
+END
+$$;
+
+-- users whose emails only differ by case can't be told apart automatically:
+-- stop and list them, so they can be merged or have their email changed first.
+DO $$
+DECLARE
+  duplicates text;
+BEGIN
+  SELECT string_agg(lower_email || ' (user ids ' || ids || ')', ', ') INTO duplicates
+  FROM (
+    SELECT lower(email) AS lower_email, string_agg(id::text, ', ' ORDER BY id) AS ids
+    FROM users
+    GROUP BY lower(email)
+    HAVING count(*) > 1
+  ) AS dups;
+
+  IF duplicates IS NOT NULL THEN
+    RAISE EXCEPTION 'users share emails differing only by case, change them before migrating: %', duplicates;
+  END IF;
+END
+$$;
+
+CREATE UNIQUE INDEX index_users_on_lower_username ON users USING btree (lower(username));
+CREATE UNIQUE INDEX index_users_on_lower_email ON users USING btree (lower(email));
@@ -0,0 +1,2 @@
+ALTER TABLE tokens ADD COLUMN last_used_at timestamp without time zone;
+ALTER TABLE tokens ADD COLUMN last_used_ip varchar(64);
@@ -0,0 +1,13 @@
+CREATE TABLE personal_tokens (
+  id bigserial PRIMARY KEY NOT NULL,
+  user_id bigint REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  name varchar(255) NOT NULL,
+  hashed_token varchar(255) NOT NULL UNIQUE,
+  scope varchar(255) NOT NULL,
+  expires_in bigint,
+  created_at timestamp without time zone DEFAULT now(),
+  revoked_at timestamp without time zone,
+  last_used_at timestamp without time zone,
+  last_used_ip varchar(64)
+);
+CREATE INDEX index_personal_tokens_on_user_id ON personal_tokens USING btree (user_id);
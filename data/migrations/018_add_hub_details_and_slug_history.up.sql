@@ -0,0 +1,13 @@
+ALTER TABLE hubs ADD COLUMN name varchar(255) NOT NULL DEFAULT '';
+ALTER TABLE hubs ADD COLUMN description text NOT NULL DEFAULT '';
+
+-- slugs a hub was renamed from; they keep resolving to the hub, and can't be
+-- taken by another user, until expires_at.
+CREATE TABLE hub_slugs (
+  slug varchar(255) PRIMARY KEY NOT NULL,
+  hub_id int REFERENCES hubs(id) ON DELETE CASCADE NOT NULL,
+  user_id int REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  created_at timestamp without time zone DEFAULT now(),
+  expires_at timestamp without time zone NOT NULL
+);
+CREATE INDEX index_hub_slugs_on_hub_id ON hub_slugs USING btree (hub_id);
@@ -0,0 +1,15 @@
+ALTER TABLE hubs ADD COLUMN last_seen_at timestamp without time zone;
+ALTER TABLE hubs ADD COLUMN last_seen_ip varchar(64);
+ALTER TABLE hubs ADD COLUMN uptime bigint;
+ALTER TABLE hubs ADD COLUMN version varchar(64);
+ALTER TABLE hubs ADD COLUMN load double precision;
+-- last presence change recorded in hub_presence_events
+ALTER TABLE hubs ADD COLUMN presence varchar(32) NOT NULL DEFAULT 'offline';
+
+CREATE TABLE hub_presence_events (
+  id bigserial PRIMARY KEY NOT NULL,
+  hub_id int REFERENCES hubs(id) ON DELETE CASCADE NOT NULL,
+  status varchar(32) NOT NULL,
+  created_at timestamp without time zone DEFAULT now()
+);
+CREATE INDEX index_hub_presence_events_on_hub_id ON hub_presence_events USING btree (hub_id);
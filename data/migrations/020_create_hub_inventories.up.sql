@@ -0,0 +1,14 @@
+-- latest inventory reported by the hub, for querying
+ALTER TABLE hubs ADD COLUMN inventory jsonb;
+CREATE INDEX index_hubs_on_inventory ON hubs USING gin (inventory jsonb_path_ops);
+
+-- every distinct inventory reported, with the changes from the previous one
+CREATE TABLE hub_inventories (
+  id bigserial PRIMARY KEY NOT NULL,
+  hub_id int REFERENCES hubs(id) ON DELETE CASCADE NOT NULL,
+  version bigint NOT NULL,
+  document jsonb NOT NULL,
+  changes jsonb NOT NULL,
+  created_at timestamp without time zone DEFAULT now(),
+  UNIQUE (hub_id, version)
+);
@@ -0,0 +1,26 @@
+-- offers to hand a hub over to another user
+CREATE TABLE hub_transfers (
+  id bigserial PRIMARY KEY NOT NULL,
+  hub_id int REFERENCES hubs(id) ON DELETE CASCADE NOT NULL,
+  from_user_id int REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  to_user_id int REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+  expires_in bigint NOT NULL,
+  created_at timestamp without time zone DEFAULT now(),
+  accepted_at timestamp without time zone,
+  declined_at timestamp without time zone,
+  cancelled_at timestamp without time zone
+);
+CREATE INDEX index_hub_transfers_on_hub_id ON hub_transfers USING btree (hub_id);
+CREATE INDEX index_hub_transfers_on_to_user_id ON hub_transfers USING btree (to_user_id);
+
+-- changes to a hub worth keeping track of, eg: who it was transferred to.
+-- hub_id is not a foreign key so that events outlive the hub.
+CREATE TABLE hub_audit_events (
+  id bigserial PRIMARY KEY NOT NULL,
+  hub_id int NOT NULL,
+  user_id int REFERENCES users(id) ON DELETE SET NULL,
+  action varchar(64) NOT NULL,
+  details jsonb NOT NULL DEFAULT '{}',
+  created_at timestamp without time zone DEFAULT now()
+);
+CREATE INDEX index_hub_audit_events_on_hub_id ON hub_audit_events USING btree (hub_id);
@@ -0,0 +1,153 @@
+package data
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const recoveryCodeCount = 10
+
+// OTPEnabled reports whether the user enrolled two-factor authentication.
+func (u *User) OTPEnabled() bool {
+	return u.OTPEnabledAt != nil && u.OTPSecret != nil
+}
+
+// SetOTPSecret starts enrolling two-factor authentication. The secret is not
+// required to log in until EnableOTP confirms the user could set it up.
+func (u *User) SetOTPSecret(db *sqlx.DB, secret string) error {
+	err := db.Get(u, `UPDATE users
+	SET otp_secret = $2, otp_last_counter = NULL, updated_at = now()
+	WHERE id = $1 AND otp_enabled_at IS NULL
+	RETURNING *;
+	`, u.ID, secret)
+	if err, ok := err.(*pq.Error); ok {
+		return &Error{err.Code.Name(), "pq error"}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"otp_enabled", "two-factor authentication is already enabled"}
+	}
+	return err
+}
+
+// EnableOTP requires the OTP to log in from now on, and replaces the user's
+// recovery codes. Returns the plain recovery codes.
+func (u *User) EnableOTP(db *sqlx.DB) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		c, err := generateSecret(5)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = c[:5] + "-" + c[5:]
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Get(u, `UPDATE users
+	SET otp_enabled_at = now(), updated_at = now()
+	WHERE id = $1 AND otp_secret IS NOT NULL AND otp_enabled_at IS NULL
+	RETURNING *;
+	`, u.ID)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, &Error{"otp_enabled", "two-factor authentication is already enabled"}
+		}
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1;", u.ID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for _, c := range codes {
+		_, err := tx.Exec("INSERT INTO recovery_codes (user_id, hashed_code) VALUES ($1, $2);", u.ID, hashRecoveryCode(c))
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+// DisableOTP removes two-factor authentication and the recovery codes.
+func (u *User) DisableOTP(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Get(u, `UPDATE users
+	SET otp_secret = NULL, otp_enabled_at = NULL, otp_last_counter = NULL, updated_at = now()
+	WHERE id = $1
+	RETURNING *;
+	`, u.ID)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return &Error{"record_not_found", "user not found"}
+		}
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1;", u.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseOTPCounter records the period of an OTP the user logged in with.
+// It fails with an invalid_otp error if a code of that period or a later one
+// was already used, so that an intercepted code can't be replayed.
+func (u *User) UseOTPCounter(db *sqlx.DB, counter int64) error {
+	err := db.Get(u, `UPDATE users
+	SET otp_last_counter = $2
+	WHERE id = $1 AND (otp_last_counter IS NULL OR otp_last_counter < $2)
+	RETURNING *;
+	`, u.ID, counter)
+	if err, ok := err.(*pq.Error); ok {
+		return &Error{err.Code.Name(), "pq error"}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"invalid_otp", "otp was already used"}
+	}
+	return err
+}
+
+// UseRecoveryCode spends one of the user's recovery codes.
+// It fails with an invalid_otp error if the code is unknown or already used.
+func (u *User) UseRecoveryCode(db *sqlx.DB, code string) error {
+	r, err := db.Exec(`UPDATE recovery_codes
+	SET used_at = now()
+	WHERE user_id = $1 AND hashed_code = $2 AND used_at IS NULL;
+	`, u.ID, hashRecoveryCode(code))
+	if err, ok := err.(*pq.Error); ok {
+		return &Error{err.Code.Name(), "pq error"}
+	}
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &Error{"invalid_otp", "recovery code is not valid"}
+	}
+	return nil
+}
+
+// hashRecoveryCode ignores case and separators, as codes are typed by users.
+func hashRecoveryCode(code string) string {
+	return hashSecret(strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1)))
+}
@@ -0,0 +1,80 @@
+package data_test
+
+import (
+	"testing"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestOTP(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	// enrol
+	if err := u.SetOTPSecret(db, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Error("Failed to set otp secret: ", err)
+	}
+	if u.OTPEnabled() {
+		t.Error("OTP must not be enabled before confirmation")
+	}
+	codes, err := u.EnableOTP(db)
+	if err != nil {
+		t.Error("Failed to enable otp: ", err)
+	}
+	if !u.OTPEnabled() || len(codes) != 10 {
+		t.Error("EnableOTP must enable otp and return recovery codes: %v", codes)
+	}
+
+	// a new secret can't be set while enabled
+	err = u.SetOTPSecret(db, "JBSWY3DPEHPK3PXQ")
+	if e, ok := err.(*data.Error); !ok || e.Code != "otp_enabled" {
+		t.Error("Expected an 'otp_enabled' error, Got %v", err)
+	}
+
+	// an OTP period is used only once
+	if err := u.UseOTPCounter(db, 100); err != nil {
+		t.Error("Failed to use otp counter: ", err)
+	}
+	for _, c := range []int64{100, 99} {
+		err := u.UseOTPCounter(db, c)
+		if e, ok := err.(*data.Error); !ok || e.Code != "invalid_otp" {
+			t.Error("Expected an 'invalid_otp' error for counter %d, Got %v", c, err)
+		}
+	}
+
+	// a recovery code is used only once, ignoring case
+	if err := u.UseRecoveryCode(db, codes[0]); err != nil {
+		t.Error("Failed to use recovery code: ", err)
+	}
+	err = u.UseRecoveryCode(db, codes[0])
+	if e, ok := err.(*data.Error); !ok || e.Code != "invalid_otp" {
+		t.Error("Expected an 'invalid_otp' error, Got %v", err)
+	}
+	if err := u.UseRecoveryCode(db, "  "+codes[1]+" "); err != nil {
+		t.Error("Failed to use recovery code with spaces: ", err)
+	}
+
+	// disable
+	if err := u.DisableOTP(db); err != nil {
+		t.Error("Failed to disable otp: ", err)
+	}
+	if u.OTPEnabled() || u.OTPSecret != nil {
+		t.Error("DisableOTP must clear the otp secret")
+	}
+	if err := u.UseRecoveryCode(db, codes[2]); err == nil {
+		t.Error("Recovery codes must be deleted when otp is disabled")
+	}
+
+	db.Close()
+}
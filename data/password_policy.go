@@ -0,0 +1,56 @@
+package data
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PasswordPolicy tells which passwords users can choose.
+type PasswordPolicy struct {
+	MinLength int
+	Common    map[string]bool // breached or common passwords, lowercased
+}
+
+// DefaultPasswordPolicy is used when no other policy is configured.
+var DefaultPasswordPolicy = &PasswordPolicy{MinLength: 8}
+
+// LoadPasswordList reads a list of common passwords, one per line.
+// Blank lines and lines starting with # are ignored.
+func LoadPasswordList(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := map[string]bool{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list[strings.ToLower(line)] = true
+	}
+	return list, s.Err()
+}
+
+// Check returns an error describing why the password can't be used by
+// the user with the given username.
+func (p *PasswordPolicy) Check(password, username string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return &Error{"weak_password", "password must be at least " + strconv.Itoa(p.MinLength) + " characters"}
+	}
+
+	lower := strings.ToLower(password)
+	if p.Common[lower] {
+		return &Error{"weak_password", "password is too common"}
+	}
+	if username != "" && strings.Contains(lower, strings.ToLower(username)) {
+		return &Error{"weak_password", "password must not contain the username"}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package data_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ripple-cloud/cloud/data"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passwords")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "common.txt")
+	if err := ioutil.WriteFile(path, []byte("# common passwords\n123456789\nPassword1\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	common, err := data.LoadPasswordList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(common) != 2 {
+		t.Errorf("Expected 2 common passwords, Got %d", len(common))
+	}
+
+	p := &data.PasswordPolicy{MinLength: 8, Common: common}
+
+	type testCase struct {
+		password string
+		desc     string // empty when the password is valid
+	}
+
+	tCases := []testCase{
+		{"correct horse", ""},
+		{"héllo", "password must be at least 8 characters"},
+		{"123456789", "password is too common"},
+		{"PASSWORD1", "password is too common"},
+		{"my-ChuckNorris-pw", "password must not contain the username"},
+	}
+
+	for _, tc := range tCases {
+		err := p.Check(tc.password, "chucknorris")
+		if tc.desc == "" {
+			if err != nil {
+				t.Errorf("%s - Expected no error, Got %v", tc.password, err)
+			}
+			continue
+		}
+		e, ok := err.(*data.Error)
+		if !ok || e.Code != "weak_password" || e.Desc != tc.desc {
+			t.Errorf("%s - Expected weak_password error %q, Got %v", tc.password, tc.desc, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// PasswordReset is mailed to a user who forgot their password.
+type PasswordReset struct {
+	ID          int64  `db:"id"`
+	UserID      int64  `db:"user_id"`
+	HashedToken string `db:"hashed_token"`
+	ExpiresIn   int64  `db:"expires_in"`
+
+	CreatedAt *time.Time `db:"created_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+// Generate creates a new random token and sets its hash.
+// The returned plain token is not persisted and must be mailed to the user.
+func (pr *PasswordReset) Generate() (string, error) {
+	token, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+	pr.HashedToken = hashSecret(token)
+	return token, nil
+}
+
+func (pr *PasswordReset) Insert(db *sqlx.DB) error {
+	nstmt, err := db.PrepareNamed(`INSERT INTO password_resets
+	(user_id, hashed_token, expires_in)
+	VALUES (:user_id, :hashed_token, :expires_in)
+	RETURNING *;
+	`)
+	if err != nil {
+		return err
+	}
+	defer nstmt.Close()
+
+	err = nstmt.QueryRow(pr).StructScan(pr)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	return err
+}
+
+// GetByToken finds a password reset by its plain token.
+func (pr *PasswordReset) GetByToken(db *sqlx.DB, token string) error {
+	err := db.Get(pr, "SELECT * FROM password_resets WHERE hashed_token = $1 LIMIT 1;", hashSecret(token))
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "password reset not found"}
+	}
+	return err
+}
+
+func (pr *PasswordReset) Expired() bool {
+	return !time.Now().Before(expiresAt(pr.CreatedAt, pr.ExpiresIn))
+}
+
+// Reset sets the user's encrypted password (see User.EncryptPassword) and
+// revokes every token the user had. Other pending resets of the user are
+// used up too. It fails with a token_reused error if the reset was already used.
+func (pr *PasswordReset) Reset(db *sqlx.DB, u *User) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Get(pr, `UPDATE password_resets
+	SET used_at = now()
+	WHERE id = $1 AND used_at IS NULL
+	RETURNING *;
+	`, pr.ID)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return &Error{"token_reused", "password reset was already used"}
+		}
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE password_resets SET used_at = now() WHERE user_id = $1 AND used_at IS NULL;", pr.UserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Get(u, `UPDATE users
+	SET encrypted_password = $2, updated_at = now()
+	WHERE id = $1
+	RETURNING *;
+	`, pr.UserID, u.EncryptedPassword)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return &Error{"record_not_found", "user not found"}
+		}
+		return err
+	}
+
+	if err := revokeUserTokens(tx, pr.UserID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
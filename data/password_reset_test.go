@@ -0,0 +1,102 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestPasswordReset(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username: "chucknorris",
+		Email:    "gmail@chucknorris.com",
+	}
+	if err := u.EncryptPassword("wood-chuck-chuck"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	// insert a token for the user
+	tok := &data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "user",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Error("Failed to insert token to db: %v", tok)
+	}
+
+	pt := &data.PersonalToken{UserID: u.ID, Name: "deploy script", Scope: "user"}
+	if _, err := pt.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.Insert(db); err != nil {
+		t.Fatal("Failed to insert personal token to db: ", err)
+	}
+
+	pr := &data.PasswordReset{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+	}
+	token, err := pr.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" || pr.HashedToken == token {
+		t.Error("Generate must return a token and only keep its hash")
+	}
+	if err := pr.Insert(db); err != nil {
+		t.Error("Failed to insert password reset to db: %v", pr)
+	}
+
+	// query for the reset by token
+	pr1 := &data.PasswordReset{}
+	if err := pr1.GetByToken(db, token); err != nil {
+		t.Error("Failed to get password reset: ", err)
+	}
+	if pr1.ID != pr.ID || pr1.Expired() {
+		t.Error("Unexpected password reset returned: %v", pr1)
+	}
+
+	// reset the password
+	u1 := &data.User{}
+	if err := u1.EncryptPassword("chuck-wood-wood"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pr1.Reset(db, u1); err != nil {
+		t.Error("Failed to reset password: ", err)
+	}
+	if u1.ID != u.ID || !u1.VerifyPassword("chuck-wood-wood") || u1.VerifyPassword("wood-chuck-chuck") {
+		t.Error("Reset must set the new password: %v", u1)
+	}
+
+	// existing tokens are revoked
+	if err := tok.Get(db, tok.ID); err != nil {
+		t.Error("Failed to find token for id: ", tok.ID)
+	}
+	if tok.RevokedAt == nil {
+		t.Error("Tokens must be revoked when the password is reset")
+	}
+	if err := pt.Get(db, pt.ID); err != nil {
+		t.Error("Failed to find personal token for id: ", pt.ID)
+	}
+	if pt.RevokedAt == nil {
+		t.Error("Personal tokens must be revoked when the password is reset")
+	}
+
+	// a reset is used only once
+	err = pr1.Reset(db, u1)
+	if e, ok := err.(*data.Error); !ok || e.Code != "token_reused" {
+		t.Error("Resetting twice must return a 'token_reused' error, Got %v", err)
+	}
+
+	db.Close()
+}
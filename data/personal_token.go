@@ -0,0 +1,172 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// PersonalTokenPrefix starts every personal token, which tells them apart
+// from JWTs (see handlers.Auth).
+const PersonalTokenPrefix = "rpt_"
+
+// PersonalToken is an access token a user creates for scripts. Unlike tokens
+// issued by grants it has a name, may never expire, and is sent as is rather
+// than as a JWT.
+type PersonalToken struct {
+	ID          int64  `db:"id" json:"id"`
+	UserID      int64  `db:"user_id" json:"user_id"`
+	Name        string `db:"name" json:"name"`
+	HashedToken string `db:"hashed_token" json:"-"`
+	Scope       string `db:"scope" json:"scope"`
+	ExpiresIn   *int64 `db:"expires_in" json:"-"` // nanoseconds; never expires if nil
+
+	CreatedAt  *time.Time `db:"created_at" json:"created_at"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"-"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
+	LastUsedIP *string    `db:"last_used_ip" json:"last_used_ip"`
+}
+
+type PersonalTokens []PersonalToken
+
+// Generate creates a new random token and sets its hash.
+// The returned plain token is not persisted and must be shown to the user.
+func (pt *PersonalToken) Generate() (string, error) {
+	secret, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+	token := PersonalTokenPrefix + secret
+	pt.HashedToken = hashSecret(token)
+	return token, nil
+}
+
+func (pt *PersonalToken) Insert(db *sqlx.DB) error {
+	nstmt, err := db.PrepareNamed(`INSERT INTO personal_tokens
+	(user_id, name, hashed_token, scope, expires_in)
+	VALUES (:user_id, :name, :hashed_token, :scope, :expires_in)
+	RETURNING *;
+	`)
+	if err != nil {
+		return err
+	}
+	defer nstmt.Close()
+
+	err = nstmt.QueryRow(pt).StructScan(pt)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	return err
+}
+
+func (pt *PersonalToken) Get(db *sqlx.DB, id int64) error {
+	err := db.Get(pt, "SELECT * FROM personal_tokens WHERE id = $1 LIMIT 1;", id)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "personal token not found"}
+	}
+	return err
+}
+
+func (pt *PersonalToken) GetByToken(db *sqlx.DB, token string) error {
+	err := db.Get(pt, "SELECT * FROM personal_tokens WHERE hashed_token = $1 LIMIT 1;", hashSecret(token))
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "personal token not found"}
+	}
+	return err
+}
+
+// SelectByUserID selects the personal tokens of the user that are not revoked.
+// Expired ones are kept so the user can see why a script stopped working.
+func (pts *PersonalTokens) SelectByUserID(db *sqlx.DB, userID int64) error {
+	err := db.Select(pts, "SELECT * FROM personal_tokens WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id;", userID)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	return err
+}
+
+// Revoke marks the token as revoked.
+// Revoking an already revoked token keeps its original RevokedAt.
+func (pt *PersonalToken) Revoke(db *sqlx.DB) error {
+	err := db.Get(pt, `UPDATE personal_tokens
+	SET revoked_at = COALESCE(revoked_at, now())
+	WHERE id = $1
+	RETURNING *;
+	`, pt.ID)
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "personal token not found"}
+	}
+	return err
+}
+
+// Touch records that the token was used from the given IP, at most every
+// so often (see Token.Touch).
+func (pt *PersonalToken) Touch(db *sqlx.DB, ip string, every time.Duration) error {
+	if pt.LastUsedAt != nil && time.Since(*pt.LastUsedAt) < every && pt.LastUsedIP != nil && *pt.LastUsedIP == ip {
+		return nil
+	}
+
+	err := db.Get(pt, `UPDATE personal_tokens
+	SET last_used_at = now(), last_used_ip = $2
+	WHERE id = $1
+	RETURNING *;
+	`, pt.ID, ip)
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "personal token not found"}
+	}
+	return err
+}
+
+// ExpiresAt returns when the token stops being valid, or nil if it never does.
+func (pt *PersonalToken) ExpiresAt() *time.Time {
+	if pt.ExpiresIn == nil {
+		return nil
+	}
+	t := expiresAt(pt.CreatedAt, *pt.ExpiresIn)
+	return &t
+}
+
+func (pt *PersonalToken) Expired() bool {
+	exp := pt.ExpiresAt()
+	return exp != nil && !time.Now().Before(*exp)
+}
+
+// Validate returns an invalid_token error if the token was revoked or has expired.
+func (pt *PersonalToken) Validate() error {
+	if pt.RevokedAt != nil {
+		return &Error{"invalid_token", "token is not valid"}
+	}
+	if pt.Expired() {
+		return &Error{"invalid_token", "token is expired"}
+	}
+	return nil
+}
+
+// HasScope reports whether the token was granted the given scope, the same
+// way as Token.HasScope.
+func (pt *PersonalToken) HasScope(scope string) bool {
+	t := Token{Scope: pt.Scope}
+	return t.HasScope(scope)
+}
@@ -0,0 +1,87 @@
+package data_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestPersonalToken(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	// insert a personal token that never expires
+	pt := &data.PersonalToken{
+		UserID: u.ID,
+		Name:   "deploy script",
+		Scope:  "hub",
+	}
+	token, err := pt.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, data.PersonalTokenPrefix) || pt.HashedToken == token {
+		t.Error("Generate must return a prefixed token and only keep its hash")
+	}
+	if err := pt.Insert(db); err != nil {
+		t.Fatal("Failed to insert personal token to db: ", err)
+	}
+	if pt.ExpiresAt() != nil || pt.Validate() != nil {
+		t.Error("Expected the personal token to never expire")
+	}
+	if !pt.HasScope("hub") || pt.HasScope("user") {
+		t.Errorf("Unexpected scope %s", pt.Scope)
+	}
+
+	// it is found by the plain token only
+	pt1 := &data.PersonalToken{}
+	if err := pt1.GetByToken(db, token); err != nil || pt1.ID != pt.ID {
+		t.Error("Failed to get personal token by token: ", err)
+	}
+	if err := pt1.GetByToken(db, pt.HashedToken); err == nil {
+		t.Error("Expected the hash not to be accepted as a token")
+	}
+
+	// an expired token is listed but not valid
+	expiresIn := time.Nanosecond.Nanoseconds()
+	expired := &data.PersonalToken{UserID: u.ID, Name: "old", Scope: "user", ExpiresIn: &expiresIn}
+	if _, err := expired.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expired.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := expired.Validate(); err == nil {
+		t.Error("Expected the personal token to be expired")
+	}
+
+	// revoked tokens are not listed
+	if err := pt.Revoke(db); err != nil {
+		t.Error("Failed to revoke personal token: ", err)
+	}
+	if err := pt.Validate(); err == nil {
+		t.Error("Expected the revoked personal token not to be valid")
+	}
+	pts := data.PersonalTokens{}
+	if err := pts.SelectByUserID(db, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(pts) != 1 || pts[0].ID != expired.ID {
+		t.Errorf("Expected only the expired personal token to be listed, Got %+v", pts)
+	}
+
+	db.Close()
+}
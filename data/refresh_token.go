@@ -0,0 +1,127 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// RefreshToken is a long-lived credential exchanged for a new access token.
+// Refresh tokens are single use; every exchange rotates it to a new one in
+// the same family. The family is identified by the first access token issued.
+type RefreshToken struct {
+	ID          int64  `db:"id"`
+	TokenID     int64  `db:"token_id"`
+	UserID      int64  `db:"user_id"`
+	FamilyID    int64  `db:"family_id"`
+	HashedToken string `db:"hashed_token"`
+	Scope       string `db:"scope"`
+	ExpiresIn   int64  `db:"expires_in"`
+	ClientID    *int64 `db:"client_id"`
+
+	CreatedAt *time.Time `db:"created_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
+
+// Generate creates a new random refresh token and sets its hash.
+// The returned plain token is not persisted and must be handed to the client.
+func (rt *RefreshToken) Generate() (string, error) {
+	token, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+	rt.HashedToken = hashSecret(token)
+	return token, nil
+}
+
+func (rt *RefreshToken) Insert(db *sqlx.DB) error {
+	nstmt, err := db.PrepareNamed(`INSERT INTO refresh_tokens
+	(token_id, user_id, family_id, hashed_token, scope, expires_in, client_id)
+	VALUES (:token_id, :user_id, :family_id, :hashed_token, :scope, :expires_in, :client_id)
+	RETURNING *;
+	`)
+	if err != nil {
+		return err
+	}
+	defer nstmt.Close()
+
+	err = nstmt.QueryRow(rt).StructScan(rt)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	return err
+}
+
+// GetByToken finds a refresh token by its plain value.
+func (rt *RefreshToken) GetByToken(db *sqlx.DB, token string) error {
+	err := db.Get(rt, "SELECT * FROM refresh_tokens WHERE hashed_token = $1 LIMIT 1;", hashSecret(token))
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "refresh token not found"}
+	}
+	return err
+}
+
+// Use marks the refresh token as exchanged. It fails with a token_reused error
+// if the token was already used or revoked, which includes losing a race
+// against a concurrent exchange of the same token.
+func (rt *RefreshToken) Use(db *sqlx.DB) error {
+	err := db.Get(rt, `UPDATE refresh_tokens
+	SET used_at = now()
+	WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL
+	RETURNING *;
+	`, rt.ID)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"token_reused", "refresh token was already used"}
+	}
+	return err
+}
+
+// RevokeFamily revokes every refresh token in the token's family along with
+// the access tokens issued with them.
+func (rt *RefreshToken) RevokeFamily(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE tokens
+	SET revoked_at = now()
+	WHERE revoked_at IS NULL
+	AND id IN (SELECT token_id FROM refresh_tokens WHERE family_id = $1);
+	`, rt.FamilyID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE refresh_tokens
+	SET revoked_at = now()
+	WHERE family_id = $1 AND revoked_at IS NULL;
+	`, rt.FamilyID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
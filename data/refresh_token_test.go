@@ -0,0 +1,162 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestRefreshTokenInsert(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	// insert an access token for the user
+	tok := &data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "hub",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Error("Failed to insert token to db: %v", tok)
+	}
+
+	// insert a refresh token starting a new family
+	rt := &data.RefreshToken{
+		TokenID:   tok.ID,
+		UserID:    u.ID,
+		FamilyID:  tok.ID,
+		Scope:     tok.Scope,
+		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(),
+	}
+	plain, err := rt.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain == "" || rt.HashedToken == "" || plain == rt.HashedToken {
+		t.Error("Generate must return the plain token and only keep its hash")
+	}
+	if err := rt.Insert(db); err != nil {
+		t.Error("Failed to insert refresh token to db: %v", rt)
+	}
+
+	// check if returned values are scanned back to the struct
+	if rt.ID == 0 {
+		t.Error("ID must be set")
+	}
+
+	if rt.CreatedAt == nil {
+		t.Error("CreatedAt must be set")
+	}
+
+	if rt.UsedAt != nil || rt.RevokedAt != nil {
+		t.Error("UsedAt and RevokedAt must be nil")
+	}
+
+	if rt.Expired() {
+		t.Error("Refresh token must not be expired")
+	}
+
+	// query for the inserted refresh token by its plain value
+	rt1 := &data.RefreshToken{}
+	if err := rt1.GetByToken(db, plain); err != nil {
+		t.Error("Failed to find refresh token")
+	}
+	if rt1.ID != rt.ID {
+		t.Error("Unexpected refresh token returned: %v", rt1)
+	}
+
+	// query for a non-existing refresh token
+	rt2 := &data.RefreshToken{}
+	err = rt2.GetByToken(db, "invalid")
+	e, ok := err.(*data.Error)
+	if !ok {
+		t.Error("Returned error must be of type `data.Error`")
+	}
+	if e.Code != "record_not_found" {
+		t.Error("Error code must be 'record_not_found' but received %s", e.Code)
+	}
+
+	db.Close()
+}
+
+func TestRefreshTokenUseAndRevokeFamily(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	tok := &data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "hub",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Error("Failed to insert token to db: %v", tok)
+	}
+
+	rt := &data.RefreshToken{
+		TokenID:   tok.ID,
+		UserID:    u.ID,
+		FamilyID:  tok.ID,
+		Scope:     tok.Scope,
+		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(),
+	}
+	if _, err := rt.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Insert(db); err != nil {
+		t.Error("Failed to insert refresh token to db: %v", rt)
+	}
+
+	// first use succeeds
+	if err := rt.Use(db); err != nil {
+		t.Error("Failed to use refresh token: ", err)
+	}
+	if rt.UsedAt == nil {
+		t.Error("UsedAt must be set")
+	}
+
+	// second use is detected as reuse
+	err := rt.Use(db)
+	e, ok := err.(*data.Error)
+	if !ok {
+		t.Error("Returned error must be of type `data.Error`")
+	}
+	if e.Code != "token_reused" {
+		t.Error("Error code must be 'token_reused' but received %s", e.Code)
+	}
+
+	// revoking the family revokes the access token too
+	if err := rt.RevokeFamily(db); err != nil {
+		t.Error("Failed to revoke refresh token family: ", err)
+	}
+	tok1 := &data.Token{}
+	if err := tok1.Get(db, tok.ID); err != nil {
+		t.Error("Failed to find token for id: ", tok.ID)
+	}
+	if tok1.RevokedAt == nil {
+		t.Error("Access token must be revoked with its family")
+	}
+
+	db.Close()
+}
@@ -0,0 +1,24 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// generateSecret returns a random, hex encoded secret of n bytes.
+func generateSecret(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashSecret returns the SHA-256 digest of a secret, hex encoded.
+// Secrets are random and long enough that a fast hash is sufficient;
+// only the digest is stored so a leaked table can't be replayed.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
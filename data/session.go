@@ -0,0 +1,39 @@
+package data
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Session is a live token of a user, along with the name of the client or
+// hub it was issued to, if any.
+type Session struct {
+	Token
+	ClientName *string `db:"client_name"`
+	HubSlug    *string `db:"hub_slug"`
+}
+
+type Sessions []Session
+
+// SelectByUserID selects the latest tokens of the user that are neither
+// revoked nor expired, most recently created first.
+func (s *Sessions) SelectByUserID(db *sqlx.DB, userID int64, limit int) error {
+	// expiry is derived from the row as in expiry.go; expires_in is in nanoseconds
+	*s = Sessions{}
+	err := db.Select(s, `SELECT tokens.*, oauth_clients.name AS client_name, hubs.slug AS hub_slug
+	FROM tokens
+	LEFT JOIN oauth_clients ON oauth_clients.id = tokens.client_id
+	LEFT JOIN hubs ON hubs.id = tokens.hub_id
+	WHERE tokens.user_id = $1 AND tokens.revoked_at IS NULL
+	AND tokens.created_at + (tokens.expires_in / 1000) * interval '1 microsecond' > now()
+	ORDER BY tokens.created_at DESC, tokens.id DESC
+	LIMIT $2;
+	`, userID, limit)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+	return err
+}
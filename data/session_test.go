@@ -0,0 +1,57 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestSessionsSelectByUserID(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	// insert live, expired and revoked tokens
+	insertToken := func(expiresIn time.Duration) *data.Token {
+		tok := &data.Token{UserID: u.ID, ExpiresIn: expiresIn.Nanoseconds(), Scope: "user"}
+		if err := tok.Insert(db); err != nil {
+			t.Fatal("Failed to insert token to db: ", err)
+		}
+		return tok
+	}
+	older := insertToken(time.Hour)
+	newer := insertToken(time.Hour)
+	insertToken(time.Nanosecond)
+	if err := insertToken(time.Hour).Revoke(db); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := data.Sessions{}
+	if err := sessions.SelectByUserID(db, u.ID, 10); err != nil {
+		t.Fatal("Failed to select sessions: ", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != newer.ID || sessions[1].ID != older.ID {
+		t.Errorf("Expected only the live tokens, newest first, Got %+v", sessions)
+	}
+
+	// only the latest are selected
+	if err := sessions.SelectByUserID(db, u.ID, 1); err != nil {
+		t.Fatal("Failed to select sessions: ", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != newer.ID {
+		t.Errorf("Expected only the latest token, Got %+v", sessions)
+	}
+
+	db.Close()
+}
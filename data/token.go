@@ -2,26 +2,43 @@ package data
 
 import (
 	"database/sql"
+	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
+	"github.com/ripple-cloud/cloud/keyring"
 )
 
+// Scopes lists every scope a token can be granted.
+// A route declares the scope it requires (see handlers.Auth).
+var Scopes = []string{"user", "hub", "app"}
+
+// DefaultScope is granted when a token request does not ask for a scope.
+var DefaultScope = strings.Join(Scopes, " ")
+
+// DeviceScope is granted only to tokens issued to hubs with their own
+// credential. It is not in Scopes, so users can't request it.
+const DeviceScope = "device"
+
 type Token struct {
-	ID        int64 `db:"id"`
-	UserID    int64 `db:"user_id"`
-	ExpiresIn int64 `db:"expires_in"`
+	ID        int64  `db:"id"`
+	UserID    int64  `db:"user_id"`
+	ExpiresIn int64  `db:"expires_in"`
+	Scope     string `db:"scope"`     // space-delimited list of scopes
+	ClientID  *int64 `db:"client_id"` // set if issued to a registered client
+	HubID     *int64 `db:"hub_id"`    // set if issued to a hub; UserID is its owner
 
-	CreatedAt *time.Time `db:"created_at"`
-	RevokedAt *time.Time `db:"revoked_at"`
+	CreatedAt  *time.Time `db:"created_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+	LastUsedAt *time.Time `db:"last_used_at"` // updated at most every few minutes (see Touch)
+	LastUsedIP *string    `db:"last_used_ip"`
 }
 
 func (t *Token) Insert(db *sqlx.DB) error {
 	nstmt, err := db.PrepareNamed(`INSERT INTO tokens
-	(user_id, expires_in)
-	VALUES (:user_id, :expires_in)
+	(user_id, expires_in, scope, client_id, hub_id)
+	VALUES (:user_id, :expires_in, :scope, :client_id, :hub_id)
 	RETURNING *;
 	`)
 	if err != nil {
@@ -54,14 +71,147 @@ func (t *Token) Get(db *sqlx.DB, id int64) error {
 	return err
 }
 
-// Encode JWT will return the current token encoded as a JSON web token.
+// Revoke marks the token as revoked along with the refresh tokens issued with it.
+// Revoking an already revoked token keeps its original RevokedAt.
+func (t *Token) Revoke(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Get(t, `UPDATE tokens
+	SET revoked_at = COALESCE(revoked_at, now())
+	WHERE id = $1
+	RETURNING *;
+	`, t.ID)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return &Error{"record_not_found", "token not found"}
+		}
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE refresh_tokens
+	SET revoked_at = now()
+	WHERE token_id = $1 AND revoked_at IS NULL;
+	`, t.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Touch records that the token was used from the given IP. To avoid a write
+// on every request, nothing is saved if the token was last used from the
+// same IP less than `every` ago.
+func (t *Token) Touch(db *sqlx.DB, ip string, every time.Duration) error {
+	if t.LastUsedAt != nil && time.Since(*t.LastUsedAt) < every && t.LastUsedIP != nil && *t.LastUsedIP == ip {
+		return nil
+	}
+
+	err := db.Get(t, `UPDATE tokens
+	SET last_used_at = now(), last_used_ip = $2
+	WHERE id = $1
+	RETURNING *;
+	`, t.ID, ip)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "token not found"}
+	}
+	return err
+}
+
+// Shorten makes the token expire d from now, unless it already expires sooner.
+// A live token can be cut short this way without revoking it.
+func (t *Token) Shorten(db *sqlx.DB, d time.Duration) error {
+	expiresIn := time.Now().Add(d).Sub(*t.CreatedAt)
+	if expiresIn < 0 {
+		expiresIn = 0
+	}
+
+	err := db.Get(t, `UPDATE tokens
+	SET expires_in = LEAST(expires_in, $2)
+	WHERE id = $1
+	RETURNING *;
+	`, t.ID, expiresIn.Nanoseconds())
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "token not found"}
+	}
+	return err
+}
+
+// HasScope reports whether the token was granted the given scope.
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range strings.Fields(t.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidScope reports whether every scope in a space-delimited list is known.
+// An empty list is not valid.
+func ValidScope(scope string) bool {
+	fields := strings.Fields(scope)
+	if len(fields) == 0 {
+		return false
+	}
+	for _, f := range fields {
+		known := false
+		for _, s := range Scopes {
+			if f == s {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
+
+// ScopeWithin reports whether every scope requested was also granted.
+func ScopeWithin(requested, granted string) bool {
+	t := Token{Scope: granted}
+	for _, s := range strings.Fields(requested) {
+		if !t.HasScope(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode JWT will return the current token encoded as a JSON web token,
+// signed with the keyring's signing key.
 // Note the encoded token is not persisted
-func (t *Token) EncodeJWT(tokenSecret []byte) (string, error) {
-	j := jwt.New(jwt.SigningMethodHS256)
-	j.Claims["iat"] = t.CreatedAt.Unix()                                 // issued at
-	j.Claims["exp"] = t.CreatedAt.Add(time.Duration(t.ExpiresIn)).Unix() // expires at
-	j.Claims["jti"] = t.ID                                               // token ID
-	j.Claims["user_id"] = t.UserID
-	//j.Claims["scopes"] = "user,hub,app" // FIXME: should not be hardcoded
-	return j.SignedString(tokenSecret)
+func (t *Token) EncodeJWT(keys *keyring.Keyring) (string, error) {
+	claims := map[string]interface{}{}
+	claims["iat"] = t.CreatedAt.Unix()   // issued at
+	claims["exp"] = t.ExpiresAt().Unix() // expires at
+	claims["jti"] = t.ID                 // token ID
+	if t.HubID != nil {
+		claims["hub_id"] = *t.HubID
+	} else {
+		claims["user_id"] = t.UserID
+	}
+	claims["scope"] = t.Scope
+	return keys.Sign(claims)
 }
@@ -109,3 +109,257 @@ func TestTokenGet(t *testing.T) {
 	// TODO: Add a test case of other errors (eg: db already closed)
 	db.Close()
 }
+
+func TestTokenRevoke(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	// insert tokens for the user
+	tok := &data.Token{
+		UserID:    u.ID,
+		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(),
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Error("Failed to insert token to db: %v", tok)
+	}
+	tok1 := &data.Token{
+		UserID:    u.ID,
+		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(),
+	}
+	if err := tok1.Insert(db); err != nil {
+		t.Error("Failed to insert token to db: %v", tok1)
+	}
+
+	// revoke a single token
+	if err := tok.Revoke(db); err != nil {
+		t.Error("Failed to revoke token: ", err)
+	}
+	if tok.RevokedAt == nil {
+		t.Error("RevokedAt must be set")
+	}
+
+	// revoke a non-existing token
+	tok2 := &data.Token{ID: 9999}
+	err := tok2.Revoke(db)
+	e, ok := err.(*data.Error)
+	if !ok {
+		t.Error("Returned error must be of type `data.Error`")
+	}
+	if e.Code != "record_not_found" {
+		t.Error("Error code must be 'record_not_found' but received %s", e.Code)
+	}
+
+	pt := &data.PersonalToken{UserID: u.ID, Name: "deploy script", Scope: "hub"}
+	if _, err := pt.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.Insert(db); err != nil {
+		t.Fatal("Failed to insert personal token to db: ", err)
+	}
+
+	// revoke all tokens of the user
+	if err := u.RevokeTokens(db); err != nil {
+		t.Error("Failed to revoke user tokens: ", err)
+	}
+	if err := tok1.Get(db, tok1.ID); err != nil {
+		t.Error("Failed to find token for id: ", tok1.ID)
+	}
+	if tok1.RevokedAt == nil {
+		t.Error("RevokedAt must be set for every token of the user")
+	}
+	if err := pt.Get(db, pt.ID); err != nil {
+		t.Error("Failed to find personal token for id: ", pt.ID)
+	}
+	if pt.RevokedAt == nil {
+		t.Error("RevokedAt must be set for every personal token of the user")
+	}
+
+	db.Close()
+}
+
+func TestTokenExpiry(t *testing.T) {
+	createdAt := time.Now().Add(-2 * time.Hour)
+
+	// a token created 2 hours ago living for 3 hours
+	tok := &data.Token{
+		CreatedAt: &createdAt,
+		ExpiresIn: (3 * time.Hour).Nanoseconds(),
+	}
+	if !tok.ExpiresAt().Equal(createdAt.Add(3 * time.Hour)) {
+		t.Errorf("Expected ExpiresAt to be %v, Got %v", createdAt.Add(3*time.Hour), tok.ExpiresAt())
+	}
+	if tok.Expired() {
+		t.Error("Expected token not to be expired")
+	}
+	if s := tok.ExpiresInSeconds(); s < 3590 || s > 3600 {
+		t.Errorf("Expected ExpiresInSeconds to be about 3600, Got %v", s)
+	}
+	if err := tok.Validate(); err != nil {
+		t.Errorf("Expected token to be valid, Got %v", err)
+	}
+
+	// a token created 2 hours ago living for 1 hour
+	tok.ExpiresIn = time.Hour.Nanoseconds()
+	if !tok.Expired() {
+		t.Error("Expected token to be expired")
+	}
+	if s := tok.ExpiresInSeconds(); s != 0 {
+		t.Errorf("Expected ExpiresInSeconds to be 0, Got %v", s)
+	}
+	if e, ok := tok.Validate().(*data.Error); !ok || e.Desc != "token is expired" {
+		t.Errorf("Expected an expired token error, Got %v", e)
+	}
+
+	// a revoked token
+	tok.ExpiresIn = (3 * time.Hour).Nanoseconds()
+	tok.RevokedAt = &createdAt
+	if e, ok := tok.Validate().(*data.Error); !ok || e.Code != "invalid_token" {
+		t.Errorf("Expected an invalid token error, Got %v", e)
+	}
+}
+
+func TestTokenShorten(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	// insert a new token for the user
+	tok := &data.Token{
+		UserID:    u.ID,
+		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(),
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Error("Failed to insert token to db: %v", tok)
+	}
+
+	// shorten the token to an hour
+	if err := tok.Shorten(db, time.Hour); err != nil {
+		t.Error("Failed to shorten token: ", err)
+	}
+	if s := tok.ExpiresInSeconds(); s > 3600 || s < 3500 {
+		t.Errorf("Expected token to expire in about an hour, Got %v seconds", s)
+	}
+
+	// shortening never extends the token
+	if err := tok.Shorten(db, 24*time.Hour); err != nil {
+		t.Error("Failed to shorten token: ", err)
+	}
+	if s := tok.ExpiresInSeconds(); s > 3600 {
+		t.Errorf("Expected token to still expire in about an hour, Got %v seconds", s)
+	}
+
+	// expire the token right away
+	if err := tok.Shorten(db, 0); err != nil {
+		t.Error("Failed to shorten token: ", err)
+	}
+	if !tok.Expired() {
+		t.Error("Expected token to be expired")
+	}
+
+	db.Close()
+}
+
+func TestTokenTouch(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	// insert a new token for the user
+	tok := &data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Error("Failed to insert token to db: %v", tok)
+	}
+
+	// the first use is recorded
+	if err := tok.Touch(db, "203.0.113.7", time.Minute); err != nil {
+		t.Error("Failed to touch token: ", err)
+	}
+	if tok.LastUsedAt == nil || tok.LastUsedIP == nil || *tok.LastUsedIP != "203.0.113.7" {
+		t.Fatal("Expected the last use to be recorded")
+	}
+	first := *tok.LastUsedAt
+
+	// a use from the same IP shortly after is not saved
+	if err := tok.Touch(db, "203.0.113.7", time.Minute); err != nil {
+		t.Error("Failed to touch token: ", err)
+	}
+	if !tok.LastUsedAt.Equal(first) {
+		t.Error("Expected the last use not to be saved again")
+	}
+
+	// a use from another IP is saved
+	if err := tok.Touch(db, "198.51.100.1", time.Minute); err != nil {
+		t.Error("Failed to touch token: ", err)
+	}
+	tok1 := &data.Token{}
+	if err := tok1.Get(db, tok.ID); err != nil {
+		t.Error("Failed to find token for id: ", tok.ID)
+	}
+	if tok1.LastUsedIP == nil || *tok1.LastUsedIP != "198.51.100.1" || tok1.LastUsedAt.Before(first) {
+		t.Error("Expected the last use from another IP to be saved")
+	}
+
+	db.Close()
+}
+
+func TestTokenHasScope(t *testing.T) {
+	tok := &data.Token{Scope: "hub app"}
+
+	if !tok.HasScope("hub") {
+		t.Error("Expected HasScope to return true for a granted scope")
+	}
+
+	if tok.HasScope("user") {
+		t.Error("Expected HasScope to return false for a scope not granted")
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	type testCase struct {
+		scope string
+		valid bool
+	}
+
+	tCases := []testCase{
+		{"user hub app", true},
+		{"hub", true},
+		{"hub admin", false},
+		{"", false},
+	}
+	for _, tc := range tCases {
+		if v := data.ValidScope(tc.scope); v != tc.valid {
+			t.Errorf("%q - Expected ValidScope to return %v, Got %v", tc.scope, tc.valid, v)
+		}
+	}
+}
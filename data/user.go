@@ -2,6 +2,7 @@ package data
 
 import (
 	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -14,14 +15,23 @@ type User struct {
 	Username          string     `db:"username" json:"username"`
 	Email             string     `db:"email" json:"email"`
 	EncryptedPassword string     `db:"encrypted_password" json:"-"`
+	EmailVerifiedAt   *time.Time `db:"email_verified_at" json:"email_verified_at"`
+	DeleteAfter       *time.Time `db:"delete_after" json:"delete_after,omitempty"` // set while deletion is scheduled
+	OTPSecret         *string    `db:"otp_secret" json:"-"`
+	OTPEnabledAt      *time.Time `db:"otp_enabled_at" json:"otp_enabled_at"`
+	OTPLastCounter    *int64     `db:"otp_last_counter" json:"-"`
 	CreatedAt         *time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt         *time.Time `db:"updated_at" json:"updated_at"`
 }
 
+// PasswordCost is the bcrypt cost of newly encrypted passwords.
+// Passwords encrypted with a lower cost are upgraded on login (see RehashPassword).
+var PasswordCost = bcrypt.DefaultCost
+
 // EncryptPassword accepts a password as a string and encrytps it using bcrypt.
 // Encrypted value will be stored in user's EncryptedPassword field.
 func (u *User) EncryptPassword(password string) error {
-	ep, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	ep, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
 	if err != nil {
 		return err
 	}
@@ -36,10 +46,31 @@ func (u *User) VerifyPassword(password string) bool {
 	return true
 }
 
+// RehashPassword encrypts the password again if it was encrypted with a lower
+// cost than PasswordCost. It must only be called with a verified password.
+// Unlike ChangePassword, the user's tokens are kept.
+func (u *User) RehashPassword(db *sqlx.DB, password string) error {
+	cost, err := bcrypt.Cost([]byte(u.EncryptedPassword))
+	if err != nil || cost >= PasswordCost {
+		return err
+	}
+
+	if err := u.EncryptPassword(password); err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE users SET encrypted_password = $2 WHERE id = $1;", u.ID, u.EncryptedPassword)
+	return err
+}
+
+// EmailVerified reports whether the user proved they own their email address.
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
 func (u *User) Insert(db *sqlx.DB) error {
 	nstmt, err := db.PrepareNamed(`INSERT INTO users
-	(username, email, encrypted_password, created_at, updated_at)
-	VALUES (:username, :email, :encrypted_password, now(), now())
+	(username, email, encrypted_password, email_verified_at, created_at, updated_at)
+	VALUES (:username, :email, :encrypted_password, :email_verified_at, now(), now())
 	RETURNING *;
 	`)
 	if err != nil {
@@ -59,6 +90,70 @@ func (u *User) Insert(db *sqlx.DB) error {
 	return err
 }
 
+// Update saves the user's username, email and email verification.
+func (u *User) Update(db *sqlx.DB) error {
+	nstmt, err := db.PrepareNamed(`UPDATE users
+	SET username = :username, email = :email, email_verified_at = :email_verified_at, updated_at = now()
+	WHERE id = :id
+	RETURNING *;
+	`)
+	if err != nil {
+		return err
+	}
+	defer nstmt.Close()
+
+	err = nstmt.QueryRow(u).StructScan(u)
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code.Name() {
+		case "unique_violation":
+			// tell which field is taken
+			if strings.Contains(err.Constraint, "email") {
+				return &Error{"unique_violation", "email exists"}
+			}
+			return &Error{"unique_violation", "username exists"}
+		default:
+			return &Error{err.Code.Name(), "pq error"}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return &Error{"record_not_found", "user not found"}
+	}
+	return err
+}
+
+// ChangePassword sets a new password and revokes every token the user had.
+func (u *User) ChangePassword(db *sqlx.DB, password string) error {
+	if err := u.EncryptPassword(password); err != nil {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Get(u, `UPDATE users
+	SET encrypted_password = $2, updated_at = now()
+	WHERE id = $1
+	RETURNING *;
+	`, u.ID, u.EncryptedPassword)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return &Error{"record_not_found", "user not found"}
+		}
+		return err
+	}
+
+	if err := revokeUserTokens(tx, u.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (u *User) Get(db *sqlx.DB, id int64) error {
 	err := db.Get(u, "SELECT * FROM users WHERE id = $1 LIMIT 1;", id)
 	switch err {
@@ -72,7 +167,7 @@ func (u *User) Get(db *sqlx.DB, id int64) error {
 }
 
 func (u *User) GetByLogin(db *sqlx.DB, login string) error {
-	err := db.Get(u, "SELECT * FROM users WHERE username = $1 OR email = $1 LIMIT 1;", login)
+	err := db.Get(u, "SELECT * FROM users WHERE lower(username) = lower($1) OR lower(email) = lower($1) LIMIT 1;", login)
 	switch err {
 	case nil:
 		return nil
@@ -82,3 +177,105 @@ func (u *User) GetByLogin(db *sqlx.DB, login string) error {
 		return err
 	}
 }
+
+// ScheduleDeletion marks the user to be deleted once grace has passed (see
+// DeleteScheduledUsers) and revokes every token of the user and their hubs,
+// personal tokens included.
+func (u *User) ScheduleDeletion(db *sqlx.DB, grace time.Duration) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Get(u, `UPDATE users
+	SET delete_after = now() + $2::float8 * interval '1 second', updated_at = now()
+	WHERE id = $1
+	RETURNING *;
+	`, u.ID, grace.Seconds())
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return &Error{"record_not_found", "user not found"}
+		}
+		return err
+	}
+
+	// unlike revokeUserTokens, hubs lose their tokens too
+	_, err = tx.Exec("UPDATE tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL;", u.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL;", u.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE personal_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL;", u.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelDeletion cancels a scheduled deletion. Revoked tokens stay revoked.
+func (u *User) CancelDeletion(db *sqlx.DB) error {
+	err := db.Get(u, `UPDATE users
+	SET delete_after = NULL, updated_at = now()
+	WHERE id = $1 AND delete_after IS NOT NULL
+	RETURNING *;
+	`, u.ID)
+	if err == sql.ErrNoRows {
+		return &Error{"invalid_request", "user is not scheduled for deletion"}
+	}
+	return err
+}
+
+// DeletionScheduled reports whether the user asked to delete their account.
+func (u *User) DeletionScheduled() bool {
+	return u.DeleteAfter != nil
+}
+
+// DeleteScheduledUsers deletes the users whose grace period has passed, along
+// with everything they own. Returns the number of users deleted.
+func DeleteScheduledUsers(db *sqlx.DB) (int64, error) {
+	r, err := db.Exec("DELETE FROM users WHERE delete_after <= now();")
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
+
+// RevokeTokens revokes every access, refresh and personal token issued to
+// the user. Tokens issued to the user's hubs are kept.
+func (u *User) RevokeTokens(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if err := revokeUserTokens(tx, u.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// revokeUserTokens revokes the user's tokens as part of a larger transaction.
+func revokeUserTokens(tx *sqlx.Tx, userID int64) error {
+	_, err := tx.Exec("UPDATE tokens SET revoked_at = now() WHERE user_id = $1 AND hub_id IS NULL AND revoked_at IS NULL;", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL;", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE personal_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL;", userID)
+	return err
+}
@@ -2,9 +2,11 @@ package data_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/ripple-cloud/cloud/data"
 	"github.com/ripple-cloud/cloud/testhelpers"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestEncryptPassword(t *testing.T) {
@@ -179,3 +181,229 @@ func TestGet(t *testing.T) {
 	// TODO: Add a test case of other errors (eg: db already closed)
 	db.Close()
 }
+
+func TestUpdate(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new users
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+	u2 := &data.User{
+		Username:          "brucelee",
+		Email:             "gmail@brucelee.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u2.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u2)
+	}
+
+	// update the username
+	createdAt, updatedAt := *u.CreatedAt, *u.UpdatedAt
+	u.Username = "chuck"
+	if err := u.Update(db); err != nil {
+		t.Error("Failed to update user: ", err)
+	}
+	if u.Username != "chuck" || !u.CreatedAt.Equal(createdAt) || !u.UpdatedAt.After(updatedAt) {
+		t.Error("Update must save the username and maintain UpdatedAt: %v", u)
+	}
+
+	// check if taking another user's email violates unique constraint
+	u.Email = u2.Email
+	err := u.Update(db)
+	e, ok := err.(*data.Error)
+	if !ok {
+		t.Error("Returned error must be of type `data.Error`")
+	}
+	if e.Code != "unique_violation" || e.Desc != "email exists" {
+		t.Error("Expected 'email exists' unique violation, received %v", e)
+	}
+
+	db.Close()
+}
+
+func TestChangePassword(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user
+	u := &data.User{
+		Username: "chucknorris",
+		Email:    "gmail@chucknorris.com",
+	}
+	if err := u.EncryptPassword("wood-chuck-chuck"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	// insert a token for the user
+	tok := &data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "user",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Error("Failed to insert token to db: %v", tok)
+	}
+
+	pt := &data.PersonalToken{UserID: u.ID, Name: "deploy script", Scope: "user"}
+	if _, err := pt.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.Insert(db); err != nil {
+		t.Fatal("Failed to insert personal token to db: ", err)
+	}
+
+	if err := u.ChangePassword(db, "chuck-wood-wood"); err != nil {
+		t.Error("Failed to change password: ", err)
+	}
+
+	// the new password is persisted
+	u1 := &data.User{}
+	if err := u1.Get(db, u.ID); err != nil {
+		t.Error("Failed to get user with id: ", u.ID)
+	}
+	if !u1.VerifyPassword("chuck-wood-wood") || u1.VerifyPassword("wood-chuck-chuck") {
+		t.Error("ChangePassword must save the new password")
+	}
+
+	// existing tokens are revoked
+	if err := tok.Get(db, tok.ID); err != nil {
+		t.Error("Failed to find token for id: ", tok.ID)
+	}
+	if tok.RevokedAt == nil {
+		t.Error("Tokens must be revoked when the password changes")
+	}
+	if err := pt.Get(db, pt.ID); err != nil {
+		t.Error("Failed to find personal token for id: ", pt.ID)
+	}
+	if pt.RevokedAt == nil {
+		t.Error("Personal tokens must be revoked when the password changes")
+	}
+
+	db.Close()
+}
+
+func TestRehashPassword(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert a user with a password encrypted at the minimum cost
+	cost := data.PasswordCost
+	data.PasswordCost = bcrypt.MinCost
+	u := &data.User{
+		Username: "chucknorris",
+		Email:    "gmail@chucknorris.com",
+	}
+	if err := u.EncryptPassword("wood-chuck-chuck"); err != nil {
+		t.Fatal(err)
+	}
+	data.PasswordCost = cost
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+
+	if err := u.RehashPassword(db, "wood-chuck-chuck"); err != nil {
+		t.Error("Failed to rehash password: ", err)
+	}
+
+	// the password is saved with the configured cost
+	u1 := &data.User{}
+	if err := u1.Get(db, u.ID); err != nil {
+		t.Error("Failed to get user with id: ", u.ID)
+	}
+	if c, _ := bcrypt.Cost([]byte(u1.EncryptedPassword)); c != data.PasswordCost {
+		t.Errorf("Expected password cost to be %d, Got %d", data.PasswordCost, c)
+	}
+	if !u1.VerifyPassword("wood-chuck-chuck") {
+		t.Error("RehashPassword must keep the password")
+	}
+
+	db.Close()
+}
+
+func TestScheduleDeletion(t *testing.T) {
+	// setup database
+	db := testhelpers.SetupDB(t)
+
+	// insert new user owning a hub and a token
+	u := &data.User{
+		Username:          "chucknorris",
+		Email:             "gmail@chucknorris.com",
+		EncryptedPassword: "wood-chuck-chuck",
+	}
+	if err := u.Insert(db); err != nil {
+		t.Error("Failed to insert user to db: %v", u)
+	}
+	h := &data.Hub{
+		Slug:   "earthworm",
+		UserID: u.ID,
+	}
+	if err := h.Insert(db); err != nil {
+		t.Error("Failed to insert hub to db: %v", h)
+	}
+	tok := &data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "user",
+		HubID:     &h.ID,
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Error("Failed to insert token to db: %v", tok)
+	}
+
+	// schedule and cancel the deletion
+	if err := u.ScheduleDeletion(db, time.Hour); err != nil {
+		t.Error("Failed to schedule deletion: ", err)
+	}
+	if !u.DeletionScheduled() {
+		t.Error("DeleteAfter must be set")
+	}
+	if err := tok.Get(db, tok.ID); err != nil || tok.RevokedAt == nil {
+		t.Error("Tokens of the user and their hubs must be revoked")
+	}
+	if err := u.CancelDeletion(db); err != nil {
+		t.Error("Failed to cancel deletion: ", err)
+	}
+	if u.DeletionScheduled() {
+		t.Error("DeleteAfter must be cleared")
+	}
+	if err := u.CancelDeletion(db); err == nil {
+		t.Error("Cancelling twice should return an error")
+	}
+
+	// users are only deleted once the grace period has passed
+	if err := u.ScheduleDeletion(db, time.Hour); err != nil {
+		t.Error("Failed to schedule deletion: ", err)
+	}
+	if n, err := data.DeleteScheduledUsers(db); err != nil || n != 0 {
+		t.Error("Expected no user to be deleted, Got %d %v", n, err)
+	}
+	if err := u.ScheduleDeletion(db, 0); err != nil {
+		t.Error("Failed to schedule deletion: ", err)
+	}
+	if n, err := data.DeleteScheduledUsers(db); err != nil || n != 1 {
+		t.Error("Expected the user to be deleted, Got %d %v", n, err)
+	}
+
+	// everything the user owned is deleted
+	if err := u.Get(db, u.ID); err == nil {
+		t.Error("Expected the user to be deleted")
+	}
+	if err := h.GetByID(db, h.ID); err == nil {
+		t.Error("Expected the hub to be deleted")
+	}
+	if err := tok.Get(db, tok.ID); err == nil {
+		t.Error("Expected the token to be deleted")
+	}
+
+	db.Close()
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Usernames are 3 to 32 letters, digits, - or _, starting with a letter or
+// digit. They can't contain @ so they are never mistaken for an email.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{2,31}$`)
+
+func ValidateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return &Error{"invalid_username", "username must be 3 to 32 letters, digits, - or _, starting with a letter or digit"}
+	}
+	return nil
+}
+
+// NormalizeEmail validates a bare email address (eg: foo@example.com) and
+// returns it with surrounding spaces removed and its domain lowercased.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	invalid := &Error{"invalid_email", "email must be a valid address"}
+
+	if len(email) > 255 {
+		return "", invalid
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", invalid
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 1 || !strings.Contains(email[at:], ".") {
+		return "", invalid
+	}
+	return email[:at] + strings.ToLower(email[at:]), nil
+}
+
+// LoginTaken reports whether a user other than the one with exceptID has
+// the login as username or email, ignoring case.
+func LoginTaken(db *sqlx.DB, login string, exceptID int64) (bool, error) {
+	var taken bool
+	err := db.Get(&taken, `SELECT EXISTS (
+		SELECT 1 FROM users
+		WHERE (lower(username) = lower($1) OR lower(email) = lower($1)) AND id <> $2
+	);`, login, exceptID)
+	return taken, err
+}
@@ -0,0 +1,53 @@
+package data_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ripple-cloud/cloud/data"
+)
+
+func TestValidateUsername(t *testing.T) {
+	for _, username := range []string{"bob", "Bob_99", "chuck-norris", strings.Repeat("a", 32)} {
+		if err := data.ValidateUsername(username); err != nil {
+			t.Errorf("%s - Expected username to be valid, Got %v", username, err)
+		}
+	}
+
+	for _, username := range []string{"", "bo", "-bob", "bob norris", "bob@example.com", "bób", strings.Repeat("a", 33)} {
+		if err := data.ValidateUsername(username); err == nil {
+			t.Errorf("%s - Expected username to be invalid", username)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	type testCase struct {
+		email      string
+		normalized string // empty when the email is invalid
+	}
+
+	tCases := []testCase{
+		{"bob@example.com", "bob@example.com"},
+		{"  Bob@Example.COM ", "Bob@example.com"},
+		{"bob+ripple@mail.example.com", "bob+ripple@mail.example.com"},
+		{"bob", ""},
+		{"bob@localhost", ""},
+		{"@example.com", ""},
+		{"Bob <bob@example.com>", ""},
+		{"bob@example.com, eve@example.com", ""},
+	}
+
+	for _, tc := range tCases {
+		email, err := data.NormalizeEmail(tc.email)
+		if tc.normalized == "" {
+			if e, ok := err.(*data.Error); !ok || e.Code != "invalid_email" {
+				t.Errorf("%q - Expected invalid_email error, Got %v", tc.email, err)
+			}
+			continue
+		}
+		if err != nil || email != tc.normalized {
+			t.Errorf("%q - Expected %q, Got %q (%v)", tc.email, tc.normalized, email, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+// POST /admin/tokens/:id/expire
+// Params: (expires_in)
+// Requires ClientAuth. Makes the token expire in expires_in seconds (defaults
+// to now), unless it already expires sooner.
+func ExpireToken(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	id, err := strconv.ParseInt(c.Params.ByName("id"), 10, 64)
+	if err != nil {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "id must be a number"})
+	}
+
+	var expiresIn int64
+	if v := r.FormValue("expires_in"); v != "" {
+		expiresIn, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || expiresIn < 0 {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_request", "expires_in must be a number of seconds"})
+		}
+	}
+
+	t := data.Token{}
+	if err := t.Get(db, id); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+	if err := t.Shorten(db, time.Duration(expiresIn)*time.Second); err != nil {
+		return err
+	}
+
+	payload := struct {
+		ID        int64  `json:"id"`
+		UserID    int64  `json:"user_id"`
+		Scope     string `json:"scope"`
+		ExpiresIn int64  `json:"expires_in"`
+		ExpiresAt int64  `json:"expires_at"`
+	}{
+		t.ID,
+		t.UserID,
+		t.Scope,
+		t.ExpiresInSeconds(),
+		t.ExpiresAt().Unix(),
+	}
+
+	return res.OK(w, payload)
+}
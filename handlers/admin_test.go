@@ -0,0 +1,124 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func setupServerAdmin(db *sqlx.DB, keys *keyring.Keyring) (*httptest.Server, error) {
+	r := router.New()
+
+	r.Default(
+		handlers.SetConfig(db, keys),
+	)
+
+	r.POST("/admin/tokens/:id/expire", handlers.ClientAuth(map[string]string{"ops": "ops-secret"}), handlers.ExpireToken)
+
+	return httptest.NewServer(r), nil
+}
+
+func TestExpireToken(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerAdmin(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a user
+	u := &data.User{
+		Username: "foo",
+		Email:    "foo@example.com",
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a token for the user
+	tok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(), // 30 days
+		Scope:     "hub",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// test when the token is shortened to a minute
+	spath := "/admin/tokens/1/expire?client_id=ops&client_secret=ops-secret&expires_in=60"
+	res, err := http.Post(ts.URL+spath, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("%s - Expected status code %v, Got %v", spath, http.StatusOK, res.StatusCode)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := struct {
+		ExpiresIn int64 `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ExpiresIn > 60 {
+		t.Errorf("%s - Expected expires_in to be at most 60, Got %v", spath, payload.ExpiresIn)
+	}
+
+	type testCase struct {
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when client credentials are missing
+		{"/admin/tokens/1/expire", http.StatusUnauthorized, `{"error":"invalid_client","error_description":"client authentication failed"}`},
+
+		// when expires_in is invalid
+		{"/admin/tokens/1/expire?client_id=ops&client_secret=ops-secret&expires_in=-1", http.StatusBadRequest, `{"error":"invalid_request","error_description":"expires_in must be a number of seconds"}`},
+
+		// when token does not exist
+		{"/admin/tokens/9999/expire?client_id=ops&client_secret=ops-secret", http.StatusNotFound, `{"error":"record_not_found","error_description":"token not found"}`},
+	}
+	for _, tc := range tCases {
+		res, err := http.Post(ts.URL+tc.path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v", tc.path, tc.statusCode, res.StatusCode)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if body := string(b); body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+}
@@ -1,73 +1,175 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/jmoiron/sqlx"
 	"github.com/ripple-cloud/cloud/data"
 	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/keyring"
 	"github.com/ripple-cloud/cloud/router"
 )
 
-//var scopeRegex *regexp.Regexp
-//
-//func init() {
-//	scopeRegex = regexp.MustCompile(`^(?:/api/v\d/)([^/]+)(.*)$`) // eg: /api/v0/hub/list || /api/v0/app
-//}
+// tokenTouchInterval is how often the last use of a token is saved.
+const tokenTouchInterval = 5 * time.Minute
 
-func Auth(w http.ResponseWriter, r *http.Request, c router.Context) error {
-	db, ok := c.Meta["db"].(*sqlx.DB)
-	if !ok {
-		return errors.New("db not set in context")
+// Auth returns a middleware handler that only lets through requests carrying
+// a valid access token granted the given scope (eg: "hub").
+// Tokens issued to a hub set `hub_id` in the context instead of `user_id`;
+// only they are granted data.DeviceScope. The token's id is set as `token_id`
+// and its full scope as `scope`.
+// Personal tokens (see data.PersonalToken) are accepted too; they set
+// `user_id`, `personal_token_id` and `scope`.
+func Auth(scope string) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request, c router.Context) error {
+		db, ok := c.Meta["db"].(*sqlx.DB)
+		if !ok {
+			return errors.New("db not set in context")
+		}
+		keys, ok := c.Meta["keyring"].(*keyring.Keyring)
+		if !ok {
+			return errors.New("keyring not set in context")
+		}
+
+		// personal tokens are sent as is, other tokens as JWTs
+		if raw := requestToken(r); strings.HasPrefix(raw, data.PersonalTokenPrefix) {
+			return personalTokenAuth(w, r, c, db, raw, scope)
+		}
+
+		// parse the token param
+		token, err := jwt.ParseFromRequest(r, keys.Keyfunc)
+		if err != nil {
+			return res.Unauthorized(w, res.ErrorMsg{"invalid_token", err.Error()})
+		}
+
+		// check if the token was revoked or shortened from DB
+		t, err := verifyToken(db, token)
+		if err != nil {
+			if e, ok := err.(*data.Error); ok {
+				return res.Unauthorized(w, res.ErrorMsg{e.Code, e.Desc})
+			}
+			return err
+		}
+
+		// check if the token is eligible for current scope
+		// scopes are read from DB, the claim is only informational
+		if !t.HasScope(scope) {
+			return res.Forbidden(w, res.ErrorMsg{"insufficient_scope", "token is not valid for this scope"})
+		}
+
+		// record when and where the token was last used
+		if err := t.Touch(db, clientIP(r, c), tokenTouchInterval); err != nil {
+			return err
+		}
+
+		// valid token
+		// set the hub or user id to context and pass to next handler
+		if t.HubID != nil {
+			c.Meta["hub_id"] = *t.HubID
+		} else {
+			c.Meta["user_id"] = t.UserID
+		}
+		c.Meta["token_id"] = t.ID
+		c.Meta["scope"] = t.Scope
+
+		return c.Next(w, r, c)
+	}
+}
+
+// ClientAuth returns a middleware handler that only lets through requests
+// from one of the given clients (client id => client secret). Clients
+// authenticate with HTTP Basic auth or the client_id and client_secret params.
+func ClientAuth(clients map[string]string) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request, c router.Context) error {
+		id, secret, ok := r.BasicAuth()
+		if !ok {
+			id, secret = r.FormValue("client_id"), r.FormValue("client_secret")
+		}
+
+		expected, ok := clients[id]
+		if id == "" || !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ripple"`)
+			return res.Unauthorized(w, res.ErrorMsg{"invalid_client", "client authentication failed"})
+		}
+
+		// set the client id to context and pass to next handler
+		c.Meta["client_id"] = id
+
+		return c.Next(w, r, c)
 	}
-	tokenSecret, ok := c.Meta["tokenSecret"].([]byte)
+}
+
+// verifyToken looks up the token a parsed JWT refers to and checks it is
+// still valid. Returns a *data.Error if the token is not valid.
+func verifyToken(db *sqlx.DB, j *jwt.Token) (*data.Token, error) {
+	jti, ok := j.Claims["jti"].(float64)
 	if !ok {
-		return errors.New("token secret not set in context")
+		return nil, &data.Error{"invalid_token", "token is not valid"}
 	}
 
-	// parse the token param
-	token, err := jwt.ParseFromRequest(r, func(token *jwt.Token) (interface{}, error) {
-		return tokenSecret, nil
-	})
-	if err != nil {
-		return res.Unauthorized(w, res.ErrorMsg{"invalid_token", err.Error()})
+	t := &data.Token{}
+	if err := t.Get(db, int64(jti)); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return nil, &data.Error{"invalid_token", "token is not valid"}
+		}
+		return nil, err
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// tokenScope returns the scope of the token the request was authenticated
+// with (see Auth). Tokens can't hand out more than it.
+func tokenScope(c router.Context) string {
+	scope, _ := c.Meta["scope"].(string)
+	return scope
+}
+
+// requestToken returns the access token sent in the Authorization header
+// or the access_token param, the same way jwt.ParseFromRequest reads it.
+func requestToken(r *http.Request) string {
+	if ah := r.Header.Get("Authorization"); len(ah) > 7 && strings.EqualFold(ah[:7], "bearer ") {
+		return ah[7:]
 	}
+	return r.FormValue("access_token")
+}
 
-	// check if the token is eligible for current scope
-	//	scope := scopeRegex.FindStringSubmatch(r.URL.Path)[1]
-	//	scopes := token.Claims["scopes"].(string)
-	//
-	//	if !contains(strings.Split(scopes, ","), scope) {
-	//		return res.Forbidden(w, res.ErrorMsg{"invalid_scope", "token is not valid for this scope"})
-	//	}
-
-	// check if the token was revoked from DB
-	t := data.Token{}
-	err = t.Get(db, int64(token.Claims["jti"].(float64)))
-	if err != nil {
+// personalTokenAuth lets the request through Auth if the personal token is
+// valid and granted the scope.
+func personalTokenAuth(w http.ResponseWriter, r *http.Request, c router.Context, db *sqlx.DB, token, scope string) error {
+	pt := data.PersonalToken{}
+	if err := pt.GetByToken(db, token); err != nil {
 		if _, ok := err.(*data.Error); ok {
 			return res.Unauthorized(w, res.ErrorMsg{"invalid_token", "token is not valid"})
 		}
 		return err
 	}
-	if t.RevokedAt != nil {
-		return res.Unauthorized(w, res.ErrorMsg{"invalid_token", "token is not valid"})
+	if err := pt.Validate(); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.Unauthorized(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
 	}
 
-	// valid token
-	// set the user id to context and pass to next handler
-	c.Meta["user_id"] = t.UserID
+	if !pt.HasScope(scope) {
+		return res.Forbidden(w, res.ErrorMsg{"insufficient_scope", "token is not valid for this scope"})
+	}
+
+	if err := pt.Touch(db, clientIP(r, c), tokenTouchInterval); err != nil {
+		return err
+	}
+
+	c.Meta["user_id"] = pt.UserID
+	c.Meta["personal_token_id"] = pt.ID
+	c.Meta["scope"] = pt.Scope
 
 	return c.Next(w, r, c)
 }
-
-//func contains(col []string, val string) bool {
-//	for _, cur := range col {
-//		if cur == val {
-//			return true
-//		}
-//	}
-//	return false
-//}
@@ -11,23 +11,25 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/ripple-cloud/cloud/data"
 	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
 	"github.com/ripple-cloud/cloud/router"
 	"github.com/ripple-cloud/cloud/testhelpers"
 )
 
-func setupServer(db *sqlx.DB, tokenSecret []byte) (*httptest.Server, error) {
+func setupServer(db *sqlx.DB, keys *keyring.Keyring) (*httptest.Server, error) {
 	r := router.New()
 
 	r.Default(
-		handlers.SetConfig(db, []byte(tokenSecret)),
-		handlers.Auth,
+		handlers.SetConfig(db, keys),
 	)
 
-	r.GET("/api/v0/*endpoint", func(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	ok := func(w http.ResponseWriter, r *http.Request, c router.Context) error {
 		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
 		w.Write([]byte(`{"status":"ok"}`))
 		return nil
-	})
+	}
+	r.GET("/api/v0/hub", handlers.Auth("hub"), ok)
+	r.GET("/api/v0/user", handlers.Auth("user"), ok)
 
 	return httptest.NewServer(r), nil
 }
@@ -38,7 +40,8 @@ func TestAuthToken(t *testing.T) {
 	defer db.Close()
 
 	// setup server
-	ts, err := setupServer(db, []byte("secret"))
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServer(db, keys)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -60,17 +63,52 @@ func TestAuthToken(t *testing.T) {
 	tok := data.Token{
 		UserID:    u.ID,
 		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(), // 30 days
+		Scope:     "hub app",
 	}
 	if err := tok.Insert(db); err != nil {
 		t.Fatal(err)
 	}
 
 	// get the encoded JSON Web Token
-	jwt, err := tok.EncodeJWT([]byte("secret"))
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create a token for the user and revoke it
+	revokedTok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(), // 30 days
+		Scope:     "hub app",
+	}
+	if err := revokedTok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := revokedTok.Revoke(db); err != nil {
+		t.Fatal(err)
+	}
+	revokedJWT, err := revokedTok.EncodeJWT(keys)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	// create a token for the user and expire it server-side
+	expiredTok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(), // 30 days
+		Scope:     "hub app",
+	}
+	if err := expiredTok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	expiredJWT, err := expiredTok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expiredTok.Shorten(db, 0); err != nil {
+		t.Fatal(err)
+	}
+
 	type testCase struct {
 		path       string
 		statusCode int
@@ -84,15 +122,17 @@ func TestAuthToken(t *testing.T) {
 		// when access token is invalid
 		{"hub?access_token=invalid", http.StatusUnauthorized, `{"error":"invalid_token","error_description":"token contains an invalid number of segments"}`},
 
-		// // when access token is not properly scoped
-		// // fixme currently valid scopes are ["user", "hub", "app"]
-		// {"admin?access_token=" + jwt, http.statusforbidden, `{"error":"invalid_scope","error_description":"token is not valid for this scope"}`},
+		// when access token is not properly scoped
+		{"user?access_token=" + jwt, http.StatusForbidden, `{"error":"insufficient_scope","error_description":"token is not valid for this scope"}`},
 
 		// when a valid token is provided
 		{"hub?access_token=" + jwt, http.StatusOK, `{"status":"ok"}`},
 
 		// when access token is revoked
-		// TODO
+		{"hub?access_token=" + revokedJWT, http.StatusUnauthorized, `{"error":"invalid_token","error_description":"token is not valid"}`},
+
+		// when access token was shortened and has expired
+		{"hub?access_token=" + expiredJWT, http.StatusUnauthorized, `{"error":"invalid_token","error_description":"token is expired"}`},
 	}
 	for _, tc := range tCases {
 		res, err := http.Get(ts.URL + path.Join("/api/v0", tc.path))
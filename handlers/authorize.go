@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+const authorizationCodeExpiry = 10 * time.Minute
+
+// a S256 code challenge is a base64url encoded SHA-256 digest
+var codeChallengeRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// authorizeRequest is a validated request of a client for the user's approval.
+type authorizeRequest struct {
+	client           *data.Client
+	redirectURI      string
+	redirectURIGiven bool // false if it defaulted to the single registered one
+	scope            string
+	state            string
+	codeChallenge    string
+}
+
+// parseAuthorizeRequest validates the authorization request params.
+// If the client or the redirect URI can't be trusted, the request is nil and
+// the error must be shown to the user. Otherwise errors are sent back to the
+// client through its redirect URI.
+func parseAuthorizeRequest(r *http.Request, db *sqlx.DB) (*authorizeRequest, error) {
+	cl := &data.Client{}
+	if err := cl.GetByClientID(db, r.FormValue("client_id")); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return nil, &data.Error{"invalid_request", "client_id is not valid"}
+		}
+		return nil, err
+	}
+
+	// the redirect URI can be omitted only if a single one is registered
+	redirectURI := r.FormValue("redirect_uri")
+	if uris := strings.Fields(cl.RedirectURIs); redirectURI == "" && len(uris) == 1 {
+		redirectURI = uris[0]
+	}
+	if !cl.HasRedirectURI(redirectURI) {
+		return nil, &data.Error{"invalid_request", "redirect_uri is not registered for the client"}
+	}
+
+	ar := &authorizeRequest{
+		client:           cl,
+		redirectURI:      redirectURI,
+		redirectURIGiven: r.FormValue("redirect_uri") != "",
+		scope:            r.FormValue("scope"),
+		state:            r.FormValue("state"),
+		codeChallenge:    r.FormValue("code_challenge"),
+	}
+
+	if r.FormValue("response_type") != "code" {
+		return ar, &data.Error{"unsupported_response_type", "supports only code response type"}
+	}
+
+	if ar.scope == "" {
+		ar.scope = cl.Scope
+	}
+	if !data.ValidScope(ar.scope) || !data.ScopeWithin(ar.scope, cl.Scope) {
+		return ar, &data.Error{"invalid_scope", "requested scope exceeds the client scope"}
+	}
+
+	if r.FormValue("code_challenge_method") != "S256" || !codeChallengeRegex.MatchString(ar.codeChallenge) {
+		return ar, &data.Error{"invalid_request", "code_challenge with S256 code_challenge_method required"}
+	}
+
+	return ar, nil
+}
+
+// respondAuthorize hands the client's redirect URI, carrying params, to the
+// user agent. The URI is returned as JSON so the dashboard can follow it.
+func respondAuthorize(w http.ResponseWriter, ar *authorizeRequest, params url.Values) error {
+	u, err := url.Parse(ar.redirectURI)
+	if err != nil {
+		return err
+	}
+	if ar.state != "" {
+		params.Set("state", ar.state)
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	payload := struct {
+		RedirectTo string `json:"redirect_to"`
+	}{
+		u.String(),
+	}
+
+	return res.OK(w, payload)
+}
+
+// respondAuthorizeError sends an error either to the user or to the client.
+func respondAuthorizeError(w http.ResponseWriter, ar *authorizeRequest, e *data.Error) error {
+	if ar == nil {
+		return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+	}
+	return respondAuthorize(w, ar, url.Values{"error": {e.Code}, "error_description": {e.Desc}})
+}
+
+// GET /oauth/authorize
+// Params: access_token, response_type, client_id, (redirect_uri), (scope), (state), code_challenge, code_challenge_method
+// Describes the request the user is asked to approve.
+func ShowAuthorize(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	ar, err := parseAuthorizeRequest(r, db)
+	if err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return respondAuthorizeError(w, ar, e)
+		}
+		return err
+	}
+
+	payload := struct {
+		ClientID    string `json:"client_id"`
+		ClientName  string `json:"client_name"`
+		RedirectURI string `json:"redirect_uri"`
+		Scope       string `json:"scope"`
+		State       string `json:"state,omitempty"`
+	}{
+		ar.client.ClientID,
+		ar.client.Name,
+		ar.redirectURI,
+		ar.scope,
+		ar.state,
+	}
+
+	return res.OK(w, payload)
+}
+
+// POST /oauth/authorize
+// Params: access_token, approve, and the params of GET /oauth/authorize
+// Issues an authorization code to the client if the user approved.
+func Authorize(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	ar, err := parseAuthorizeRequest(r, db)
+	if err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return respondAuthorizeError(w, ar, e)
+		}
+		return err
+	}
+
+	if r.FormValue("approve") != "true" {
+		return respondAuthorizeError(w, ar, &data.Error{"access_denied", "user denied the request"})
+	}
+
+	ac := data.AuthorizationCode{
+		ClientID:      ar.client.ID,
+		UserID:        c.Meta["user_id"].(int64),
+		Scope:         ar.scope,
+		CodeChallenge: ar.codeChallenge,
+		ExpiresIn:     authorizationCodeExpiry.Nanoseconds(),
+	}
+	// the code is bound only to a redirect URI the client sent
+	if ar.redirectURIGiven {
+		ac.RedirectURI = ar.redirectURI
+	}
+	code, err := ac.Generate()
+	if err != nil {
+		return err
+	}
+	if err := ac.Insert(db); err != nil {
+		return err
+	}
+
+	return respondAuthorize(w, ar, url.Values{"code": {code}})
+}
@@ -0,0 +1,244 @@
+package handlers_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func setupServerAuthorize(db *sqlx.DB, keys *keyring.Keyring) (*httptest.Server, error) {
+	r := router.New()
+
+	r.Default(
+		handlers.SetConfig(db, keys),
+	)
+
+	r.POST("/oauth/token", handlers.UserToken)
+	r.GET("/oauth/authorize", handlers.Auth("user"), handlers.ShowAuthorize)
+	r.POST("/oauth/authorize", handlers.Auth("user"), handlers.Authorize)
+	r.POST("/api/v0/clients", handlers.Auth("user"), handlers.AddClient)
+
+	return httptest.NewServer(r), nil
+}
+
+func TestAuthorizationCodeGrant(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerAuthorize(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a user
+	u := &data.User{
+		Username: "foo",
+		Email:    "foo@example.com",
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a token for the user, able to register hub clients
+	tok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "user hub",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string) (int, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	// register a partner client
+	redirectURI := "https://partner.example.com/callback"
+	status, b := do("POST", "/api/v0/clients?name=partner&scope=hub&redirect_uri="+url.QueryEscape(redirectURI)+"&access_token="+jwt)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusCreated, status, b)
+	}
+	cl := struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}{}
+	if err := json.Unmarshal(b, &cl); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	authorize := "/oauth/authorize?response_type=code&client_id=" + cl.ClientID +
+		"&state=xyz&code_challenge=" + challenge + "&code_challenge_method=S256&access_token=" + jwt
+
+	// show the consent details
+	status, b = do("GET", authorize)
+	if status != http.StatusOK {
+		t.Errorf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	expected := `{"client_id":"` + cl.ClientID + `","client_name":"partner","redirect_uri":"` + redirectURI + `","scope":"hub","state":"xyz"}`
+	if body := string(b); body != expected {
+		t.Errorf("Expected response body to be %v, Got %v", expected, body)
+	}
+
+	// approve the request
+	status, b = do("POST", authorize+"&approve=true")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	redirect := struct {
+		RedirectTo string `json:"redirect_to"`
+	}{}
+	if err := json.Unmarshal(b, &redirect); err != nil {
+		t.Fatal(err)
+	}
+	loc, err := url.Parse(redirect.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := loc.Query().Get("code")
+	if code == "" || loc.Query().Get("state") != "xyz" {
+		t.Fatalf("Unexpected redirect %s", redirect.RedirectTo)
+	}
+
+	exchange := "/oauth/token?grant_type=authorization_code&code=" + code +
+		"&redirect_uri=" + url.QueryEscape(redirectURI) +
+		"&client_id=" + cl.ClientID + "&client_secret=" + cl.ClientSecret
+
+	// exchange the code with a wrong verifier
+	status, b = do("POST", exchange+"&code_verifier=wrong-verifier")
+	if status != http.StatusBadRequest {
+		t.Errorf("Expected status code %v, Got %v: %s", http.StatusBadRequest, status, b)
+	}
+
+	// exchange the code
+	status, b = do("POST", exchange+"&code_verifier="+verifier)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	payload := struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.AccessToken == "" || payload.RefreshToken == "" || payload.Scope != "hub" {
+		t.Errorf("Unexpected token response %s", b)
+	}
+
+	type testCase struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}
+
+	// approve a request and get its code
+	getCode := func(path string) string {
+		status, b := do("POST", path+"&approve=true")
+		if status != http.StatusOK {
+			t.Fatalf("%s - Expected status code %v, Got %v: %s", path, http.StatusOK, status, b)
+		}
+		redirect := struct {
+			RedirectTo string `json:"redirect_to"`
+		}{}
+		if err := json.Unmarshal(b, &redirect); err != nil {
+			t.Fatal(err)
+		}
+		loc, err := url.Parse(redirect.RedirectTo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loc.Query().Get("code")
+	}
+	credentials := "&client_id=" + cl.ClientID + "&client_secret=" + cl.ClientSecret + "&code_verifier=" + verifier
+
+	// redirect_uri can be omitted if it wasn't given at authorization
+	code = getCode(authorize)
+	status, b = do("POST", "/oauth/token?grant_type=authorization_code&code="+code+credentials)
+	if status != http.StatusOK {
+		t.Errorf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+
+	// redirect_uri is required if it was given at authorization
+	code = getCode(authorize + "&redirect_uri=" + url.QueryEscape(redirectURI))
+	status, b = do("POST", "/oauth/token?grant_type=authorization_code&code="+code+credentials)
+	if expected := `{"error":"invalid_grant","error_description":"authorization code is not valid"}`; status != http.StatusBadRequest || string(b) != expected {
+		t.Errorf("Expected %v %s, Got %v %s", http.StatusBadRequest, expected, status, b)
+	}
+	status, b = do("POST", "/oauth/token?grant_type=authorization_code&code="+code+"&redirect_uri="+url.QueryEscape(redirectURI)+credentials)
+	if status != http.StatusOK {
+		t.Errorf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+
+	tCases := []testCase{
+		// when the code is replayed
+		{"POST", exchange + "&code_verifier=" + verifier, http.StatusBadRequest, `{"error":"invalid_grant","error_description":"authorization code is not valid"}`},
+
+		// when the client is unknown
+		{"GET", "/oauth/authorize?response_type=code&client_id=unknown&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"client_id is not valid"}`},
+
+		// when the redirect URI is not registered
+		{"GET", "/oauth/authorize?response_type=code&client_id=" + cl.ClientID + "&redirect_uri=https://evil.example.com&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"redirect_uri is not registered for the client"}`},
+
+		// when PKCE is missing, the error is sent to the client
+		{"GET", "/oauth/authorize?response_type=code&client_id=" + cl.ClientID + "&access_token=" + jwt, http.StatusOK, `{"redirect_to":"https://partner.example.com/callback?error=invalid_request\u0026error_description=code_challenge+with+S256+code_challenge_method+required"}`},
+
+		// when the user denies the request
+		{"POST", authorize + "&approve=false", http.StatusOK, `{"redirect_to":"https://partner.example.com/callback?error=access_denied\u0026error_description=user+denied+the+request\u0026state=xyz"}`},
+
+		// when registering a client with a relative redirect URI
+		{"POST", "/api/v0/clients?name=partner&redirect_uri=/callback&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_redirect_uri","error_description":"redirect_uri must be an absolute URI without a fragment"}`},
+	}
+	for _, tc := range tCases {
+		status, b := do(tc.method, tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v", tc.path, tc.statusCode, status)
+		}
+		if body := string(b); body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+// POST /api/v0/clients
+// Params: access_token, name, (scope), (redirect_uri)
+// scope can't exceed the scope of the token making the request, which is the default.
+// redirect_uri can be given multiple times. The client secret is only ever shown in this response.
+func AddClient(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	name := r.FormValue("name")
+	if name == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "name required"})
+	}
+
+	scope := r.FormValue("scope")
+	if scope == "" {
+		scope = tokenScope(c)
+	}
+	if !data.ValidScope(scope) {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_scope", "requested scope is not valid"})
+	}
+	if !data.ScopeWithin(scope, tokenScope(c)) {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_scope", "requested scope exceeds the token scope"})
+	}
+
+	// redirect URIs must be absolute and are matched exactly
+	uris := r.Form["redirect_uri"]
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Fragment != "" || strings.ContainsAny(uri, " \t\n") {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_redirect_uri", "redirect_uri must be an absolute URI without a fragment"})
+		}
+	}
+
+	cl := &data.Client{
+		Name:         name,
+		Scope:        scope,
+		UserID:       c.Meta["user_id"].(int64),
+		RedirectURIs: strings.Join(uris, " "),
+	}
+	secret, err := cl.GenerateCredentials()
+	if err != nil {
+		return err
+	}
+	if err := cl.Insert(db); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	payload := struct {
+		*data.Client
+		ClientSecret string `json:"client_secret"`
+	}{
+		cl,
+		secret,
+	}
+
+	return res.Created(w, payload)
+}
+
+// GET /api/v0/clients
+// Params: access_token
+func ShowClients(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	cls := data.Clients{}
+	if err := cls.SelectByUserID(db, c.Meta["user_id"].(int64)); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	payload := struct {
+		Clients data.Clients `json:"clients"`
+	}{
+		cls,
+	}
+
+	return res.OK(w, payload)
+}
+
+// POST /api/v0/clients/:client_id/secret
+// Params: access_token
+// Issues a new client secret and revokes the tokens issued with the old one.
+func RotateClientSecret(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	cl := &data.Client{}
+	if err := cl.GetByClientID(db, c.Params.ByName("client_id")); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	// don't reveal clients owned by other users
+	if cl.UserID != c.Meta["user_id"].(int64) {
+		return res.NotFound(w, res.ErrorMsg{"record_not_found", "client not found"})
+	}
+
+	secret, err := cl.RotateSecret(db)
+	if err != nil {
+		return err
+	}
+
+	payload := struct {
+		*data.Client
+		ClientSecret string `json:"client_secret"`
+	}{
+		cl,
+		secret,
+	}
+
+	return res.OK(w, payload)
+}
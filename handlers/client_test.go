@@ -0,0 +1,169 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func setupServerClient(db *sqlx.DB, keys *keyring.Keyring) (*httptest.Server, error) {
+	r := router.New()
+
+	r.Default(
+		handlers.SetConfig(db, keys),
+	)
+
+	r.POST("/oauth/token", handlers.UserToken)
+	r.POST("/api/v0/clients", handlers.Auth("user"), handlers.AddClient)
+	r.GET("/api/v0/clients", handlers.Auth("user"), handlers.ShowClients)
+	r.POST("/api/v0/clients/:client_id/secret", handlers.Auth("user"), handlers.RotateClientSecret)
+
+	return httptest.NewServer(r), nil
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerClient(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a user
+	u := &data.User{
+		Username: "foo",
+		Email:    "foo@example.com",
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a token for the user
+	tok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(), // 30 days
+		Scope:     "user hub",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// get the encoded JSON Web Token
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(path string) (int, []byte) {
+		res, err := http.Post(ts.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	type clientPayload struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		Scope        string `json:"scope"`
+	}
+
+	// register a client limited to the hub scope
+	status, b := post("/api/v0/clients?name=ci&scope=hub&access_token=" + jwt)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusCreated, status, b)
+	}
+	cl := clientPayload{}
+	if err := json.Unmarshal(b, &cl); err != nil {
+		t.Fatal(err)
+	}
+	if cl.ClientID == "" || cl.ClientSecret == "" || cl.Scope != "hub" {
+		t.Fatalf("Unexpected client registration response %s", b)
+	}
+
+	// get a token with the client credentials
+	creds := "client_id=" + cl.ClientID + "&client_secret=" + cl.ClientSecret
+	status, b = post("/oauth/token?grant_type=client_credentials&" + creds)
+	if status != http.StatusOK {
+		t.Errorf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	payload := struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.AccessToken == "" || payload.RefreshToken != "" || payload.Scope != "hub" {
+		t.Errorf("Unexpected token response %s", b)
+	}
+
+	// rotate the client secret
+	status, b = post("/api/v0/clients/" + cl.ClientID + "/secret?access_token=" + jwt)
+	if status != http.StatusOK {
+		t.Errorf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	rotated := clientPayload{}
+	if err := json.Unmarshal(b, &rotated); err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when client credentials are missing
+		{"/oauth/token?grant_type=client_credentials", http.StatusUnauthorized, `{"error":"invalid_client","error_description":"client authentication failed"}`},
+
+		// when the rotated secret is used
+		{"/oauth/token?grant_type=client_credentials&" + creds, http.StatusUnauthorized, `{"error":"invalid_client","error_description":"client authentication failed"}`},
+
+		// when requested scope exceeds the client scope
+		{"/oauth/token?grant_type=client_credentials&scope=user&client_id=" + cl.ClientID + "&client_secret=" + rotated.ClientSecret, http.StatusBadRequest, `{"error":"invalid_scope","error_description":"requested scope exceeds the client scope"}`},
+
+		// when registering a client with a scope the token lacks
+		{"/api/v0/clients?name=ci&scope=app&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_scope","error_description":"requested scope exceeds the token scope"}`},
+
+		// when registering a client without a name
+		{"/api/v0/clients?access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"name required"}`},
+
+		// when rotating the secret of an unknown client
+		{"/api/v0/clients/unknown/secret?access_token=" + jwt, http.StatusNotFound, `{"error":"record_not_found","error_description":"client not found"}`},
+	}
+	for _, tc := range tCases {
+		status, b := post(tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v", tc.path, tc.statusCode, status)
+		}
+		if body := string(b); body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+}
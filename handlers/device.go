@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+// Hubs have deviceCodeExpiry to get paired, polling the token endpoint at
+// most every devicePollInterval seconds.
+const (
+	deviceCodeExpiry   = 10 * time.Minute
+	devicePollInterval = 5
+)
+
+// POST /oauth/device_authorization
+// Starts pairing a headless hub (RFC 8628). The hub shows the user code and
+// verification URI to its owner, and polls /oauth/token with the device code.
+func DeviceAuthorization(verificationURI string) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request, c router.Context) error {
+		db, ok := c.Meta["db"].(*sqlx.DB)
+		if !ok {
+			return errors.New("db not set in context")
+		}
+
+		dc := data.DeviceCode{
+			ExpiresIn:    deviceCodeExpiry.Nanoseconds(),
+			PollInterval: devicePollInterval,
+		}
+		code, err := dc.Generate()
+		if err != nil {
+			return err
+		}
+		if err := dc.Insert(db); err != nil {
+			return err
+		}
+
+		payload := struct {
+			DeviceCode      string `json:"device_code"`
+			UserCode        string `json:"user_code"`
+			VerificationURI string `json:"verification_uri"`
+			ExpiresIn       int64  `json:"expires_in"`
+			Interval        int64  `json:"interval"`
+		}{
+			code,
+			dc.FormattedUserCode(),
+			verificationURI,
+			int64(deviceCodeExpiry / time.Second),
+			dc.PollInterval,
+		}
+
+		return res.OK(w, payload)
+	}
+}
+
+// POST /api/v0/device
+// Params: access_token, user_code, slug, approve
+// Registers the hub showing the user code, unless approve is not "true".
+func ApproveDevice(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+	userID := c.Meta["user_id"].(int64)
+
+	userCode := r.FormValue("user_code")
+	if userCode == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "user_code required"})
+	}
+
+	dc := data.DeviceCode{}
+	if err := dc.GetByUserCode(db, userCode); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_request", "user_code is not valid"})
+		}
+		return err
+	}
+	if dc.Expired() {
+		return res.BadRequest(w, res.ErrorMsg{"expired_token", "user_code is expired"})
+	}
+
+	if r.FormValue("approve") != "true" {
+		if err := dc.Deny(db, userID); err != nil {
+			if e, ok := err.(*data.Error); ok {
+				return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+			}
+			return err
+		}
+		return res.OK(w, struct{}{})
+	}
+
+	slug := r.FormValue("slug")
+	if slug == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "slug required"})
+	}
+
+	// the hub gets its credential when it next polls
+	h := data.Hub{
+		Slug:   slug,
+		UserID: userID,
+	}
+	if err := dc.Approve(db, &h); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	return res.Created(w, h)
+}
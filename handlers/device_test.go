@@ -0,0 +1,192 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func setupServerDevice(db *sqlx.DB, keys *keyring.Keyring) (*httptest.Server, error) {
+	r := router.New()
+
+	r.Default(
+		handlers.SetConfig(db, keys),
+	)
+
+	r.POST("/oauth/token", handlers.UserToken)
+	r.POST("/oauth/device_authorization", handlers.DeviceAuthorization("https://ripple.io/device"))
+	r.POST("/api/v0/device", handlers.Auth("hub"), handlers.ApproveDevice)
+	r.GET("/api/v0/hub/me", handlers.Auth(data.DeviceScope), handlers.ShowHubSelf)
+
+	return httptest.NewServer(r), nil
+}
+
+func TestDeviceCodeGrant(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerDevice(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a user
+	u := &data.User{
+		Username: "foo",
+		Email:    "foo@example.com",
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a token for the user
+	tok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "hub",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(path string) (int, []byte) {
+		res, err := http.Post(ts.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	// the hub starts pairing
+	status, b := post("/oauth/device_authorization")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	auth := struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURI string `json:"verification_uri"`
+		ExpiresIn       int64  `json:"expires_in"`
+		Interval        int64  `json:"interval"`
+	}{}
+	if err := json.Unmarshal(b, &auth); err != nil {
+		t.Fatal(err)
+	}
+	if auth.DeviceCode == "" || len(auth.UserCode) != 9 || auth.VerificationURI != "https://ripple.io/device" || auth.ExpiresIn != 600 || auth.Interval != 5 {
+		t.Fatalf("Unexpected device authorization response %s", b)
+	}
+
+	poll := "/oauth/token?grant_type=urn:ietf:params:oauth:grant-type:device_code&device_code=" + auth.DeviceCode
+	resetPoll := func() {
+		if _, err := db.Exec("UPDATE device_codes SET polled_at = NULL;"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type testCase struct {
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when the owner has not approved yet
+		{poll, http.StatusBadRequest, `{"error":"authorization_pending","error_description":"pairing is not approved yet"}`},
+
+		// when the hub polls too often
+		{poll, http.StatusBadRequest, `{"error":"slow_down","error_description":"polling too frequently"}`},
+
+		// when the device code is unknown
+		{"/oauth/token?grant_type=urn:ietf:params:oauth:grant-type:device_code&device_code=unknown", http.StatusBadRequest, `{"error":"invalid_grant","error_description":"device code is not valid"}`},
+
+		// when the user code is unknown
+		{"/api/v0/device?user_code=BCDF-GHJK&slug=abcd&approve=true&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"user_code is not valid"}`},
+
+		// when the slug is missing
+		{"/api/v0/device?user_code=" + auth.UserCode + "&approve=true&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"slug required"}`},
+	}
+	for _, tc := range tCases {
+		status, b := post(tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v", tc.path, tc.statusCode, status)
+		}
+		if body := string(b); body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+
+	// the owner approves the user code shown by the hub
+	status, b = post("/api/v0/device?user_code=" + auth.UserCode + "&slug=abcd&approve=true&access_token=" + jwt)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusCreated, status, b)
+	}
+	hub := data.Hub{}
+	if err := json.Unmarshal(b, &hub); err != nil {
+		t.Fatal(err)
+	}
+	if hub.Slug != "abcd" || hub.UserID != u.ID {
+		t.Errorf("Unexpected hub %s", b)
+	}
+
+	// the hub receives its credential
+	resetPoll()
+	status, b = post(poll)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	payload := struct {
+		AccessToken string `json:"access_token"`
+		Scope       string `json:"scope"`
+		HubID       int64  `json:"hub_id"`
+		HubSecret   string `json:"hub_secret"`
+	}{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.AccessToken == "" || payload.Scope != data.DeviceScope || payload.HubID != hub.ID || payload.HubSecret == "" {
+		t.Errorf("Unexpected token response %s", b)
+	}
+
+	// the hub token acts as the hub
+	res, err := http.Get(ts.URL + "/api/v0/hub/me?access_token=" + payload.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %v, Got %v", http.StatusOK, res.StatusCode)
+	}
+
+	// the device code is exchanged only once
+	resetPoll()
+	status, b = post(poll)
+	if body := string(b); status != http.StatusBadRequest || body != `{"error":"invalid_grant","error_description":"device code is not valid"}` {
+		t.Errorf("Expected a reused device code to be rejected, Got %v: %s", status, b)
+	}
+}
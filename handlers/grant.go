@@ -0,0 +1,507 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/keyring"
+)
+
+// Access tokens are short-lived; clients keep a session alive by exchanging
+// the refresh token issued alongside.
+const (
+	accessTokenExpiry  = time.Hour
+	refreshTokenExpiry = 30 * 24 * time.Hour
+)
+
+// grant_type=password
+// Params: login, password, (otp), (scope)
+func passwordGrant(w http.ResponseWriter, r *http.Request, db *sqlx.DB, keys *keyring.Keyring, ip string) error {
+	login := r.FormValue("login")
+	if login == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "login required"})
+	}
+
+	password := r.FormValue("password")
+	if password == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "password required"})
+	}
+
+	scope := r.FormValue("scope")
+	if scope == "" {
+		scope = data.DefaultScope
+	}
+	if !data.ValidScope(scope) {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_scope", "requested scope is not valid"})
+	}
+
+	throttle := throttleKeys(login, ip)
+	if locked, err := respondThrottled(w, db, throttle); locked || err != nil {
+		return err
+	}
+
+	u := data.User{}
+	if err := u.GetByLogin(db, login); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			if err := failLogin(db, throttle); err != nil {
+				return err
+			}
+			return res.BadRequest(w, res.ErrorMsg{"invalid_grant", e.Desc})
+		}
+		return err
+	}
+
+	if !u.VerifyPassword(password) {
+		if err := failLogin(db, throttle); err != nil {
+			return err
+		}
+		return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "failed to authenticate user"})
+	}
+
+	// keep up with the configured bcrypt cost
+	if err := u.RehashPassword(db, password); err != nil {
+		return err
+	}
+
+	if !u.EmailVerified() {
+		return res.BadRequest(w, res.ErrorMsg{"email_not_verified", "email address is not verified"})
+	}
+
+	// enrolled users also need a code from their authenticator app
+	if u.OTPEnabled() {
+		otp := r.FormValue("otp")
+		if otp == "" {
+			return res.BadRequest(w, res.ErrorMsg{"mfa_required", "otp required"})
+		}
+		if ok, err := verifyOTP(db, &u, otp); !ok {
+			if err != nil {
+				return err
+			}
+			if err := failLogin(db, throttle); err != nil {
+				return err
+			}
+			return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "otp is not valid"})
+		}
+	}
+
+	if err := data.ResetLoginThrottle(db, data.ThrottleLogin, throttle[data.ThrottleLogin]); err != nil {
+		return err
+	}
+
+	return issueToken(w, db, keys, grant{userID: u.ID, scope: scope, refresh: true})
+}
+
+// grant_type=refresh_token
+// Params: refresh_token, (scope)
+func refreshTokenGrant(w http.ResponseWriter, r *http.Request, db *sqlx.DB, keys *keyring.Keyring) error {
+	token := r.FormValue("refresh_token")
+	if token == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "refresh_token required"})
+	}
+
+	rt := data.RefreshToken{}
+	if err := rt.GetByToken(db, token); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "refresh token is not valid"})
+		}
+		return err
+	}
+
+	if rt.RevokedAt != nil || rt.Expired() {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "refresh token is not valid"})
+	}
+
+	// refresh tokens issued to a client can only be used by that client
+	if rt.ClientID != nil {
+		cl, err := authenticateClient(r, db)
+		if err != nil {
+			if e, ok := err.(*data.Error); ok {
+				return clientError(w, e)
+			}
+			return err
+		}
+		if cl.ID != *rt.ClientID {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "refresh token is not valid"})
+		}
+	}
+
+	scope := r.FormValue("scope")
+	if scope == "" {
+		scope = rt.Scope
+	}
+	if !data.ValidScope(scope) || !data.ScopeWithin(scope, rt.Scope) {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_scope", "requested scope exceeds the granted scope"})
+	}
+
+	// A refresh token is only ever exchanged once. Seeing it again means it
+	// leaked, so we can't tell the client from the attacker; revoke every
+	// token descended from the same grant.
+	if err := rt.Use(db); err != nil {
+		if e, ok := err.(*data.Error); ok && e.Code == "token_reused" {
+			if err := rt.RevokeFamily(db); err != nil {
+				return err
+			}
+			return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "refresh token is not valid"})
+		}
+		return err
+	}
+
+	return issueToken(w, db, keys, grant{
+		userID:   rt.UserID,
+		clientID: rt.ClientID,
+		scope:    scope,
+		refresh:  true,
+		familyID: rt.FamilyID,
+	})
+}
+
+// grant_type=authorization_code
+// Params: code, (redirect_uri), code_verifier, client_id, client_secret (or HTTP Basic auth)
+func authorizationCodeGrant(w http.ResponseWriter, r *http.Request, db *sqlx.DB, keys *keyring.Keyring) error {
+	cl, err := authenticateClient(r, db)
+	if err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return clientError(w, e)
+		}
+		return err
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "code required"})
+	}
+	verifier := r.FormValue("code_verifier")
+	if verifier == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "code_verifier required"})
+	}
+
+	ac := data.AuthorizationCode{}
+	if err := ac.GetByCode(db, code); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "authorization code is not valid"})
+		}
+		return err
+	}
+
+	// redirect_uri must match only if it was given at authorization; otherwise
+	// it may be omitted (RFC 6749 4.1.3)
+	redirectURI := r.FormValue("redirect_uri")
+	if ac.RedirectURI == "" && cl.HasRedirectURI(redirectURI) {
+		redirectURI = ""
+	}
+	if ac.ClientID != cl.ID || ac.RedirectURI != redirectURI || ac.Expired() {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "authorization code is not valid"})
+	}
+	if !ac.VerifyChallenge(verifier) {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "code_verifier does not match the code challenge"})
+	}
+
+	// A code is exchanged only once; a replayed code may have been
+	// intercepted, so revoke whatever was issued for it (RFC 6749 4.1.2).
+	if err := ac.Use(db); err != nil {
+		if e, ok := err.(*data.Error); ok && e.Code == "token_reused" {
+			if err := ac.RevokeTokens(db); err != nil {
+				return err
+			}
+			return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "authorization code is not valid"})
+		}
+		return err
+	}
+
+	t, refreshToken, err := createToken(db, grant{
+		userID:   ac.UserID,
+		clientID: &cl.ID,
+		scope:    ac.Scope,
+		refresh:  true,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ac.SetToken(db, t.ID); err != nil {
+		return err
+	}
+
+	return respondToken(w, keys, t, refreshToken)
+}
+
+// grant_type=client_credentials
+// Params: client_id, client_secret (or HTTP Basic auth), (scope)
+// No refresh token is issued; clients can authenticate again instead.
+func clientCredentialsGrant(w http.ResponseWriter, r *http.Request, db *sqlx.DB, keys *keyring.Keyring) error {
+	cl, err := authenticateClient(r, db)
+	if err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return clientError(w, e)
+		}
+		return err
+	}
+
+	scope := r.FormValue("scope")
+	if scope == "" {
+		scope = cl.Scope
+	}
+	if !data.ValidScope(scope) || !data.ScopeWithin(scope, cl.Scope) {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_scope", "requested scope exceeds the client scope"})
+	}
+
+	if ok, err := ownerActive(db, cl.UserID); !ok {
+		if err != nil {
+			return err
+		}
+		return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "account is scheduled for deletion"})
+	}
+
+	return issueToken(w, db, keys, grant{userID: cl.UserID, clientID: &cl.ID, scope: scope})
+}
+
+// grant_type=hub_credentials
+// Params: hub_id, hub_secret (or HTTP Basic auth)
+// Hubs get a device scoped token acting as themselves, not as their owner.
+func hubCredentialsGrant(w http.ResponseWriter, r *http.Request, db *sqlx.DB, keys *keyring.Keyring) error {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.FormValue("hub_id"), r.FormValue("hub_secret")
+	}
+
+	hubID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return clientError(w, &data.Error{"invalid_client", "hub authentication failed"})
+	}
+
+	h := data.Hub{}
+	if err := h.GetByID(db, hubID); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return clientError(w, &data.Error{"invalid_client", "hub authentication failed"})
+		}
+		return err
+	}
+	if !h.VerifySecret(secret) {
+		return clientError(w, &data.Error{"invalid_client", "hub authentication failed"})
+	}
+
+	if ok, err := ownerActive(db, h.UserID); !ok {
+		if err != nil {
+			return err
+		}
+		return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "account is scheduled for deletion"})
+	}
+
+	return issueToken(w, db, keys, grant{userID: h.UserID, hubID: &h.ID, scope: data.DeviceScope})
+}
+
+// grant_type=urn:ietf:params:oauth:grant-type:device_code
+// Params: device_code
+// Polled by a hub being paired. Once its owner approved, the hub receives its
+// credential along with a device scoped token.
+func deviceCodeGrant(w http.ResponseWriter, r *http.Request, db *sqlx.DB, keys *keyring.Keyring) error {
+	code := r.FormValue("device_code")
+	if code == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "device_code required"})
+	}
+
+	dc := data.DeviceCode{}
+	if err := dc.GetByDeviceCode(db, code); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "device code is not valid"})
+		}
+		return err
+	}
+
+	if dc.Expired() {
+		return res.BadRequest(w, res.ErrorMsg{"expired_token", "device code is expired"})
+	}
+	if err := dc.Poll(db); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+	if dc.DeniedAt != nil {
+		return res.BadRequest(w, res.ErrorMsg{"access_denied", "pairing was denied"})
+	}
+	if dc.HubID == nil {
+		return res.BadRequest(w, res.ErrorMsg{"authorization_pending", "pairing is not approved yet"})
+	}
+
+	if err := dc.Use(db); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "device code is not valid"})
+		}
+		return err
+	}
+
+	h := data.Hub{ID: *dc.HubID}
+	secret, err := h.RotateSecret(db)
+	if err != nil {
+		return err
+	}
+
+	t, _, err := createToken(db, grant{userID: h.UserID, hubID: &h.ID, scope: data.DeviceScope})
+	if err != nil {
+		return err
+	}
+	tr, err := newTokenResponse(keys, t, "")
+	if err != nil {
+		return err
+	}
+
+	payload := struct {
+		*tokenResponse
+		HubID     int64  `json:"hub_id"`
+		HubSecret string `json:"hub_secret"`
+	}{
+		tr,
+		h.ID,
+		secret,
+	}
+
+	return res.OK(w, payload)
+}
+
+// ownerActive reports whether the user a client or hub acts for is not
+// scheduled for deletion. Only the user can get tokens during the grace period.
+func ownerActive(db *sqlx.DB, userID int64) (bool, error) {
+	u := data.User{}
+	if err := u.Get(db, userID); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return !u.DeletionScheduled(), nil
+}
+
+// authenticateClient checks the credentials of a registered client sent with
+// HTTP Basic auth or the client_id and client_secret params.
+// Returns a *data.Error if the client can't be authenticated.
+func authenticateClient(r *http.Request, db *sqlx.DB) (*data.Client, error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	if id == "" {
+		return nil, &data.Error{"invalid_client", "client authentication failed"}
+	}
+
+	cl := &data.Client{}
+	if err := cl.GetByClientID(db, id); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return nil, &data.Error{"invalid_client", "client authentication failed"}
+		}
+		return nil, err
+	}
+	if !cl.VerifySecret(secret) {
+		return nil, &data.Error{"invalid_client", "client authentication failed"}
+	}
+
+	return cl, nil
+}
+
+// clientError responds to a failed client authentication.
+func clientError(w http.ResponseWriter, e *data.Error) error {
+	w.Header().Set("WWW-Authenticate", `Basic realm="ripple"`)
+	return res.Unauthorized(w, res.ErrorMsg{e.Code, e.Desc})
+}
+
+// grant describes the access token to issue once a grant succeeded.
+type grant struct {
+	userID   int64
+	clientID *int64 // set if the token is issued to a registered client
+	hubID    *int64 // set if the token is issued to a hub
+	scope    string
+
+	// refresh issues a refresh token alongside the access token, starting a
+	// new family unless familyID is set.
+	refresh  bool
+	familyID int64
+}
+
+// issueToken creates an access token (and a refresh token if requested) and
+// responds with the oAuth2 access token payload.
+func issueToken(w http.ResponseWriter, db *sqlx.DB, keys *keyring.Keyring, g grant) error {
+	t, refreshToken, err := createToken(db, g)
+	if err != nil {
+		return err
+	}
+	return respondToken(w, keys, t, refreshToken)
+}
+
+// createToken persists the access token of a grant, and its refresh token if
+// requested. Returns the token along with the plain refresh token.
+func createToken(db *sqlx.DB, g grant) (*data.Token, string, error) {
+	t := &data.Token{
+		UserID:    g.userID,
+		ExpiresIn: accessTokenExpiry.Nanoseconds(),
+		Scope:     g.scope,
+		ClientID:  g.clientID,
+		HubID:     g.hubID,
+	}
+	if err := t.Insert(db); err != nil {
+		return nil, "", err
+	}
+
+	if !g.refresh {
+		return t, "", nil
+	}
+
+	if g.familyID == 0 {
+		g.familyID = t.ID
+	}
+	rt := data.RefreshToken{
+		TokenID:   t.ID,
+		UserID:    g.userID,
+		FamilyID:  g.familyID,
+		Scope:     g.scope,
+		ExpiresIn: refreshTokenExpiry.Nanoseconds(),
+		ClientID:  g.clientID,
+	}
+	refreshToken, err := rt.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := rt.Insert(db); err != nil {
+		return nil, "", err
+	}
+
+	return t, refreshToken, nil
+}
+
+// tokenResponse is the oAuth2 access token payload.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+func newTokenResponse(keys *keyring.Keyring, t *data.Token, refreshToken string) (*tokenResponse, error) {
+	// get the encoded JSON Web token
+	jwt, err := t.EncodeJWT(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		jwt,
+		"bearer",
+		t.ExpiresInSeconds(),
+		refreshToken,
+		t.Scope,
+	}, nil
+}
+
+// respondToken responds with the oAuth2 access token payload.
+func respondToken(w http.ResponseWriter, keys *keyring.Keyring, t *data.Token, refreshToken string) error {
+	payload, err := newTokenResponse(keys, t, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	return res.OK(w, payload)
+}
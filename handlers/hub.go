@@ -1,7 +1,12 @@
 package handlers
 
 import (
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 
@@ -10,8 +15,19 @@ import (
 	"github.com/ripple-cloud/cloud/router"
 )
 
+// hubPayload is a hub along with its status (see data.Hub.Status).
+type hubPayload struct {
+	*data.Hub
+	Status string `json:"status"`
+}
+
+func newHubPayload(h *data.Hub) hubPayload {
+	return hubPayload{h, h.Status()}
+}
+
 // POST /api/v0/hub
 // Params: access_token, slug, (scope?)
+// The hub secret is only ever shown in this response.
 func AddHub(w http.ResponseWriter, r *http.Request, c router.Context) error {
 	db, _ := c.Meta["db"].(*sqlx.DB)
 
@@ -25,6 +41,10 @@ func AddHub(w http.ResponseWriter, r *http.Request, c router.Context) error {
 		Slug:   slug,
 		UserID: c.Meta["user_id"].(int64),
 	}
+	secret, err := h.GenerateSecret()
+	if err != nil {
+		return err
+	}
 	if err := h.Insert(db); err != nil {
 		if e, ok := err.(*data.Error); ok {
 			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
@@ -32,33 +52,273 @@ func AddHub(w http.ResponseWriter, r *http.Request, c router.Context) error {
 		return err
 	}
 
-	return res.OK(w, h)
+	// the hub exchanges its credential for tokens (grant_type=hub_credentials)
+	payload := struct {
+		hubPayload
+		HubSecret string `json:"hub_secret"`
+	}{
+		newHubPayload(&h),
+		secret,
+	}
+
+	return res.OK(w, payload)
 }
 
-// GET /api/v0/hub
+// GET /api/v0/hub/me
 // Params: access_token
-func ShowHub(w http.ResponseWriter, r *http.Request, c router.Context) error {
+// Requires a token issued to the hub itself.
+func ShowHubSelf(w http.ResponseWriter, r *http.Request, c router.Context) error {
 	db, _ := c.Meta["db"].(*sqlx.DB)
 
-	// Since all is well, get hub(s) from database
-	var h data.Hubs
-	if err := h.SelectByUserId(db, c.Meta["user_id"].(int64)); err != nil {
+	h := data.Hub{}
+	if err := h.GetByID(db, c.Meta["hub_id"].(int64)); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	return res.OK(w, newHubPayload(&h))
+}
+
+// POST /api/v0/hub/me/heartbeat
+// Params: access_token, (uptime), (version), (load)
+// Requires a token issued to the hub itself. Hubs send heartbeats more often
+// than data.HubPresence.StaleAfter to stay online.
+func HubHeartbeat(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	hb := data.Heartbeat{IP: clientIP(r, c)}
+	if v := r.FormValue("uptime"); v != "" {
+		uptime, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || uptime < 0 {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_request", "uptime must be a number of seconds"})
+		}
+		hb.Uptime = &uptime
+	}
+	if v := r.FormValue("version"); v != "" {
+		if len(v) > 64 {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_request", "version must be at most 64 characters"})
+		}
+		hb.Version = &v
+	}
+	if v := r.FormValue("load"); v != "" {
+		load, err := strconv.ParseFloat(v, 64)
+		if err != nil || load < 0 || math.IsInf(load, 0) || math.IsNaN(load) {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_request", "load must be a positive number"})
+		}
+		hb.Load = &load
+	}
+
+	h := data.Hub{ID: c.Meta["hub_id"].(int64)}
+	if err := h.Heartbeat(db, hb); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	return res.OK(w, newHubPayload(&h))
+}
+
+// GET /api/v0/hubs/:slug/presence
+// Params: access_token
+// Lists the last 50 times the hub went online or offline, newest first.
+func ShowHubPresence(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	h, err := ownHub(w, r, c)
+	if h == nil {
+		return err
+	}
+
+	events, err := h.PresenceEvents(db, 50)
+	if err != nil {
+		return err
+	}
+
+	return res.OK(w, events)
+}
+
+// Hubs are listed 20 at a time by default, and at most 100.
+const (
+	defaultHubsLimit = 20
+	maxHubsLimit     = 100
+)
+
+// GET /api/v0/hubs
+// Params: access_token, (q), (sort), (limit), (cursor), (os), (arch),
+// (hub_version_below), (hub_version_at_least), (app)
+// Lists the hubs of the current user. q filters hubs whose slug contains it;
+// the other filters apply to the latest inventory the hubs reported.
+// If there are more hubs, next_cursor is set; pass it as cursor, with the
+// same q and sort, to get the next page.
+func ShowHubs(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	q := data.HubQuery{
+		UserID: c.Meta["user_id"].(int64),
+		Search: r.FormValue("q"),
+		Sort:   r.FormValue("sort"),
+		Limit:  defaultHubsLimit,
+
+		OS:                r.FormValue("os"),
+		Arch:              r.FormValue("arch"),
+		HubVersionBelow:   r.FormValue("hub_version_below"),
+		HubVersionAtLeast: r.FormValue("hub_version_at_least"),
+		App:               r.FormValue("app"),
+	}
+	if v := r.FormValue("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxHubsLimit {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_request", "limit must be a number from 1 to " + strconv.Itoa(maxHubsLimit)})
+		}
+		q.Limit = limit
+	}
+	if v := r.FormValue("cursor"); v != "" {
+		cursor, err := data.DecodeHubCursor(v)
+		if err != nil {
+			if e, ok := err.(*data.Error); ok {
+				return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+			}
+			return err
+		}
+		q.After = cursor
+	}
+
+	// select one more hub to know if there is a next page
+	q.Limit++
+	hubs := data.Hubs{}
+	if err := hubs.Select(db, q); err != nil {
 		if e, ok := err.(*data.Error); ok {
 			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
 		}
 		return err
 	}
 
-	// prepare oAuth2 access token payload
+	var next *string
+	if len(hubs) == q.Limit {
+		hubs = hubs[:len(hubs)-1]
+		sort := q.Sort
+		if sort == "" {
+			sort = data.HubSorts[0]
+		}
+		cursor := data.NewHubCursor(&hubs[len(hubs)-1], sort).Encode()
+		next = &cursor
+	}
+
 	payload := struct {
-		Hubs []string `json:"hub"`
+		Hubs       []hubPayload `json:"hubs"`
+		NextCursor *string      `json:"next_cursor"`
 	}{
-		h,
+		[]hubPayload{},
+		next,
+	}
+	for i := range hubs {
+		payload.Hubs = append(payload.Hubs, newHubPayload(&hubs[i]))
 	}
 
 	return res.OK(w, payload)
 }
 
+// GET /api/v0/hubs/:slug
+// Params: access_token
+// Hubs of other users are reported as not found.
+func ShowHub(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	h, err := ownHub(w, r, c)
+	if h == nil {
+		return err
+	}
+
+	return res.OK(w, newHubPayload(h))
+}
+
+// PATCH /api/v0/hubs/:slug
+// Params: access_token, (slug), (name), (description)
+// Only the params sent are changed. After a rename the old slug keeps
+// redirecting to the hub, and can't be taken by other users, for hold.
+func UpdateHub(hold time.Duration) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request, c router.Context) error {
+		db, _ := c.Meta["db"].(*sqlx.DB)
+
+		h, err := ownHub(w, r, c)
+		if h == nil {
+			return err
+		}
+
+		if slug, ok := formValue(r, "slug"); ok {
+			if slug == "" {
+				return res.BadRequest(w, res.ErrorMsg{"invalid_request", "slug must not be empty"})
+			}
+			h.Slug = slug
+		}
+		if name, ok := formValue(r, "name"); ok {
+			if len(name) > 255 {
+				return res.BadRequest(w, res.ErrorMsg{"invalid_request", "name must be at most 255 characters"})
+			}
+			h.Name = name
+		}
+		if description, ok := formValue(r, "description"); ok {
+			h.Description = description
+		}
+
+		if err := h.Update(db, hold); err != nil {
+			if e, ok := err.(*data.Error); ok {
+				return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+			}
+			return err
+		}
+
+		return res.OK(w, newHubPayload(h))
+	}
+}
+
+// ownHub gets the current user's hub with the slug in the path. If the hub
+// was renamed from that slug, it responds with a redirect to the new one.
+// Returns nil if it responded.
+func ownHub(w http.ResponseWriter, r *http.Request, c router.Context) (*data.Hub, error) {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+	userID := c.Meta["user_id"].(int64)
+	slug := c.Params.ByName("slug")
+
+	h := &data.Hub{}
+	err := h.Get(db, slug)
+	if err == nil && h.UserID == userID {
+		return h, nil
+	}
+	if _, ok := err.(*data.Error); err != nil && !ok {
+		return nil, err
+	}
+
+	// hubs of other users are reported as not found
+	if err := h.GetByOldSlug(db, slug); err != nil || h.UserID != userID {
+		if _, ok := err.(*data.Error); ok || err == nil {
+			return nil, res.NotFound(w, res.ErrorMsg{"record_not_found", "hub not found"})
+		}
+		return nil, err
+	}
+
+	// point at the same path with the new slug
+	// 308 so that clients following it keep the method
+	segments := strings.Split(r.URL.Path, "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i] == slug {
+			segments[i] = url.PathEscape(h.Slug)
+			break
+		}
+	}
+	w.Header().Set("Location", strings.Join(segments, "/"))
+	return nil, res.Respond(w, http.StatusPermanentRedirect, res.ErrorMsg{"hub_moved", "hub was renamed to " + h.Slug})
+}
+
+// formValue returns the param and whether it was sent at all, so that a
+// param can be set to an empty value.
+func formValue(r *http.Request, key string) (string, bool) {
+	v := r.FormValue(key)
+	_, ok := r.Form[key]
+	return v, ok
+}
+
 // DELETE /api/v0/hub
 // Params: access_token, slug
 func DeleteHub(w http.ResponseWriter, r *http.Request, c router.Context) error {
@@ -69,18 +329,15 @@ func DeleteHub(w http.ResponseWriter, r *http.Request, c router.Context) error {
 	if slug == "" {
 		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "slug required"})
 	}
+	// hubs of other users are reported as not found
 	h := data.Hub{}
-	if err := h.Get(db, slug); err != nil {
-		if e, ok := err.(*data.Error); ok {
-			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+	if err := h.Get(db, slug); err != nil || userid != h.UserID {
+		if _, ok := err.(*data.Error); ok || err == nil {
+			return res.NotFound(w, res.ErrorMsg{"record_not_found", "hub not found"})
 		}
 		return err
 	}
 
-	if userid != h.UserID {
-		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "user does not own hub"})
-	}
-
 	// Since all is well, delete hub from database
 	h = data.Hub{
 		Slug: slug,
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+// Inventory documents are small; anything larger is not an inventory.
+const maxInventorySize = 64 << 10
+
+type inventoryPayload struct {
+	Version   int64           `json:"version"`
+	Inventory json.RawMessage `json:"inventory,omitempty"`
+	Changes   json.RawMessage `json:"changes"` // changes from the previous version
+	CreatedAt *time.Time      `json:"created_at"`
+}
+
+func newInventoryPayload(hi *data.HubInventory, withDocument bool) inventoryPayload {
+	p := inventoryPayload{
+		Version:   hi.Version,
+		Changes:   hi.Changes,
+		CreatedAt: hi.CreatedAt,
+	}
+	if withDocument {
+		p.Inventory = hi.Document
+	}
+	return p
+}
+
+// PUT /api/v0/hub/me/inventory
+// Params: access_token, inventory
+// Requires a token issued to the hub itself. inventory is a JSON document
+// (see data.Inventory). A new version is only recorded if it changed.
+func ReportHubInventory(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	v := r.FormValue("inventory")
+	if v == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "inventory required"})
+	}
+	if len(v) > maxInventorySize {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_inventory", "inventory must be at most " + strconv.Itoa(maxInventorySize) + " bytes"})
+	}
+	inv := &data.Inventory{}
+	if err := json.Unmarshal([]byte(v), inv); err != nil {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_inventory", "inventory must be a JSON object"})
+	}
+	if err := inv.Validate(); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	h := data.Hub{ID: c.Meta["hub_id"].(int64)}
+	hi, err := h.ReportInventory(db, inv)
+	if err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	return res.OK(w, newInventoryPayload(hi, false))
+}
+
+// GET /api/v0/hubs/:slug/inventory
+// Params: access_token, (version)
+// Shows the latest inventory reported by the hub, or the given version.
+func ShowHubInventory(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	h, err := ownHub(w, r, c)
+	if h == nil {
+		return err
+	}
+
+	var version int64
+	if v := r.FormValue("version"); v != "" {
+		version, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || version < 1 {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_request", "version must be a positive number"})
+		}
+	}
+
+	hi, err := h.GetInventory(db, version)
+	if err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	return res.OK(w, newInventoryPayload(hi, true))
+}
+
+// GET /api/v0/hubs/:slug/inventory/history
+// Params: access_token
+// Lists what changed in the last 50 inventories of the hub, newest first.
+func ShowHubInventoryHistory(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	h, err := ownHub(w, r, c)
+	if h == nil {
+		return err
+	}
+
+	history, err := h.InventoryHistory(db, 50)
+	if err != nil {
+		return err
+	}
+
+	payload := []inventoryPayload{}
+	for i := range history {
+		payload = append(payload, newInventoryPayload(&history[i], false))
+	}
+
+	return res.OK(w, payload)
+}
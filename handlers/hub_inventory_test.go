@@ -0,0 +1,169 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestHubInventory(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerHub(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a user with a hub
+	u := &data.User{Username: "foo", Email: "foo@example.com", EncryptedPassword: "x"}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	hub := data.Hub{Slug: "abcd", UserID: u.ID}
+	if err := hub.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create tokens for the user and the hub
+	jwtFor := func(tok data.Token) string {
+		if err := tok.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+		jwt, err := tok.EncodeJWT(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jwt
+	}
+	jwt := jwtFor(data.Token{UserID: u.ID, ExpiresIn: time.Hour.Nanoseconds(), Scope: "hub"})
+	hubJWT := jwtFor(data.Token{UserID: u.ID, HubID: &hub.ID, ExpiresIn: time.Hour.Nanoseconds(), Scope: data.DeviceScope})
+
+	report := func(inventory string) string {
+		return "/api/v0/hub/me/inventory?access_token=" + hubJWT + "&inventory=" + url.QueryEscape(inventory)
+	}
+
+	type testCase struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when the hub has not reported an inventory yet
+		{"GET", "/api/v0/hubs/abcd/inventory?access_token=" + jwt, http.StatusNotFound, `{"error":"record_not_found","error_description":"inventory not found"}`},
+
+		// when the hub reports its inventory
+		{"PUT", report(`{"os":"linux","arch":"arm64","hub_version":"1.3.2","apps":[{"name":"zwave","version":"2.0"}]}`), http.StatusOK, ""},
+
+		// when the hub reports a changed inventory
+		{"PUT", report(`{"os":"linux","arch":"arm64","hub_version":"1.4.0","apps":[{"name":"zwave","version":"2.0"}]}`), http.StatusOK, ""},
+
+		// when inventory is not JSON
+		{"PUT", report(`os=linux`), http.StatusBadRequest, `{"error":"invalid_inventory","error_description":"inventory must be a JSON object"}`},
+
+		// when the hub version is not numeric
+		{"PUT", report(`{"os":"linux","arch":"arm64","hub_version":"latest"}`), http.StatusBadRequest, `{"error":"invalid_inventory","error_description":"hub_version must be numbers separated by dots, eg: 1.4.2"}`},
+
+		// when a user token reports an inventory
+		{"PUT", "/api/v0/hub/me/inventory?access_token=" + jwt, http.StatusForbidden, `{"error":"insufficient_scope","error_description":"token is not valid for this scope"}`},
+
+		// when filtering hubs by an invalid version
+		{"GET", "/api/v0/hubs?hub_version_below=new&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_hub_version","error_description":"hub version must be numbers separated by dots, eg: 1.4.2"}`},
+
+		// when the version was never reported
+		{"GET", "/api/v0/hubs/abcd/inventory?version=3&access_token=" + jwt, http.StatusNotFound, `{"error":"record_not_found","error_description":"inventory not found"}`},
+	}
+
+	for _, tc := range tCases {
+		req, err := http.NewRequest(tc.method, ts.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != tc.statusCode {
+			t.Errorf("%s %s - Expected status code %v, Got %v: %s", tc.method, tc.path, tc.statusCode, res.StatusCode, b)
+		}
+		if body := string(b); tc.body != "" && body != tc.body {
+			t.Errorf("%s %s - Expected response body to be %v, Got %v", tc.method, tc.path, tc.body, body)
+		}
+	}
+
+	// the latest inventory is shown, and the first one by version
+	for version, hubVersion := range map[string]string{"": "1.4.0", "1": "1.3.2"} {
+		res, err := http.Get(ts.URL + "/api/v0/hubs/abcd/inventory?version=" + version + "&access_token=" + jwt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := struct {
+			Inventory data.Inventory `json:"inventory"`
+		}{}
+		err = json.NewDecoder(res.Body).Decode(&payload)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload.Inventory.HubVersion != hubVersion {
+			t.Errorf("version %q - Expected hub version %s, Got %+v", version, hubVersion, payload.Inventory)
+		}
+	}
+
+	// the history lists what changed
+	res, err := http.Get(ts.URL + "/api/v0/hubs/abcd/inventory/history?access_token=" + jwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := []struct {
+		Version int64                  `json:"version"`
+		Changes []data.InventoryChange `json:"changes"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&history)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	change := data.InventoryChange{"hub_version", "changed", "1.3.2", "1.4.0"}
+	if len(history) != 2 || history[0].Version != 2 || len(history[0].Changes) != 1 || history[0].Changes[0] != change {
+		t.Errorf("Unexpected inventory history %+v", history)
+	}
+
+	// owners find hubs by their inventory
+	for query, count := range map[string]int{"arch=arm64&hub_version_below=1.4": 0, "arch=arm64&hub_version_at_least=1.4": 1, "app=zwave": 1} {
+		res, err := http.Get(ts.URL + "/api/v0/hubs?" + query + "&access_token=" + jwt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := struct {
+			Hubs []data.Hub `json:"hubs"`
+		}{}
+		err = json.NewDecoder(res.Body).Decode(&payload)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payload.Hubs) != count {
+			t.Errorf("%s - Expected %d hubs, Got %d", query, count, len(payload.Hubs))
+		}
+	}
+}
@@ -3,28 +3,48 @@ package handlers_test
 import (
 	"encoding/json"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/ripple-cloud/cloud/data"
 	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
 	"github.com/ripple-cloud/cloud/router"
 	"github.com/ripple-cloud/cloud/testhelpers"
 )
 
-func setupServerHub(db *sqlx.DB, tokenSecret []byte) (*httptest.Server, error) {
+func setupServerHub(db *sqlx.DB, keys *keyring.Keyring) (*httptest.Server, error) {
 	r := router.New()
 
+	// requests from the test server come from loopback
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
 	r.Default(
-		handlers.SetConfig(db, []byte(tokenSecret)),
+		handlers.SetConfig(db, keys),
+		handlers.SetTrustedProxies([]*net.IPNet{loopback}),
 	)
 
-	r.GET("/api/v0/hub", handlers.Auth, handlers.AddHub)
-	r.POST("/api/v0/hub", handlers.Auth, handlers.ShowHub)
-	r.DELETE("/api/v0/hub", handlers.Auth, handlers.DeleteHub)
+	r.GET("/api/v0/hub", handlers.Auth("hub"), handlers.AddHub)
+	r.DELETE("/api/v0/hub", handlers.Auth("hub"), handlers.DeleteHub)
+	r.GET("/api/v0/hubs", handlers.Auth("hub"), handlers.ShowHubs)
+	r.GET("/api/v0/hubs/:slug", handlers.Auth("hub"), handlers.ShowHub)
+	r.GET("/api/v0/hub/me", handlers.Auth(data.DeviceScope), handlers.ShowHubSelf)
+	r.POST("/api/v0/hub/me/heartbeat", handlers.Auth(data.DeviceScope), handlers.HubHeartbeat)
+	r.GET("/api/v0/hubs/:slug/presence", handlers.Auth("hub"), handlers.ShowHubPresence)
+	r.GET("/api/v0/hubs/:slug/inventory", handlers.Auth("hub"), handlers.ShowHubInventory)
+	r.GET("/api/v0/hubs/:slug/inventory/history", handlers.Auth("hub"), handlers.ShowHubInventoryHistory)
+	r.PUT("/api/v0/hub/me/inventory", handlers.Auth(data.DeviceScope), handlers.ReportHubInventory)
+	r.GET("/api/v0/hubs/:slug/audit", handlers.Auth("hub"), handlers.ShowHubAudit)
+	r.POST("/api/v0/hubs/:slug/transfers", handlers.Auth("hub"), handlers.OfferHub(time.Hour))
+	r.GET("/api/v0/hub_transfers", handlers.Auth("hub"), handlers.ShowHubTransfers)
+	r.POST("/api/v0/hub_transfers/:id/accept", handlers.Auth("hub"), handlers.AcceptHubTransfer)
+	r.POST("/api/v0/hub_transfers/:id/decline", handlers.Auth("hub"), handlers.DeclineHubTransfer)
+	r.DELETE("/api/v0/hub_transfers/:id", handlers.Auth("hub"), handlers.CancelHubTransfer)
+	r.POST("/oauth/token", handlers.UserToken)
 
 	return httptest.NewServer(r), nil
 }
@@ -35,7 +55,8 @@ func TestAddHub(t *testing.T) {
 	defer db.Close()
 
 	// setup server
-	ts, err := setupServerHub(db, []byte("secret"))
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerHub(db, keys)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,13 +78,14 @@ func TestAddHub(t *testing.T) {
 	tok := data.Token{
 		UserID:    u.ID,
 		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(), // 30 days
+		Scope:     "hub",
 	}
 	if err := tok.Insert(db); err != nil {
 		t.Fatal(err)
 	}
 
 	// get the encoded JSON Web Token
-	jwt, err := tok.EncodeJWT([]byte("secret"))
+	jwt, err := tok.EncodeJWT(keys)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -131,13 +153,149 @@ func TestAddHub(t *testing.T) {
 	}
 }
 
-func TestShowHub(t *testing.T) {
+func TestShowHubs(t *testing.T) {
 	// setup DB
 	db := testhelpers.SetupDB(t)
 	defer db.Close()
 
 	// setup server
-	ts, err := setupServerHub(db, []byte("secret"))
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerHub(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create users
+	u := &data.User{Username: "foo", Email: "foo@example.com", EncryptedPassword: "x"}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	u2 := &data.User{Username: "bar", Email: "bar@example.com", EncryptedPassword: "x"}
+	if err = u2.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create tokens for both users
+	jwtFor := func(userID int64) string {
+		tok := data.Token{UserID: userID, ExpiresIn: time.Hour.Nanoseconds(), Scope: "hub"}
+		if err := tok.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+		jwt, err := tok.EncodeJWT(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jwt
+	}
+	jwt, jwt2 := jwtFor(u.ID), jwtFor(u2.ID)
+
+	for _, slug := range []string{"abcd", "efgh", "ijkl"} {
+		hub := data.Hub{Slug: slug, UserID: u.ID}
+		if err := hub.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(path string) (int, []byte) {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	type page struct {
+		Hubs       []data.Hub `json:"hubs"`
+		NextCursor *string    `json:"next_cursor"`
+	}
+
+	// page through the hubs, newest first
+	status, b := get("/api/v0/hubs?sort=-created_at&limit=2&access_token=" + jwt)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	p := page{}
+	if err := json.Unmarshal(b, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Hubs) != 2 || p.Hubs[0].Slug != "ijkl" || p.Hubs[1].Slug != "efgh" || p.NextCursor == nil {
+		t.Fatalf("Unexpected first page %s", b)
+	}
+
+	status, b = get("/api/v0/hubs?sort=-created_at&limit=2&cursor=" + *p.NextCursor + "&access_token=" + jwt)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	p = page{}
+	if err := json.Unmarshal(b, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Hubs) != 1 || p.Hubs[0].Slug != "abcd" || p.NextCursor != nil {
+		t.Fatalf("Unexpected second page %s", b)
+	}
+
+	type testCase struct {
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when filtering the hubs
+		{"/api/v0/hubs?q=EF&access_token=" + jwt, http.StatusOK, ""},
+
+		// when the user has no hubs
+		{"/api/v0/hubs?access_token=" + jwt2, http.StatusOK, `{"hubs":[],"next_cursor":null}`},
+
+		// when the sort is not valid
+		{"/api/v0/hubs?sort=user_id&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_sort","error_description":"sort must be one of created_at, -created_at, slug, -slug"}`},
+
+		// when the limit is not valid
+		{"/api/v0/hubs?limit=0&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"limit must be a number from 1 to 100"}`},
+
+		// when the cursor is not valid
+		{"/api/v0/hubs?cursor=abc&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_cursor","error_description":"cursor is not valid"}`},
+
+		// when the cursor was made for another sort
+		{"/api/v0/hubs?sort=slug&cursor=" + (&data.HubCursor{Sort: "-slug", Value: "x", ID: 1}).Encode() + "&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_cursor","error_description":"cursor is not valid for this sort"}`},
+
+		// when showing a hub of the user
+		{"/api/v0/hubs/abcd?access_token=" + jwt, http.StatusOK, ""},
+
+		// when showing a hub of another user
+		{"/api/v0/hubs/abcd?access_token=" + jwt2, http.StatusNotFound, `{"error":"record_not_found","error_description":"hub not found"}`},
+
+		// when showing an unknown hub
+		{"/api/v0/hubs/unknown?access_token=" + jwt, http.StatusNotFound, `{"error":"record_not_found","error_description":"hub not found"}`},
+
+		// when access_token param is missing
+		{"/api/v0/hubs", http.StatusUnauthorized, `{"error":"invalid_token","error_description":"no token present in request"}`},
+	}
+	for _, tc := range tCases {
+		status, b := get(tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v: %s", tc.path, tc.statusCode, status, b)
+		}
+		if body := string(b); tc.body != "" && body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+}
+
+func TestDeleteHub(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerHub(db, keys)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -159,13 +317,14 @@ func TestShowHub(t *testing.T) {
 	tok := data.Token{
 		UserID:    u.ID,
 		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(), // 30 days
+		Scope:     "hub",
 	}
 	if err := tok.Insert(db); err != nil {
 		t.Fatal(err)
 	}
 
 	// get the encoded JSON Web Token
-	jwt, err := tok.EncodeJWT([]byte("secret"))
+	jwt, err := tok.EncodeJWT(keys)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -178,24 +337,65 @@ func TestShowHub(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// create a hub for another user
+	other := &data.User{Username: "bar", Email: "bar@example.com", EncryptedPassword: "x"}
+	if err := other.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	otherHub := data.Hub{Slug: "efgh", UserID: other.ID}
+	if err := otherHub.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
 	type testCase struct {
 		path       string
 		statusCode int
 		body       string
 	}
 
+	// test when valid params are provided
+	spath := "?slug=abcd&access_token=" + jwt
+	result, err := http.NewRequest("DELETE", ts.URL+"/api/v0/hub"+spath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.DefaultClient.Do(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("%s - Expected status code %v, Got %v", spath, http.StatusOK, res.StatusCode)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := data.Hub{}
+	if err := json.Unmarshal(b, &h); err != nil {
+		t.Errorf("%s - Expected response body to be %+v, Got %s", spath, h, b)
+	}
+
 	tCases := []testCase{
-		// when valid params are provided
-		{"?access_token=" + jwt, http.StatusOK, `{"hub":["abcd"]}`},
+		// when slug param is missing
+		{"?access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"slug required"}`},
 
-		// when access_token param is missing
-		{"?" + jwt, http.StatusUnauthorized, `{"error":"invalid_token","error_description":"no token present in request"}`},
+		// when hub belongs to another user
+		{"?slug=efgh&access_token=" + jwt, http.StatusNotFound, `{"error":"record_not_found","error_description":"hub not found"}`},
 	}
 	for _, tc := range tCases {
-		res, err := http.Post(ts.URL+"/api/v0/hub"+tc.path, "", nil)
+		result, err := http.NewRequest("DELETE", ts.URL+"/api/v0/hub"+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := http.DefaultClient.Do(result)
 		if err != nil {
 			t.Fatal(err)
 		}
+
 		if res.StatusCode != tc.statusCode {
 			t.Errorf("%s - Expected status code %v, Got %v", tc.path, tc.statusCode, res.StatusCode)
 		}
@@ -209,15 +409,74 @@ func TestShowHub(t *testing.T) {
 			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
 		}
 	}
+
+	// the other user's hub is left alone
+	if err := (&data.Hub{}).Get(db, "efgh"); err != nil {
+		t.Errorf("Expected hub efgh to still exist, Got %v", err)
+	}
 }
 
-func TestDeleteHub(t *testing.T) {
+// A hub that does not exist used to be a 400; it is now a 404 like in ShowHub,
+// so that it can't be told apart from a hub of another user.
+func TestDeleteHubNotFound(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerHub(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a user and a token for the user
+	u := &data.User{Username: "foo", Email: "foo@example.com", EncryptedPassword: "x"}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	tok := data.Token{UserID: u.ID, ExpiresIn: time.Hour.Nanoseconds(), Scope: "hub"}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spath := "?slug=1234&access_token=" + jwt
+	req, err := http.NewRequest("DELETE", ts.URL+"/api/v0/hub"+spath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("%s - Expected status code %v, Got %v", spath, http.StatusNotFound, res.StatusCode)
+	}
+	expected := `{"error":"record_not_found","error_description":"hub not found"}`
+	if body := string(b); body != expected {
+		t.Errorf("%s - Expected response body to be %v, Got %v", spath, expected, body)
+	}
+}
+
+func TestHubCredentialsGrant(t *testing.T) {
 	// setup DB
 	db := testhelpers.SetupDB(t)
 	defer db.Close()
 
 	// setup server
-	ts, err := setupServerHub(db, []byte("secret"))
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerHub(db, keys)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -239,83 +498,354 @@ func TestDeleteHub(t *testing.T) {
 	tok := data.Token{
 		UserID:    u.ID,
 		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(), // 30 days
+		Scope:     "hub",
 	}
 	if err := tok.Insert(db); err != nil {
 		t.Fatal(err)
 	}
-
-	// get the encoded JSON Web Token
-	jwt, err := tok.EncodeJWT([]byte("secret"))
+	jwt, err := tok.EncodeJWT(keys)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	hub := data.Hub{
-		Slug:   "abcd",
-		UserID: u.ID,
+	do := func(method, path string) (int, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
 	}
-	if err := hub.Insert(db); err != nil {
+
+	// register a hub and receive its credential
+	status, b := do("GET", "/api/v0/hub?slug=abcd&access_token="+jwt)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	hub := struct {
+		ID        int64  `json:"id"`
+		HubSecret string `json:"hub_secret"`
+	}{}
+	if err := json.Unmarshal(b, &hub); err != nil {
+		t.Fatal(err)
+	}
+	if hub.ID == 0 || hub.HubSecret == "" {
+		t.Fatalf("Unexpected hub registration response %s", b)
+	}
+	hubID := strconv.FormatInt(hub.ID, 10)
+
+	// exchange the credential for a hub token
+	status, b = do("POST", "/oauth/token?grant_type=hub_credentials&hub_id="+hubID+"&hub_secret="+hub.HubSecret)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	payload := struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}{}
+	if err := json.Unmarshal(b, &payload); err != nil {
 		t.Fatal(err)
 	}
+	if payload.AccessToken == "" || payload.RefreshToken != "" || payload.Scope != data.DeviceScope {
+		t.Errorf("Unexpected token response %s", b)
+	}
+	hubJWT := payload.AccessToken
 
 	type testCase struct {
+		method     string
 		path       string
 		statusCode int
 		body       string
 	}
 
-	// test when valid params are provided
-	spath := "?slug=abcd&access_token=" + jwt
-	result, err := http.NewRequest("DELETE", ts.URL+"/api/v0/hub"+spath, nil)
-	if err != nil {
-		t.Fatal(err)
+	tCases := []testCase{
+		// when the hub reads itself
+		{"GET", "/api/v0/hub/me?access_token=" + hubJWT, http.StatusOK, ""},
+
+		// when the hub tries to manage its owner's hubs
+		{"GET", "/api/v0/hubs?access_token=" + hubJWT, http.StatusForbidden, `{"error":"insufficient_scope","error_description":"token is not valid for this scope"}`},
+
+		// when a user token is used as a hub token
+		{"GET", "/api/v0/hub/me?access_token=" + jwt, http.StatusForbidden, `{"error":"insufficient_scope","error_description":"token is not valid for this scope"}`},
+
+		// when the hub secret is wrong
+		{"POST", "/oauth/token?grant_type=hub_credentials&hub_id=" + hubID + "&hub_secret=wrong", http.StatusUnauthorized, `{"error":"invalid_client","error_description":"hub authentication failed"}`},
+
+		// when the hub id is missing
+		{"POST", "/oauth/token?grant_type=hub_credentials", http.StatusUnauthorized, `{"error":"invalid_client","error_description":"hub authentication failed"}`},
+	}
+	for _, tc := range tCases {
+		status, b := do(tc.method, tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v", tc.path, tc.statusCode, status)
+		}
+		if body := string(b); tc.body != "" && body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
 	}
+}
 
-	res, err := http.DefaultClient.Do(result)
-	if err != nil {
-		t.Fatal(err)
+func TestUpdateHub(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	r := router.New()
+	r.Default(handlers.SetConfig(db, keys))
+	r.GET("/api/v0/hubs/:slug", handlers.Auth("hub"), handlers.ShowHub)
+	r.PATCH("/api/v0/hubs/:slug", handlers.Auth("hub"), handlers.UpdateHub(time.Hour))
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// create users with tokens
+	jwtFor := func(username string) (string, int64) {
+		u := &data.User{Username: username, Email: username + "@example.com", EncryptedPassword: "x"}
+		if err := u.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+		tok := data.Token{UserID: u.ID, ExpiresIn: time.Hour.Nanoseconds(), Scope: "hub"}
+		if err := tok.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+		jwt, err := tok.EncodeJWT(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jwt, u.ID
+	}
+	jwt, userID := jwtFor("foo")
+	jwt2, _ := jwtFor("bar")
+
+	for _, slug := range []string{"abcd", "efgh"} {
+		hub := data.Hub{Slug: slug, UserID: userID}
+		if err := hub.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// don't follow redirects, to check them
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(method, path string) (*http.Response, string) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(b)
 	}
 
+	// rename the hub and set its details
+	res, body := do("PATCH", "/api/v0/hubs/abcd?slug=wxyz&name=Living+room&description=By+the+TV&access_token="+jwt)
 	if res.StatusCode != http.StatusOK {
-		t.Errorf("%s - Expected status code %v, Got %v", spath, http.StatusOK, res.StatusCode)
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, res.StatusCode, body)
 	}
-	b, err := ioutil.ReadAll(res.Body)
-	res.Body.Close()
-	if err != nil {
+	h := data.Hub{}
+	if err := json.Unmarshal([]byte(body), &h); err != nil {
 		t.Fatal(err)
 	}
-	h := data.Hub{}
-	if err := json.Unmarshal(b, &h); err != nil {
-		t.Errorf("%s - Expected response body to be %+v, Got %s", spath, h, b)
+	if h.Slug != "wxyz" || h.Name != "Living room" || h.Description != "By the TV" {
+		t.Errorf("Unexpected hub %s", body)
+	}
+
+	// the old slug redirects to the new one
+	res, body = do("GET", "/api/v0/hubs/abcd?access_token="+jwt)
+	if res.StatusCode != http.StatusPermanentRedirect {
+		t.Errorf("Expected status code %v, Got %v", http.StatusPermanentRedirect, res.StatusCode)
+	}
+	if loc := res.Header.Get("Location"); loc != "/api/v0/hubs/wxyz" {
+		t.Errorf("Expected Location to be /api/v0/hubs/wxyz, Got %v", loc)
+	}
+
+	type testCase struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
 	}
 
 	tCases := []testCase{
-		// when slug param is missing
-		{"?access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"slug required"}`},
+		// when the new slug is taken
+		{"PATCH", "/api/v0/hubs/wxyz?slug=efgh&access_token=" + jwt, http.StatusBadRequest, `{"error":"unique_violation","error_description":"hub exists"}`},
+
+		// when the new slug is empty
+		{"PATCH", "/api/v0/hubs/wxyz?slug=&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"slug must not be empty"}`},
 
-		// when hub does not exist
-		{"?slug=1234&access_token=" + jwt, http.StatusBadRequest, `{"error":"record_not_found","error_description":"hub not found"}`},
+		// when only the description is cleared
+		{"PATCH", "/api/v0/hubs/wxyz?description=&access_token=" + jwt, http.StatusOK, ""},
+
+		// when the old slug is used by another user
+		{"GET", "/api/v0/hubs/abcd?access_token=" + jwt2, http.StatusNotFound, `{"error":"record_not_found","error_description":"hub not found"}`},
+
+		// when another user updates the hub
+		{"PATCH", "/api/v0/hubs/wxyz?name=Mine&access_token=" + jwt2, http.StatusNotFound, `{"error":"record_not_found","error_description":"hub not found"}`},
 	}
 	for _, tc := range tCases {
-		result, err := http.NewRequest("DELETE", ts.URL+"/api/v0/hub"+tc.path, nil)
+		res, body := do(tc.method, tc.path)
+		if res.StatusCode != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v: %s", tc.path, tc.statusCode, res.StatusCode, body)
+		}
+		if tc.body != "" && body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+
+	// the name was kept when clearing the description
+	if err := h.Get(db, "wxyz"); err != nil {
+		t.Fatal(err)
+	}
+	if h.Name != "Living room" || h.Description != "" {
+		t.Errorf("Unexpected hub %+v", h)
+	}
+}
+
+func TestHubHeartbeat(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerHub(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a user with a hub
+	u := &data.User{Username: "foo", Email: "foo@example.com", EncryptedPassword: "x"}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	hub := data.Hub{Slug: "abcd", UserID: u.ID}
+	if err := hub.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create tokens for the user and the hub
+	jwtFor := func(tok data.Token) string {
+		if err := tok.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+		jwt, err := tok.EncodeJWT(keys)
 		if err != nil {
 			t.Fatal(err)
 		}
+		return jwt
+	}
+	jwt := jwtFor(data.Token{UserID: u.ID, ExpiresIn: time.Hour.Nanoseconds(), Scope: "hub"})
+	hubJWT := jwtFor(data.Token{UserID: u.ID, HubID: &hub.ID, ExpiresIn: time.Hour.Nanoseconds(), Scope: data.DeviceScope})
 
-		res, err := http.DefaultClient.Do(result)
+	do := func(method, path string) (int, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		if res.StatusCode != tc.statusCode {
-			t.Errorf("%s - Expected status code %v, Got %v", tc.path, tc.statusCode, res.StatusCode)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
 		}
 		b, err := ioutil.ReadAll(res.Body)
 		res.Body.Close()
 		if err != nil {
 			t.Fatal(err)
 		}
+		return res.StatusCode, b
+	}
+
+	type hubStatus struct {
+		Status     string   `json:"status"`
+		LastSeenIP *string  `json:"last_seen_ip"`
+		Uptime     *int64   `json:"uptime"`
+		Version    *string  `json:"version"`
+		Load       *float64 `json:"load"`
+	}
+
+	// a hub that never sent a heartbeat is offline
+	status, b := do("GET", "/api/v0/hubs/abcd?access_token="+jwt)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	hs := hubStatus{}
+	if err := json.Unmarshal(b, &hs); err != nil {
+		t.Fatal(err)
+	}
+	if hs.Status != data.HubOffline {
+		t.Errorf("Expected the hub to be offline, Got %s", b)
+	}
+
+	// a heartbeat brings it online
+	status, b = do("POST", "/api/v0/hub/me/heartbeat?uptime=3600&version=1.3.2&load=0.25&access_token="+hubJWT)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	hs = hubStatus{}
+	if err := json.Unmarshal(b, &hs); err != nil {
+		t.Fatal(err)
+	}
+	if hs.Status != data.HubOnline || hs.LastSeenIP == nil || *hs.LastSeenIP != "203.0.113.7" ||
+		hs.Uptime == nil || *hs.Uptime != 3600 || hs.Version == nil || *hs.Version != "1.3.2" || hs.Load == nil || *hs.Load != 0.25 {
+		t.Errorf("Unexpected heartbeat response %s", b)
+	}
+
+	// going online was recorded
+	status, b = do("GET", "/api/v0/hubs/abcd/presence?access_token="+jwt)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	events := []data.PresenceEvent{}
+	if err := json.Unmarshal(b, &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Status != data.HubOnline {
+		t.Errorf("Unexpected presence events %s", b)
+	}
+
+	type testCase struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when uptime is not a number
+		{"POST", "/api/v0/hub/me/heartbeat?uptime=long&access_token=" + hubJWT, http.StatusBadRequest, `{"error":"invalid_request","error_description":"uptime must be a number of seconds"}`},
+
+		// when load is not a number
+		{"POST", "/api/v0/hub/me/heartbeat?load=NaN&access_token=" + hubJWT, http.StatusBadRequest, `{"error":"invalid_request","error_description":"load must be a positive number"}`},
 
+		// when a user token sends a heartbeat
+		{"POST", "/api/v0/hub/me/heartbeat?access_token=" + jwt, http.StatusForbidden, `{"error":"insufficient_scope","error_description":"token is not valid for this scope"}`},
+	}
+	for _, tc := range tCases {
+		status, b := do(tc.method, tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v: %s", tc.path, tc.statusCode, status, b)
+		}
 		if body := string(b); body != tc.body {
 			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
 		}
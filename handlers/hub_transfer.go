@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+type hubTransferPayload struct {
+	ID        int64      `json:"id"`
+	Hub       string     `json:"hub"`  // slug of the hub offered
+	From      string     `json:"from"` // username of the owner
+	To        string     `json:"to"`   // username of the recipient
+	CreatedAt *time.Time `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+// POST /api/v0/hubs/:slug/transfers
+// Params: access_token, to
+// Offers the hub to the user whose username or email is to. The recipient
+// has expiry to accept it; a new offer replaces the pending one.
+func OfferHub(expiry time.Duration) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request, c router.Context) error {
+		db, _ := c.Meta["db"].(*sqlx.DB)
+
+		h, err := ownHub(w, r, c)
+		if h == nil {
+			return err
+		}
+
+		login := r.FormValue("to")
+		if login == "" {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_request", "to required"})
+		}
+
+		// users leaving are as good as gone
+		to := data.User{}
+		if err := to.GetByLogin(db, login); err != nil || to.DeletionScheduled() {
+			if _, ok := err.(*data.Error); ok || err == nil {
+				return res.NotFound(w, res.ErrorMsg{"record_not_found", "user not found"})
+			}
+			return err
+		}
+		if to.ID == h.UserID {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_request", "hub is already owned by this user"})
+		}
+
+		ht := &data.HubTransfer{
+			HubID:      h.ID,
+			FromUserID: h.UserID,
+			ToUserID:   to.ID,
+			ExpiresIn:  expiry.Nanoseconds(),
+		}
+		if err := ht.Insert(db); err != nil {
+			if e, ok := err.(*data.Error); ok {
+				return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+			}
+			return err
+		}
+
+		from := data.User{}
+		if err := from.Get(db, h.UserID); err != nil {
+			return err
+		}
+
+		return res.Created(w, hubTransferPayload{
+			ht.ID,
+			h.Slug,
+			from.Username,
+			to.Username,
+			ht.CreatedAt,
+			ht.ExpiresAt(),
+		})
+	}
+}
+
+// GET /api/v0/hub_transfers
+// Params: access_token
+// Lists the pending offers made to or by the current user.
+func ShowHubTransfers(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	transfers := data.PendingHubTransfers{}
+	if err := transfers.SelectByUserID(db, c.Meta["user_id"].(int64)); err != nil {
+		return err
+	}
+
+	payload := []hubTransferPayload{}
+	for _, ht := range transfers {
+		payload = append(payload, hubTransferPayload{
+			ht.ID,
+			ht.HubSlug,
+			ht.FromUsername,
+			ht.ToUsername,
+			ht.CreatedAt,
+			ht.ExpiresAt(),
+		})
+	}
+
+	return res.OK(w, payload)
+}
+
+// POST /api/v0/hub_transfers/:id/accept
+// Params: access_token
+// Makes the current user the owner of the hub offered to them. The hub gets
+// a new secret, only shown in this response, and its tokens are revoked.
+func AcceptHubTransfer(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	ht, err := hubTransfer(w, c, func(ht *data.HubTransfer, userID int64) bool { return ht.ToUserID == userID })
+	if ht == nil {
+		return err
+	}
+
+	h := &data.Hub{}
+	secret, err := ht.Accept(db, h)
+	if err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	// the hub exchanges its credential for tokens (grant_type=hub_credentials)
+	payload := struct {
+		hubPayload
+		HubSecret string `json:"hub_secret"`
+	}{
+		newHubPayload(h),
+		secret,
+	}
+
+	return res.OK(w, payload)
+}
+
+// POST /api/v0/hub_transfers/:id/decline
+// Params: access_token
+// Refuses a hub offered to the current user.
+func DeclineHubTransfer(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	ht, err := hubTransfer(w, c, func(ht *data.HubTransfer, userID int64) bool { return ht.ToUserID == userID })
+	if ht == nil {
+		return err
+	}
+
+	if err := ht.Validate(); err == nil {
+		err = ht.Decline(db)
+	}
+	if err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	return res.OK(w, struct{}{})
+}
+
+// DELETE /api/v0/hub_transfers/:id
+// Params: access_token
+// Withdraws an offer made by the current user.
+func CancelHubTransfer(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	ht, err := hubTransfer(w, c, func(ht *data.HubTransfer, userID int64) bool { return ht.FromUserID == userID })
+	if ht == nil {
+		return err
+	}
+
+	if err := ht.Cancel(db); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	return res.OK(w, struct{}{})
+}
+
+// hubTransfer gets the transfer with the id in the path, if the current user
+// is the party that can act on it. Returns nil if it responded.
+func hubTransfer(w http.ResponseWriter, c router.Context, party func(*data.HubTransfer, int64) bool) (*data.HubTransfer, error) {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	id, err := strconv.ParseInt(c.Params.ByName("id"), 10, 64)
+	if err != nil {
+		return nil, res.BadRequest(w, res.ErrorMsg{"invalid_request", "id must be a number"})
+	}
+
+	// other users' transfers are reported as not found
+	ht := &data.HubTransfer{}
+	if err := ht.Get(db, id); err != nil || !party(ht, c.Meta["user_id"].(int64)) {
+		if _, ok := err.(*data.Error); ok || err == nil {
+			return nil, res.NotFound(w, res.ErrorMsg{"record_not_found", "hub transfer not found"})
+		}
+		return nil, err
+	}
+
+	return ht, nil
+}
+
+type hubAuditEventPayload struct {
+	ID        int64           `json:"id"`
+	UserID    *int64          `json:"user_id"` // who made the change
+	Action    string          `json:"action"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt *time.Time      `json:"created_at"`
+}
+
+// GET /api/v0/hubs/:slug/audit
+// Params: access_token
+// Lists the last 50 audit events of the hub, such as transfers, newest first.
+func ShowHubAudit(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	h, err := ownHub(w, r, c)
+	if h == nil {
+		return err
+	}
+
+	events, err := h.AuditEvents(db, 50)
+	if err != nil {
+		return err
+	}
+
+	payload := []hubAuditEventPayload{}
+	for _, e := range events {
+		payload = append(payload, hubAuditEventPayload{e.ID, e.UserID, e.Action, e.Details, e.CreatedAt})
+	}
+
+	return res.OK(w, payload)
+}
@@ -0,0 +1,173 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func TestHubTransfer(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerHub(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create two users, the first with a hub
+	u := &data.User{Username: "foo", Email: "foo@example.com", EncryptedPassword: "x"}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	u2 := &data.User{Username: "bar", Email: "bar@example.com", EncryptedPassword: "x"}
+	if err = u2.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	hub := data.Hub{Slug: "abcd", UserID: u.ID}
+	if err := hub.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create tokens for the users
+	jwtFor := func(userID int64) string {
+		tok := data.Token{UserID: userID, ExpiresIn: time.Hour.Nanoseconds(), Scope: "hub"}
+		if err := tok.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+		jwt, err := tok.EncodeJWT(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jwt
+	}
+	jwt, jwt2 := jwtFor(u.ID), jwtFor(u2.ID)
+
+	do := func(method, path string) (int, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	// offer the hub to the second user, by email
+	status, b := do("POST", "/api/v0/hubs/abcd/transfers?to=BAR@example.com&access_token="+jwt)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusCreated, status, b)
+	}
+	offer := struct {
+		ID   int64  `json:"id"`
+		Hub  string `json:"hub"`
+		From string `json:"from"`
+		To   string `json:"to"`
+	}{}
+	if err := json.Unmarshal(b, &offer); err != nil {
+		t.Fatal(err)
+	}
+	if offer.Hub != "abcd" || offer.From != "foo" || offer.To != "bar" {
+		t.Errorf("Unexpected offer %s", b)
+	}
+	id := strconv.FormatInt(offer.ID, 10)
+
+	type testCase struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when the recipient does not exist
+		{"POST", "/api/v0/hubs/abcd/transfers?to=nobody&access_token=" + jwt, http.StatusNotFound, `{"error":"record_not_found","error_description":"user not found"}`},
+
+		// when the owner offers the hub to themselves
+		{"POST", "/api/v0/hubs/abcd/transfers?to=foo&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"hub is already owned by this user"}`},
+
+		// when someone else offers the hub
+		{"POST", "/api/v0/hubs/abcd/transfers?to=bar&access_token=" + jwt2, http.StatusNotFound, `{"error":"record_not_found","error_description":"hub not found"}`},
+
+		// when the owner accepts their own offer
+		{"POST", "/api/v0/hub_transfers/" + id + "/accept?access_token=" + jwt, http.StatusNotFound, `{"error":"record_not_found","error_description":"hub transfer not found"}`},
+
+		// when the recipient cancels the offer
+		{"DELETE", "/api/v0/hub_transfers/" + id + "?access_token=" + jwt2, http.StatusNotFound, `{"error":"record_not_found","error_description":"hub transfer not found"}`},
+
+		// when the recipient lists pending offers
+		{"GET", "/api/v0/hub_transfers?access_token=" + jwt2, http.StatusOK, ""},
+
+		// when the recipient accepts the offer
+		{"POST", "/api/v0/hub_transfers/" + id + "/accept?access_token=" + jwt2, http.StatusOK, ""},
+
+		// when the offer was already accepted
+		{"POST", "/api/v0/hub_transfers/" + id + "/decline?access_token=" + jwt2, http.StatusBadRequest, `{"error":"transfer_handled","error_description":"hub transfer was already handled"}`},
+
+		// when the previous owner looks for the hub
+		{"GET", "/api/v0/hubs/abcd?access_token=" + jwt, http.StatusNotFound, `{"error":"record_not_found","error_description":"hub not found"}`},
+
+		// when the new owner looks for the hub
+		{"GET", "/api/v0/hubs/abcd?access_token=" + jwt2, http.StatusOK, ""},
+
+		// when no offers are pending anymore
+		{"GET", "/api/v0/hub_transfers?access_token=" + jwt, http.StatusOK, `[]`},
+	}
+
+	for _, tc := range tCases {
+		status, b := do(tc.method, tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s %s - Expected status code %v, Got %v: %s", tc.method, tc.path, tc.statusCode, status, b)
+		}
+		if body := string(b); tc.body != "" && body != tc.body {
+			t.Errorf("%s %s - Expected response body to be %v, Got %v", tc.method, tc.path, tc.body, body)
+		}
+	}
+
+	// the new owner sees the transfer in the audit log
+	status, b = do("GET", "/api/v0/hubs/abcd/audit?access_token="+jwt2)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	events := []struct {
+		Action  string           `json:"action"`
+		Details map[string]int64 `json:"details"`
+	}{}
+	if err := json.Unmarshal(b, &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Action != data.HubTransferred || events[0].Details["from_user_id"] != u.ID {
+		t.Errorf("Unexpected audit events %s", b)
+	}
+
+	// the new owner can offer the hub back, and the offer be declined
+	status, b = do("POST", "/api/v0/hubs/abcd/transfers?to=foo&access_token="+jwt2)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusCreated, status, b)
+	}
+	if err := json.Unmarshal(b, &offer); err != nil {
+		t.Fatal(err)
+	}
+	status, b = do("POST", "/api/v0/hub_transfers/"+strconv.FormatInt(offer.ID, 10)+"/decline?access_token="+jwt)
+	if status != http.StatusOK {
+		t.Errorf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+}
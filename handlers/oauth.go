@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+// POST /oauth/revoke
+// Params: token, (token_type_hint), (client_id, client_secret)
+// Tokens issued to a client require ClientAuth of that client.
+// Responds with 200 whether or not the token was valid, as per RFC 7009.
+func RevokeToken(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, ok := c.Meta["db"].(*sqlx.DB)
+	if !ok {
+		return errors.New("db not set in context")
+	}
+	keys, ok := c.Meta["keyring"].(*keyring.Keyring)
+	if !ok {
+		return errors.New("keyring not set in context")
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "token required"})
+	}
+
+	// personal tokens are told apart by their prefix, whatever the hint
+	if strings.HasPrefix(token, data.PersonalTokenPrefix) {
+		if err := revokePersonalToken(db, token); err != nil {
+			return err
+		}
+		return res.OK(w, struct{}{})
+	}
+
+	// the hint only decides which kind of token is looked up first
+	var t *data.Token
+	var rt *data.RefreshToken
+	var err error
+	switch r.FormValue("token_type_hint") {
+	case "", "access_token":
+		t, err = getAccessToken(db, keys, token)
+		if err == nil && t == nil {
+			rt, err = getRefreshToken(db, token)
+		}
+	case "refresh_token":
+		rt, err = getRefreshToken(db, token)
+		if err == nil && rt == nil {
+			t, err = getAccessToken(db, keys, token)
+		}
+	default:
+		return res.BadRequest(w, res.ErrorMsg{"unsupported_token_type", "supports only access_token and refresh_token token types"})
+	}
+	if err != nil {
+		return err
+	}
+
+	var clientID *int64
+	switch {
+	case t != nil:
+		clientID = t.ClientID
+	case rt != nil:
+		clientID = rt.ClientID
+	default:
+		return res.OK(w, struct{}{})
+	}
+
+	// tokens issued to a client can only be revoked by that client; those of
+	// another client are treated as unknown
+	if clientID != nil {
+		cl, err := authenticateClient(r, db)
+		if err != nil {
+			if e, ok := err.(*data.Error); ok {
+				return clientError(w, e)
+			}
+			return err
+		}
+		if cl.ID != *clientID {
+			return res.OK(w, struct{}{})
+		}
+	}
+
+	if t != nil {
+		err = t.Revoke(db)
+	} else {
+		err = rt.RevokeFamily(db)
+	}
+	if err != nil {
+		return err
+	}
+
+	return res.OK(w, struct{}{})
+}
+
+// getAccessToken gets the access token encoded in a JWT.
+// Returns nil if the JWT doesn't verify or refers to an unknown token.
+func getAccessToken(db *sqlx.DB, keys *keyring.Keyring, token string) (*data.Token, error) {
+	j, err := jwt.Parse(token, keys.Keyfunc)
+	if err != nil {
+		return nil, nil
+	}
+	jti, ok := j.Claims["jti"].(float64)
+	if !ok {
+		return nil, nil
+	}
+
+	t := &data.Token{}
+	if err := t.Get(db, int64(jti)); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// getRefreshToken gets a refresh token.
+// Returns nil if the refresh token is unknown.
+func getRefreshToken(db *sqlx.DB, token string) (*data.RefreshToken, error) {
+	rt := &data.RefreshToken{}
+	if err := rt.GetByToken(db, token); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return rt, nil
+}
+
+// revokePersonalToken revokes a personal token. Unknown tokens are ignored.
+func revokePersonalToken(db *sqlx.DB, token string) error {
+	pt := data.PersonalToken{}
+	if err := pt.GetByToken(db, token); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return nil
+		}
+		return err
+	}
+	return pt.Revoke(db)
+}
+
+// POST /oauth/introspect
+// Params: token, (token_type_hint)
+// Requires ClientAuth. Responds with only `active: false` for tokens that
+// are malformed, expired, revoked or unknown, as per RFC 7662.
+func IntrospectToken(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, ok := c.Meta["db"].(*sqlx.DB)
+	if !ok {
+		return errors.New("db not set in context")
+	}
+	keys, ok := c.Meta["keyring"].(*keyring.Keyring)
+	if !ok {
+		return errors.New("keyring not set in context")
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "token required"})
+	}
+
+	type introspection struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		Sub       string `json:"sub,omitempty"`
+		UserID    int64  `json:"user_id,omitempty"`
+		HubID     int64  `json:"hub_id,omitempty"`
+		Exp       int64  `json:"exp,omitempty"`
+		Iat       int64  `json:"iat,omitempty"`
+	}
+	inactive := introspection{Active: false}
+
+	// validate personal tokens the same way as personalTokenAuth
+	if strings.HasPrefix(token, data.PersonalTokenPrefix) {
+		pt := data.PersonalToken{}
+		if err := pt.GetByToken(db, token); err != nil {
+			if _, ok := err.(*data.Error); ok {
+				return res.OK(w, inactive)
+			}
+			return err
+		}
+		if err := pt.Validate(); err != nil {
+			return res.OK(w, inactive)
+		}
+
+		i := introspection{
+			Active:    true,
+			Scope:     pt.Scope,
+			TokenType: "bearer",
+			Sub:       strconv.FormatInt(pt.UserID, 10),
+			UserID:    pt.UserID,
+			Iat:       pt.CreatedAt.Unix(),
+		}
+		// personal tokens may never expire
+		if exp := pt.ExpiresAt(); exp != nil {
+			i.Exp = exp.Unix()
+		}
+		return res.OK(w, i)
+	}
+
+	// validate the token the same way as Auth
+	j, err := jwt.Parse(token, keys.Keyfunc)
+	if err != nil {
+		return res.OK(w, inactive)
+	}
+	t, err := verifyToken(db, j)
+	if err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.OK(w, inactive)
+		}
+		return err
+	}
+
+	i := introspection{
+		Active:    true,
+		Scope:     t.Scope,
+		TokenType: "bearer",
+		Exp:       t.ExpiresAt().Unix(),
+		Iat:       t.CreatedAt.Unix(),
+	}
+	// the subject of a hub token is the hub, not its owner
+	if t.HubID != nil {
+		i.Sub = "hub:" + strconv.FormatInt(*t.HubID, 10)
+		i.HubID = *t.HubID
+	} else {
+		i.Sub = strconv.FormatInt(t.UserID, 10)
+		i.UserID = t.UserID
+	}
+
+	return res.OK(w, i)
+}
+
+// GET /.well-known/jwks.json
+// Publishes the public keys verifying access tokens, as per RFC 7517.
+func JWKS(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	keys, ok := c.Meta["keyring"].(*keyring.Keyring)
+	if !ok {
+		return errors.New("keyring not set in context")
+	}
+
+	return res.OK(w, keys.JWKS())
+}
@@ -0,0 +1,393 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func setupServerOAuth(db *sqlx.DB, keys *keyring.Keyring) (*httptest.Server, error) {
+	r := router.New()
+
+	r.Default(
+		handlers.SetConfig(db, keys),
+	)
+
+	r.POST("/oauth/revoke", handlers.RevokeToken)
+	r.POST("/oauth/introspect", handlers.ClientAuth(map[string]string{"relay": "relay-secret"}), handlers.IntrospectToken)
+
+	return httptest.NewServer(r), nil
+}
+
+func TestRevokeToken(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerOAuth(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a user
+	u := &data.User{
+		Username: "foo",
+		Email:    "foo@example.com",
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a token for the user
+	tok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(), // 30 days
+		Scope:     "hub",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// get the encoded JSON Web Token
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create a personal token for the user
+	pt := data.PersonalToken{UserID: u.ID, Name: "script", Scope: "user"}
+	personal, err := pt.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when token param is missing
+		{"", http.StatusBadRequest, `{"error":"invalid_request","error_description":"token required"}`},
+
+		// when token type hint is unsupported
+		{"?token_type_hint=id_token&token=" + jwt, http.StatusBadRequest, `{"error":"unsupported_token_type","error_description":"supports only access_token and refresh_token token types"}`},
+
+		// when a valid access token is provided
+		{"?token=" + jwt, http.StatusOK, `{}`},
+
+		// when the token is unknown
+		{"?token_type_hint=refresh_token&token=invalid", http.StatusOK, `{}`},
+
+		// when a personal token is provided
+		{"?token=" + url.QueryEscape(personal), http.StatusOK, `{}`},
+	}
+	for _, tc := range tCases {
+		res, err := http.Post(ts.URL+"/oauth/revoke"+tc.path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v", tc.path, tc.statusCode, res.StatusCode)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if body := string(b); body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+
+	// check the access token was revoked
+	if err := tok.Get(db, tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if tok.RevokedAt == nil {
+		t.Error("Expected the token to be revoked")
+	}
+	if err := pt.Get(db, pt.ID); err != nil {
+		t.Fatal(err)
+	}
+	if pt.RevokedAt == nil {
+		t.Error("Expected the personal token to be revoked")
+	}
+}
+
+func TestRevokeClientToken(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerOAuth(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a user
+	u := &data.User{Username: "foo", Email: "foo@example.com", EncryptedPassword: "x"}
+	if err := u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create two clients for the user
+	clientFor := func(name string) (*data.Client, string) {
+		cl := &data.Client{Name: name, Scope: "hub", UserID: u.ID}
+		secret, err := cl.GenerateCredentials()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+		return cl, secret
+	}
+	cl, secret := clientFor("ci")
+	other, otherSecret := clientFor("other")
+
+	// create a token issued to the first client
+	tok := data.Token{UserID: u.ID, ClientID: &cl.ID, ExpiresIn: time.Hour.Nanoseconds(), Scope: "hub"}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when client credentials are missing
+		{"?token=" + jwt, http.StatusUnauthorized, `{"error":"invalid_client","error_description":"client authentication failed"}`},
+
+		// when client credentials are wrong
+		{"?token=" + jwt + "&client_id=" + cl.ClientID + "&client_secret=wrong", http.StatusUnauthorized, `{"error":"invalid_client","error_description":"client authentication failed"}`},
+
+		// when another client revokes the token, it is treated as unknown
+		{"?token=" + jwt + "&client_id=" + other.ClientID + "&client_secret=" + url.QueryEscape(otherSecret), http.StatusOK, `{}`},
+	}
+	for _, tc := range tCases {
+		res, err := http.Post(ts.URL+"/oauth/revoke"+tc.path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v", tc.path, tc.statusCode, res.StatusCode)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if body := string(b); body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+
+	// the token is still valid
+	if err := tok.Get(db, tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if tok.RevokedAt != nil {
+		t.Fatal("Expected the token not to be revoked")
+	}
+
+	// the client the token was issued to can revoke it
+	res, err := http.Post(ts.URL+"/oauth/revoke?token="+jwt+"&client_id="+cl.ClientID+"&client_secret="+url.QueryEscape(secret), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %v, Got %v", http.StatusOK, res.StatusCode)
+	}
+	if err := tok.Get(db, tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if tok.RevokedAt == nil {
+		t.Error("Expected the token to be revoked")
+	}
+}
+
+func TestIntrospectToken(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerOAuth(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a user
+	u := &data.User{
+		Username: "foo",
+		Email:    "foo@example.com",
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a token for the user
+	tok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(), // 30 days
+		Scope:     "hub",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// get the encoded JSON Web Token
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test when a valid token is introspected
+	spath := "?client_id=relay&client_secret=relay-secret&token=" + jwt
+	res, err := http.Post(ts.URL+"/oauth/introspect"+spath, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("%s - Expected status code %v, Got %v", spath, http.StatusOK, res.StatusCode)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := struct {
+		Active bool   `json:"active"`
+		Sub    string `json:"sub"`
+		Scope  string `json:"scope"`
+		UserID int64  `json:"user_id"`
+		Exp    int64  `json:"exp"`
+	}{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if !payload.Active || payload.UserID != u.ID || payload.Sub != "1" || payload.Scope != "hub" || payload.Exp == 0 {
+		t.Errorf("%s - Unexpected response body %s", spath, b)
+	}
+
+	// create a personal token for the user
+	pt := data.PersonalToken{UserID: u.ID, Name: "script", Scope: "user"}
+	personal, err := pt.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// test when a valid personal token is introspected
+	spath = "?client_id=relay&client_secret=relay-secret&token=" + url.QueryEscape(personal)
+	res, err = http.Post(ts.URL+"/oauth/introspect"+spath, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload.Exp = 0
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if !payload.Active || payload.UserID != u.ID || payload.Scope != "user" || payload.Exp != 0 {
+		t.Errorf("%s - Unexpected response body %s", spath, b)
+	}
+
+	// revoke the tokens
+	if err := tok.Revoke(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.Revoke(db); err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when client credentials are missing
+		{"?token=" + jwt, http.StatusUnauthorized, `{"error":"invalid_client","error_description":"client authentication failed"}`},
+
+		// when client secret is incorrect
+		{"?client_id=relay&client_secret=invalid&token=" + jwt, http.StatusUnauthorized, `{"error":"invalid_client","error_description":"client authentication failed"}`},
+
+		// when token param is missing
+		{"?client_id=relay&client_secret=relay-secret", http.StatusBadRequest, `{"error":"invalid_request","error_description":"token required"}`},
+
+		// when token is malformed
+		{"?client_id=relay&client_secret=relay-secret&token=invalid", http.StatusOK, `{"active":false}`},
+
+		// when token is revoked
+		{"?client_id=relay&client_secret=relay-secret&token=" + jwt, http.StatusOK, `{"active":false}`},
+
+		// when personal token is revoked
+		{"?client_id=relay&client_secret=relay-secret&token=" + url.QueryEscape(personal), http.StatusOK, `{"active":false}`},
+	}
+	for _, tc := range tCases {
+		res, err := http.Post(ts.URL+"/oauth/introspect"+tc.path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v", tc.path, tc.statusCode, res.StatusCode)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if body := string(b); body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/totp"
+)
+
+// otpIssuer names the service in authenticator apps.
+const otpIssuer = "Ripple"
+
+// verifyOTP checks a code from the user's authenticator app or one of their
+// recovery codes. Each code is accepted only once.
+func verifyOTP(db *sqlx.DB, u *data.User, code string) (bool, error) {
+	if u.OTPSecret == nil {
+		return false, nil
+	}
+
+	var err error
+	if len(code) == totp.Digits {
+		counter, ok := totp.Validate(*u.OTPSecret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		err = u.UseOTPCounter(db, counter)
+	} else {
+		err = u.UseRecoveryCode(db, code)
+	}
+
+	if err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// POST /api/v0/user/otp
+// Params: access_token, current_password
+// Starts enrolling two-factor authentication. The otpauth URI is meant to be
+// shown as a QR code; the enrolment is confirmed with POST /api/v0/user/otp/confirm.
+func EnrollOTP(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	u := data.User{}
+	if err := u.Get(db, c.Meta["user_id"].(int64)); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	if !u.VerifyPassword(r.FormValue("current_password")) {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_password", "current password is not valid"})
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return err
+	}
+	if err := u.SetOTPSecret(db, secret); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	payload := struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}{
+		secret,
+		totp.URI(otpIssuer, u.Email, secret),
+	}
+
+	return res.OK(w, payload)
+}
+
+// POST /api/v0/user/otp/confirm
+// Params: access_token, otp
+// Requires the OTP to log in from now on. The recovery codes are only ever
+// shown in this response.
+func ConfirmOTP(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	u := data.User{}
+	if err := u.Get(db, c.Meta["user_id"].(int64)); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	if u.OTPEnabled() {
+		return res.BadRequest(w, res.ErrorMsg{"otp_enabled", "two-factor authentication is already enabled"})
+	}
+
+	// only a code from the app confirms it was set up
+	otp := r.FormValue("otp")
+	if len(otp) != totp.Digits {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_otp", "otp is not valid"})
+	}
+	if ok, err := verifyOTP(db, &u, otp); !ok {
+		if err != nil {
+			return err
+		}
+		return res.BadRequest(w, res.ErrorMsg{"invalid_otp", "otp is not valid"})
+	}
+
+	codes, err := u.EnableOTP(db)
+	if err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	payload := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		codes,
+	}
+
+	return res.OK(w, payload)
+}
+
+// DELETE /api/v0/user/otp
+// Params: access_token, current_password, otp
+func DisableOTP(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	u := data.User{}
+	if err := u.Get(db, c.Meta["user_id"].(int64)); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	if !u.VerifyPassword(r.FormValue("current_password")) {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_password", "current password is not valid"})
+	}
+	if u.OTPEnabled() {
+		if ok, err := verifyOTP(db, &u, r.FormValue("otp")); !ok {
+			if err != nil {
+				return err
+			}
+			return res.BadRequest(w, res.ErrorMsg{"invalid_otp", "otp is not valid"})
+		}
+	}
+
+	if err := u.DisableOTP(db); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	return res.OK(w, struct{}{})
+}
@@ -0,0 +1,181 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/testhelpers"
+	"github.com/ripple-cloud/cloud/totp"
+)
+
+func setupServerOTP(db *sqlx.DB, keys *keyring.Keyring) (*httptest.Server, error) {
+	r := router.New()
+
+	r.Default(
+		handlers.SetConfig(db, keys),
+	)
+
+	r.POST("/oauth/token", handlers.UserToken)
+	r.POST("/api/v0/user/otp", handlers.Auth("user"), handlers.EnrollOTP)
+	r.POST("/api/v0/user/otp/confirm", handlers.Auth("user"), handlers.ConfirmOTP)
+	r.DELETE("/api/v0/user/otp", handlers.Auth("user"), handlers.DisableOTP)
+
+	return httptest.NewServer(r), nil
+}
+
+func TestOTPLogin(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerOTP(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a verified user
+	now := time.Now()
+	u := &data.User{
+		Username:        "foo",
+		Email:           "foo@example.com",
+		EmailVerifiedAt: &now,
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a token for the user
+	tok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "user",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string) (int, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	// enrol
+	status, b := do("POST", "/api/v0/user/otp?current_password=password&access_token="+jwt)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	enrolment := struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}{}
+	if err := json.Unmarshal(b, &enrolment); err != nil {
+		t.Fatal(err)
+	}
+	if enrolment.Secret == "" || enrolment.URI == "" {
+		t.Fatalf("Unexpected enrolment response %s", b)
+	}
+
+	code := func(period int64) string {
+		c, err := totp.Code(enrolment.Secret, totp.Counter(time.Now())+period)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// confirm with a code from the app
+	status, b = do("POST", "/api/v0/user/otp/confirm?otp="+code(0)+"&access_token="+jwt)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	confirmation := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{}
+	if err := json.Unmarshal(b, &confirmation); err != nil {
+		t.Fatal(err)
+	}
+	if len(confirmation.RecoveryCodes) != 10 {
+		t.Fatalf("Unexpected confirmation response %s", b)
+	}
+	recovery := confirmation.RecoveryCodes[0]
+	login := "/oauth/token?grant_type=password&login=foo&password=password"
+
+	type testCase struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when the otp is missing
+		{"POST", login, http.StatusBadRequest, `{"error":"mfa_required","error_description":"otp required"}`},
+
+		// when the otp is wrong
+		{"POST", login + "&otp=abcdef", http.StatusBadRequest, `{"error":"invalid_grant","error_description":"otp is not valid"}`},
+
+		// when the otp is valid
+		{"POST", login + "&otp=" + code(1), http.StatusOK, ""},
+
+		// when the otp is replayed
+		{"POST", login + "&otp=" + code(1), http.StatusBadRequest, `{"error":"invalid_grant","error_description":"otp is not valid"}`},
+
+		// when a recovery code is used
+		{"POST", login + "&otp=" + recovery, http.StatusOK, ""},
+
+		// when a recovery code is reused
+		{"POST", login + "&otp=" + recovery, http.StatusBadRequest, `{"error":"invalid_grant","error_description":"otp is not valid"}`},
+
+		// when enrolling again
+		{"POST", "/api/v0/user/otp?current_password=password&access_token=" + jwt, http.StatusBadRequest, `{"error":"otp_enabled","error_description":"two-factor authentication is already enabled"}`},
+
+		// when disabling without an otp
+		{"DELETE", "/api/v0/user/otp?current_password=password&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_otp","error_description":"otp is not valid"}`},
+
+		// when disabling with a recovery code
+		{"DELETE", "/api/v0/user/otp?current_password=password&otp=" + confirmation.RecoveryCodes[1] + "&access_token=" + jwt, http.StatusOK, `{}`},
+
+		// when otp is disabled
+		{"POST", login, http.StatusOK, ""},
+	}
+	for _, tc := range tCases {
+		status, b := do(tc.method, tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v: %s", tc.path, tc.statusCode, status, b)
+		}
+		if body := string(b); tc.body != "" && body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/mailer"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+const passwordResetExpiry = time.Hour
+
+// POST /password/forgot
+// Params: login
+// Mails a password reset link. Always responds 202, so it can't be used to
+// find out who signed up.
+func ForgotPassword(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+	m, ok := c.Meta["mailer"].(mailer.Mailer)
+	if !ok {
+		return errors.New("mailer not set in context")
+	}
+	publicURL, _ := c.Meta["public_url"].(string)
+
+	login := r.FormValue("login")
+	if login == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "login required"})
+	}
+
+	u := data.User{}
+	if err := u.GetByLogin(db, login); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.Respond(w, http.StatusAccepted, struct{}{})
+		}
+		return err
+	}
+
+	pr := data.PasswordReset{
+		UserID:    u.ID,
+		ExpiresIn: passwordResetExpiry.Nanoseconds(),
+	}
+	token, err := pr.Generate()
+	if err != nil {
+		return err
+	}
+	if err := pr.Insert(db); err != nil {
+		return err
+	}
+
+	err = m.Send(mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your Ripple password",
+		Body: "Hi " + u.Username + ",\n\n" +
+			"Someone asked to reset your password. To choose a new one, open the link below within an hour:\n\n" +
+			publicURL + "/password/reset?token=" + url.QueryEscape(token) + "\n\n" +
+			"If it wasn't you, you can ignore this email.\n",
+	})
+	if err != nil {
+		// failing here would tell that the login exists
+		log.Print("[error] Failed to send password reset email: ", err)
+	}
+
+	return res.Respond(w, http.StatusAccepted, struct{}{})
+}
+
+// POST /password/reset
+// Params: token, password
+// Sets a new password and revokes every token of the user.
+func ResetPassword(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	token := r.FormValue("token")
+	if token == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "token required"})
+	}
+	password := r.FormValue("password")
+	if password == "" {
+		return res.BadRequest(w, res.ErrorMsg{"password_required", "password required"})
+	}
+
+	pr := data.PasswordReset{}
+	if err := pr.GetByToken(db, token); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_token", "reset token is not valid"})
+		}
+		return err
+	}
+	if pr.Expired() {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_token", "reset token is expired"})
+	}
+
+	u := data.User{}
+	if err := u.Get(db, pr.UserID); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_token", "reset token is not valid"})
+		}
+		return err
+	}
+	if err := passwordPolicy(c).Check(password, u.Username); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	if err := u.EncryptPassword(password); err != nil {
+		return err
+	}
+	if err := pr.Reset(db, &u); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_token", "reset token is not valid"})
+		}
+		return err
+	}
+
+	return res.OK(w, struct{}{})
+}
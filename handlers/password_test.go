@@ -0,0 +1,194 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/mailer"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func setupServerPassword(db *sqlx.DB, keys *keyring.Keyring, outbox *mailer.Outbox) (*httptest.Server, error) {
+	r := router.New()
+
+	r.Default(
+		handlers.SetConfig(db, keys),
+		handlers.SetMailer(outbox, "http://localhost"),
+	)
+
+	r.POST("/password/forgot", handlers.ForgotPassword)
+	r.POST("/password/reset", handlers.ResetPassword)
+	r.POST("/oauth/token", handlers.UserToken)
+
+	return httptest.NewServer(r), nil
+}
+
+func TestResetPassword(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	outbox := &mailer.Outbox{}
+	ts, err := setupServerPassword(db, keys, outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a verified user
+	now := time.Now()
+	u := &data.User{
+		Username:        "foo",
+		Email:           "foo@example.com",
+		EmailVerifiedAt: &now,
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a token for the user
+	tok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "user",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(path string) (int, []byte) {
+		res, err := http.Post(ts.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	// ask for a reset link
+	status, b := post("/password/forgot?login=foo@example.com")
+	if status != http.StatusAccepted {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusAccepted, status, b)
+	}
+	m, ok := outbox.Last()
+	if !ok || m.To != "foo@example.com" {
+		t.Fatalf("Expected a reset email to foo@example.com, Got %+v", m)
+	}
+	i := strings.Index(m.Body, "http://localhost/password/reset?token=")
+	if i < 0 {
+		t.Fatalf("Expected a reset link in %s", m.Body)
+	}
+	link, err := url.Parse(strings.Fields(m.Body[i:])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("token")
+
+	type testCase struct {
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when the user is unknown, the response looks the same
+		{"/password/forgot?login=bar", http.StatusAccepted, `{}`},
+
+		// when the token is unknown
+		{"/password/reset?token=invalid&password=new-password", http.StatusBadRequest, `{"error":"invalid_token","error_description":"reset token is not valid"}`},
+
+		// when the password is missing
+		{"/password/reset?token=" + token, http.StatusBadRequest, `{"error":"password_required","error_description":"password required"}`},
+
+		// when the password is reset
+		{"/password/reset?token=" + token + "&password=new-password", http.StatusOK, `{}`},
+
+		// when the token is used again
+		{"/password/reset?token=" + token + "&password=other-password", http.StatusBadRequest, `{"error":"invalid_token","error_description":"reset token is not valid"}`},
+
+		// when the old password is used
+		{"/oauth/token?grant_type=password&login=foo&password=password", http.StatusBadRequest, `{"error":"invalid_grant","error_description":"failed to authenticate user"}`},
+
+		// when the new password is used
+		{"/oauth/token?grant_type=password&login=foo&password=new-password", http.StatusOK, ""},
+	}
+	for _, tc := range tCases {
+		status, b := post(tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v: %s", tc.path, tc.statusCode, status, b)
+		}
+		if body := string(b); tc.body != "" && body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+
+	// only one email was sent
+	if n := len(outbox.Messages()); n != 1 {
+		t.Errorf("Expected 1 email, Got %d", n)
+	}
+
+	// tokens issued before the reset are revoked
+	if err := tok.Get(db, tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if tok.RevokedAt == nil {
+		t.Error("Expected the token to be revoked")
+	}
+}
+
+func TestForgotPasswordMailFailure(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	r := router.New()
+	r.Default(
+		handlers.SetConfig(db, keyring.NewHMAC([]byte("secret"))),
+		handlers.SetMailer(brokenMailer{}, "http://localhost"),
+	)
+	r.POST("/password/forgot", handlers.ForgotPassword)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// create a user
+	u := &data.User{Username: "foo", Email: "foo@example.com", EncryptedPassword: "x"}
+	if err := u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// known and unknown logins get the same response
+	for _, login := range []string{"foo", "bar"} {
+		res, err := http.Post(ts.URL+"/password/forgot?login="+login, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusAccepted || string(b) != `{}` {
+			t.Errorf("%s - Expected %v {}, Got %v %s", login, http.StatusAccepted, res.StatusCode, b)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+type personalTokenPayload struct {
+	*data.PersonalToken
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// POST /api/v0/user/personal_tokens
+// Params: access_token, name, (scope), (expires_in)
+// scope can't exceed the scope of the token making the request, which is the
+// default. expires_in is in seconds; without it the token never expires.
+// The token is only shown in this response.
+func AddPersonalToken(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	name := r.FormValue("name")
+	if name == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "name required"})
+	}
+	if len(name) > 255 {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "name must be at most 255 characters"})
+	}
+
+	scope := r.FormValue("scope")
+	if scope == "" {
+		scope = tokenScope(c)
+	}
+	if !data.ValidScope(scope) {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_scope", "requested scope is not valid"})
+	}
+	if !data.ScopeWithin(scope, tokenScope(c)) {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_scope", "requested scope exceeds the token scope"})
+	}
+
+	pt := &data.PersonalToken{
+		UserID: c.Meta["user_id"].(int64),
+		Name:   name,
+		Scope:  scope,
+	}
+	if v := r.FormValue("expires_in"); v != "" {
+		secs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || secs <= 0 || secs > math.MaxInt64/int64(time.Second) {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_request", "expires_in must be a positive number of seconds"})
+		}
+		expiresIn := (time.Duration(secs) * time.Second).Nanoseconds()
+		pt.ExpiresIn = &expiresIn
+	}
+
+	token, err := pt.Generate()
+	if err != nil {
+		return err
+	}
+	if err := pt.Insert(db); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	payload := struct {
+		personalTokenPayload
+		Token string `json:"token"`
+	}{
+		personalTokenPayload{pt, pt.ExpiresAt()},
+		token,
+	}
+
+	return res.Created(w, payload)
+}
+
+// GET /api/v0/user/personal_tokens
+// Params: access_token
+// Lists the personal tokens of the current user that are not revoked.
+func ShowPersonalTokens(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	pts := data.PersonalTokens{}
+	if err := pts.SelectByUserID(db, c.Meta["user_id"].(int64)); err != nil {
+		return err
+	}
+
+	payload := []personalTokenPayload{}
+	for i := range pts {
+		payload = append(payload, personalTokenPayload{&pts[i], pts[i].ExpiresAt()})
+	}
+
+	return res.OK(w, payload)
+}
+
+// DELETE /api/v0/user/personal_tokens/:id
+// Params: access_token
+func RevokePersonalToken(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	id, err := strconv.ParseInt(c.Params.ByName("id"), 10, 64)
+	if err != nil {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "id must be a number"})
+	}
+
+	// other users' tokens are reported as not found
+	pt := data.PersonalToken{}
+	if err := pt.Get(db, id); err != nil || pt.UserID != c.Meta["user_id"].(int64) {
+		if _, ok := err.(*data.Error); ok || err == nil {
+			return res.NotFound(w, res.ErrorMsg{"record_not_found", "personal token not found"})
+		}
+		return err
+	}
+
+	if err := pt.Revoke(db); err != nil {
+		return err
+	}
+
+	return res.OK(w, struct{}{})
+}
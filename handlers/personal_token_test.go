@@ -0,0 +1,158 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func setupServerPersonalToken(db *sqlx.DB, keys *keyring.Keyring) (*httptest.Server, error) {
+	r := router.New()
+
+	r.Default(handlers.SetConfig(db, keys))
+
+	r.POST("/api/v0/user/personal_tokens", handlers.Auth("user"), handlers.AddPersonalToken)
+	r.GET("/api/v0/user/personal_tokens", handlers.Auth("user"), handlers.ShowPersonalTokens)
+	r.DELETE("/api/v0/user/personal_tokens/:id", handlers.Auth("user"), handlers.RevokePersonalToken)
+	r.GET("/api/v0/hubs", handlers.Auth("hub"), handlers.ShowHubs)
+
+	return httptest.NewServer(r), nil
+}
+
+func TestPersonalTokens(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerPersonalToken(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a user with a token
+	u := &data.User{Username: "foo", Email: "foo@example.com", EncryptedPassword: "x"}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	tok := data.Token{UserID: u.ID, ExpiresIn: time.Hour.Nanoseconds(), Scope: "user"}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path, bearer string) (int, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	type personalToken struct {
+		ID        int64      `json:"id"`
+		Name      string     `json:"name"`
+		Scope     string     `json:"scope"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		Token     string     `json:"token"`
+	}
+
+	// create a personal token limited to the user scope
+	status, b := do("POST", "/api/v0/user/personal_tokens?name=script&scope=user&expires_in=3600", jwt)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusCreated, status, b)
+	}
+	created := personalToken{}
+	if err := json.Unmarshal(b, &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Token == "" || created.Name != "script" || created.Scope != "user" || created.ExpiresAt == nil {
+		t.Fatalf("Unexpected personal token %s", b)
+	}
+
+	// the personal token authenticates requests, and is listed without its value
+	status, b = do("GET", "/api/v0/user/personal_tokens", created.Token)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	listed := []personalToken{}
+	if err := json.Unmarshal(b, &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ID != created.ID || listed[0].Token != "" {
+		t.Errorf("Unexpected personal tokens %s", b)
+	}
+
+	path := "/api/v0/user/personal_tokens/" + strconv.FormatInt(created.ID, 10)
+
+	type testCase struct {
+		method     string
+		path       string
+		bearer     string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when name is missing
+		{"POST", "/api/v0/user/personal_tokens", jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"name required"}`},
+
+		// when scope is invalid
+		{"POST", "/api/v0/user/personal_tokens?name=script&scope=device", jwt, http.StatusBadRequest, `{"error":"invalid_scope","error_description":"requested scope is not valid"}`},
+
+		// when scope exceeds the scope of the token making the request
+		{"POST", "/api/v0/user/personal_tokens?name=script&scope=user%20hub", jwt, http.StatusBadRequest, `{"error":"invalid_scope","error_description":"requested scope exceeds the token scope"}`},
+
+		// when expires_in is invalid
+		{"POST", "/api/v0/user/personal_tokens?name=script&expires_in=-1", jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"expires_in must be a positive number of seconds"}`},
+
+		// when the personal token lacks the scope
+		{"GET", "/api/v0/hubs", created.Token, http.StatusForbidden, `{"error":"insufficient_scope","error_description":"token is not valid for this scope"}`},
+
+		// when the personal token is unknown
+		{"GET", "/api/v0/user/personal_tokens", data.PersonalTokenPrefix + "unknown", http.StatusUnauthorized, `{"error":"invalid_token","error_description":"token is not valid"}`},
+
+		// when revoking the personal token
+		{"DELETE", path, jwt, http.StatusOK, `{}`},
+
+		// when the personal token was revoked
+		{"GET", "/api/v0/user/personal_tokens", created.Token, http.StatusUnauthorized, `{"error":"invalid_token","error_description":"token is not valid"}`},
+	}
+	for _, tc := range tCases {
+		status, b := do(tc.method, tc.path, tc.bearer)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v: %s", tc.path, tc.statusCode, status, b)
+		}
+		if body := string(b); body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+// GET /api/v0/user
+// Params: access_token
+func ShowUser(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	u := data.User{}
+	if err := u.Get(db, c.Meta["user_id"].(int64)); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	return res.OK(w, u)
+}
+
+// PATCH /api/v0/user
+// Params: access_token, (username), (email), (current_password)
+// Changing the email requires the current password, and the new address
+// must be verified again; verification_sent tells if the email went out.
+func UpdateUser(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	u := data.User{}
+	if err := u.Get(db, c.Meta["user_id"].(int64)); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	invalid := fieldErrors{}
+
+	if username := r.FormValue("username"); username != "" {
+		if err := invalid.validateUsername(db, username, u.ID); err != nil {
+			return err
+		}
+		u.Username = username
+	}
+
+	email := r.FormValue("email")
+	if email != "" {
+		var err error
+		if email, err = invalid.validateEmail(db, email, u.ID); err != nil {
+			return err
+		}
+	}
+
+	if len(invalid) > 0 {
+		return res.Invalid(w, invalid)
+	}
+
+	emailChanged := false
+	if email != "" && !strings.EqualFold(email, u.Email) {
+		if !u.VerifyPassword(r.FormValue("current_password")) {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_password", "current password is not valid"})
+		}
+		u.EmailVerifiedAt = nil
+		emailChanged = true
+	}
+	if email != "" {
+		u.Email = email
+	}
+
+	if err := u.Update(db); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	// the change is saved by now, so a failed email doesn't fail the update;
+	// the client is told to have it sent again (see ResendVerification).
+	payload := struct {
+		data.User
+		VerificationSent *bool `json:"verification_sent,omitempty"`
+	}{User: u}
+	if emailChanged {
+		sent := true
+		if err := sendVerification(c, &u); err != nil {
+			log.Print("[error] Failed to send verification email: ", err)
+			sent = false
+		}
+		payload.VerificationSent = &sent
+	}
+
+	return res.OK(w, payload)
+}
+
+// PATCH /api/v0/user/password
+// Params: access_token, current_password, password
+// Revokes every token of the user, including the one used for this request.
+func ChangePassword(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	password := r.FormValue("password")
+	if password == "" {
+		return res.BadRequest(w, res.ErrorMsg{"password_required", "password required"})
+	}
+
+	u := data.User{}
+	if err := u.Get(db, c.Meta["user_id"].(int64)); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	if !u.VerifyPassword(r.FormValue("current_password")) {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_password", "current password is not valid"})
+	}
+
+	if err := passwordPolicy(c).Check(password, u.Username); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	if err := u.ChangePassword(db, password); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	return res.OK(w, struct{}{})
+}
+
+// DELETE /api/v0/user
+// Params: access_token, current_password
+// Schedules the account to be deleted after grace and revokes every token.
+// Logging in again is allowed, so the deletion can be cancelled meanwhile.
+func DeleteUser(grace time.Duration) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request, c router.Context) error {
+		db, _ := c.Meta["db"].(*sqlx.DB)
+
+		u := data.User{}
+		if err := u.Get(db, c.Meta["user_id"].(int64)); err != nil {
+			if e, ok := err.(*data.Error); ok {
+				return res.NotFound(w, res.ErrorMsg{e.Code, e.Desc})
+			}
+			return err
+		}
+
+		if !u.VerifyPassword(r.FormValue("current_password")) {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_password", "current password is not valid"})
+		}
+
+		if err := u.ScheduleDeletion(db, grace); err != nil {
+			if e, ok := err.(*data.Error); ok {
+				return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+			}
+			return err
+		}
+
+		return res.Respond(w, http.StatusAccepted, u)
+	}
+}
+
+// POST /api/v0/user/restore
+// Params: access_token
+// Cancels a scheduled account deletion.
+func RestoreUser(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	u := data.User{ID: c.Meta["user_id"].(int64)}
+	if err := u.CancelDeletion(db); err != nil {
+		if e, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{e.Code, e.Desc})
+		}
+		return err
+	}
+
+	return res.OK(w, u)
+}
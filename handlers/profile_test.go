@@ -0,0 +1,375 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/mailer"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func setupServerProfile(db *sqlx.DB, keys *keyring.Keyring, outbox *mailer.Outbox) (*httptest.Server, error) {
+	r := router.New()
+
+	r.Default(
+		handlers.SetConfig(db, keys),
+		handlers.SetMailer(outbox, "http://localhost"),
+	)
+
+	r.GET("/api/v0/user", handlers.Auth("user"), handlers.ShowUser)
+	r.PATCH("/api/v0/user", handlers.Auth("user"), handlers.UpdateUser)
+	r.PATCH("/api/v0/user/password", handlers.Auth("user"), handlers.ChangePassword)
+	r.DELETE("/api/v0/user", handlers.Auth("user"), handlers.DeleteUser(time.Hour))
+	r.POST("/api/v0/user/restore", handlers.Auth("user"), handlers.RestoreUser)
+	r.POST("/oauth/token", handlers.UserToken)
+
+	return httptest.NewServer(r), nil
+}
+
+func TestUpdateUser(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	outbox := &mailer.Outbox{}
+	ts, err := setupServerProfile(db, keys, outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create verified users
+	now := time.Now()
+	u := &data.User{
+		Username:        "foo",
+		Email:           "foo@example.com",
+		EmailVerifiedAt: &now,
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	u2 := &data.User{
+		Username:        "bar",
+		Email:           "bar@example.com",
+		EmailVerifiedAt: &now,
+	}
+	if err := u2.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u2.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a token for the user
+	tok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "user",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string) (int, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	// change the email with the current password
+	status, b := do("PATCH", "/api/v0/user?username=foo2&email=foo2@example.com&current_password=password&access_token="+jwt)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	updated := struct {
+		data.User
+		VerificationSent bool `json:"verification_sent"`
+	}{}
+	if err := json.Unmarshal(b, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Username != "foo2" || updated.Email != "foo2@example.com" || updated.EmailVerified() || !updated.VerificationSent {
+		t.Errorf("Unexpected user %s", b)
+	}
+	if m, ok := outbox.Last(); !ok || m.To != "foo2@example.com" {
+		t.Errorf("Expected a verification email to foo2@example.com, Got %+v", m)
+	}
+
+	type testCase struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when reading the user
+		{"GET", "/api/v0/user?access_token=" + jwt, http.StatusOK, ""},
+
+		// when changing the email without the current password
+		{"PATCH", "/api/v0/user?email=foo3@example.com&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_password","error_description":"current password is not valid"}`},
+
+		// when the username is taken, ignoring case
+		{"PATCH", "/api/v0/user?username=BAR&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"request params are not valid","fields":[{"field":"username","error":"unique_violation","error_description":"username exists"}]}`},
+
+		// when the email is taken
+		{"PATCH", "/api/v0/user?email=bar@example.com&current_password=password&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"request params are not valid","fields":[{"field":"email","error":"unique_violation","error_description":"email exists"}]}`},
+
+		// when the username and email are not valid
+		{"PATCH", "/api/v0/user?username=a&email=bar&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"request params are not valid","fields":[{"field":"username","error":"invalid_username","error_description":"username must be 3 to 32 letters, digits, - or _, starting with a letter or digit"},{"field":"email","error":"invalid_email","error_description":"email must be a valid address"}]}`},
+
+		// when the new password is missing
+		{"PATCH", "/api/v0/user/password?current_password=password&access_token=" + jwt, http.StatusBadRequest, `{"error":"password_required","error_description":"password required"}`},
+
+		// when the current password is wrong
+		{"PATCH", "/api/v0/user/password?current_password=wrong&password=new-password&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_password","error_description":"current password is not valid"}`},
+
+		// when the password is changed
+		{"PATCH", "/api/v0/user/password?current_password=password&password=new-password&access_token=" + jwt, http.StatusOK, `{}`},
+
+		// when the token was revoked by the password change
+		{"GET", "/api/v0/user?access_token=" + jwt, http.StatusUnauthorized, `{"error":"invalid_token","error_description":"token is not valid"}`},
+	}
+	for _, tc := range tCases {
+		status, b := do(tc.method, tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v: %s", tc.path, tc.statusCode, status, b)
+		}
+		if body := string(b); tc.body != "" && body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerProfile(db, keys, &mailer.Outbox{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a verified user
+	now := time.Now()
+	u := &data.User{
+		Username:        "foo",
+		Email:           "foo@example.com",
+		EmailVerifiedAt: &now,
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a client for the user
+	cl := &data.Client{
+		Name:   "ci",
+		Scope:  "hub",
+		UserID: u.ID,
+	}
+	secret, err := cl.GenerateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a token for the user
+	tok := data.Token{
+		UserID:    u.ID,
+		ExpiresIn: time.Hour.Nanoseconds(),
+		Scope:     "user",
+	}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string) (int, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	type testCase struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when the current password is wrong
+		{"DELETE", "/api/v0/user?current_password=wrong&access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_password","error_description":"current password is not valid"}`},
+
+		// when the deletion is scheduled
+		{"DELETE", "/api/v0/user?current_password=password&access_token=" + jwt, http.StatusAccepted, ""},
+
+		// when the token was revoked by the deletion
+		{"GET", "/api/v0/user?access_token=" + jwt, http.StatusUnauthorized, `{"error":"invalid_token","error_description":"token is not valid"}`},
+
+		// when a client of the user asks for a token
+		{"POST", "/oauth/token?grant_type=client_credentials&client_id=" + cl.ClientID + "&client_secret=" + secret, http.StatusBadRequest, `{"error":"invalid_grant","error_description":"account is scheduled for deletion"}`},
+	}
+	for _, tc := range tCases {
+		status, b := do(tc.method, tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v: %s", tc.path, tc.statusCode, status, b)
+		}
+		if body := string(b); tc.body != "" && body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+
+	// the user logs in again to cancel the deletion
+	status, b := do("POST", "/oauth/token?grant_type=password&login=foo&password=password")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	payload := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	status, b = do("POST", "/api/v0/user/restore?access_token="+payload.AccessToken)
+	if status != http.StatusOK {
+		t.Errorf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	restored := data.User{}
+	if err := json.Unmarshal(b, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.DeletionScheduled() {
+		t.Errorf("Expected the deletion to be cancelled, Got %s", b)
+	}
+
+	// when the deletion is not scheduled anymore
+	status, b = do("POST", "/api/v0/user/restore?access_token="+payload.AccessToken)
+	if body := string(b); status != http.StatusBadRequest || body != `{"error":"invalid_request","error_description":"user is not scheduled for deletion"}` {
+		t.Errorf("Expected restoring twice to fail, Got %v: %s", status, b)
+	}
+}
+
+func TestUpdateUserMailFailure(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	r := router.New()
+	r.Default(
+		handlers.SetConfig(db, keys),
+		handlers.SetMailer(brokenMailer{}, "http://localhost"),
+	)
+	r.PATCH("/api/v0/user", handlers.Auth("user"), handlers.UpdateUser)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// create a verified user and a token for the user
+	now := time.Now()
+	u := &data.User{Username: "foo", Email: "foo@example.com", EmailVerifiedAt: &now}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	tok := data.Token{UserID: u.ID, ExpiresIn: time.Hour.Nanoseconds(), Scope: "user"}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the update succeeds, telling the email was not sent
+	req, err := http.NewRequest("PATCH", ts.URL+"/api/v0/user?email=foo2@example.com&current_password=password&access_token="+jwt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, res.StatusCode, b)
+	}
+	updated := struct {
+		data.User
+		VerificationSent *bool `json:"verification_sent"`
+	}{}
+	if err := json.Unmarshal(b, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Email != "foo2@example.com" || updated.VerificationSent == nil || *updated.VerificationSent {
+		t.Errorf("Unexpected user %s", b)
+	}
+
+	// the change is saved
+	if err := u.Get(db, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if u.Email != "foo2@example.com" || u.EmailVerified() {
+		t.Errorf("Expected the unverified new email to be saved, Got %+v", u)
+	}
+}
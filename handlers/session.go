@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+type sessionPayload struct {
+	ID         int64      `json:"id"`
+	Scope      string     `json:"scope"`
+	Client     *string    `json:"client"` // name of the client the token was issued to
+	Hub        *string    `json:"hub"`    // slug of the hub the token was issued to
+	CreatedAt  *time.Time `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP *string    `json:"last_used_ip"`
+	Current    bool       `json:"current"` // true for the token used for this request
+}
+
+// Sessions are listed up to maxSessions, newest first.
+const maxSessions = 100
+
+// GET /api/v0/user/tokens
+// Params: access_token
+// Lists the latest 100 tokens of the current user that are neither revoked
+// nor expired. Last use is only saved every few minutes.
+func ShowSessions(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	sessions := data.Sessions{}
+	if err := sessions.SelectByUserID(db, c.Meta["user_id"].(int64), maxSessions); err != nil {
+		return err
+	}
+
+	payload := []sessionPayload{}
+	for _, s := range sessions {
+		payload = append(payload, sessionPayload{
+			s.ID,
+			s.Scope,
+			s.ClientName,
+			s.HubSlug,
+			s.CreatedAt,
+			s.ExpiresAt(),
+			s.LastUsedAt,
+			s.LastUsedIP,
+			s.ID == c.Meta["token_id"],
+		})
+	}
+
+	return res.OK(w, payload)
+}
+
+// DELETE /api/v0/user/tokens/:id
+// Params: access_token
+// Revokes one token of the current user, along with its refresh tokens.
+func RevokeSession(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	id, err := strconv.ParseInt(c.Params.ByName("id"), 10, 64)
+	if err != nil {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "id must be a number"})
+	}
+
+	// other users' tokens are reported as not found
+	t := data.Token{}
+	if err := t.Get(db, id); err != nil || t.UserID != c.Meta["user_id"].(int64) {
+		if _, ok := err.(*data.Error); ok || err == nil {
+			return res.NotFound(w, res.ErrorMsg{"record_not_found", "token not found"})
+		}
+		return err
+	}
+
+	if err := t.Revoke(db); err != nil {
+		return err
+	}
+
+	return res.OK(w, struct{}{})
+}
@@ -0,0 +1,219 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func setupServerSession(db *sqlx.DB, keys *keyring.Keyring) (*httptest.Server, error) {
+	r := router.New()
+
+	// requests from the test server come from loopback
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	r.Default(handlers.SetConfig(db, keys), handlers.SetTrustedProxies([]*net.IPNet{loopback}))
+
+	r.GET("/api/v0/user/tokens", handlers.Auth("user"), handlers.ShowSessions)
+	r.DELETE("/api/v0/user/tokens/:id", handlers.Auth("user"), handlers.RevokeSession)
+
+	return httptest.NewServer(r), nil
+}
+
+func TestSessions(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerSession(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create users
+	u := &data.User{Username: "foo", Email: "foo@example.com", EncryptedPassword: "x"}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	u2 := &data.User{Username: "bar", Email: "bar@example.com", EncryptedPassword: "x"}
+	if err = u2.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// create tokens: the current one, another session, a revoked one and one of another user
+	insertToken := func(userID int64) *data.Token {
+		tok := &data.Token{UserID: userID, ExpiresIn: time.Hour.Nanoseconds(), Scope: "user"}
+		if err := tok.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	current := insertToken(u.ID)
+	other := insertToken(u.ID)
+	revoked := insertToken(u.ID)
+	if err := revoked.Revoke(db); err != nil {
+		t.Fatal(err)
+	}
+	foreign := insertToken(u2.ID)
+
+	jwt, err := current.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string) (int, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	type session struct {
+		ID         int64      `json:"id"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		LastUsedIP *string    `json:"last_used_ip"`
+		Current    bool       `json:"current"`
+	}
+
+	// only live sessions are listed, the current one with its last use
+	status, b := do("GET", "/api/v0/user/tokens?access_token="+jwt)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusOK, status, b)
+	}
+	sessions := []session{}
+	if err := json.Unmarshal(b, &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != other.ID || sessions[1].ID != current.ID {
+		t.Fatalf("Unexpected sessions %s", b)
+	}
+	if !sessions[1].Current || sessions[0].Current {
+		t.Errorf("Expected only token %d to be current, Got %s", current.ID, b)
+	}
+	if sessions[1].LastUsedAt == nil || sessions[1].LastUsedIP == nil || *sessions[1].LastUsedIP != "203.0.113.7" {
+		t.Errorf("Expected the last use of the current token to be recorded, Got %s", b)
+	}
+	if sessions[0].LastUsedAt != nil {
+		t.Errorf("Expected token %d to be unused, Got %s", other.ID, b)
+	}
+
+	type testCase struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when the id is not a number
+		{"DELETE", "/api/v0/user/tokens/abc?access_token=" + jwt, http.StatusBadRequest, `{"error":"invalid_request","error_description":"id must be a number"}`},
+
+		// when the token belongs to another user
+		{"DELETE", "/api/v0/user/tokens/" + strconv.FormatInt(foreign.ID, 10) + "?access_token=" + jwt, http.StatusNotFound, `{"error":"record_not_found","error_description":"token not found"}`},
+
+		// when revoking another session
+		{"DELETE", "/api/v0/user/tokens/" + strconv.FormatInt(other.ID, 10) + "?access_token=" + jwt, http.StatusOK, `{}`},
+
+		// when revoking the current session
+		{"DELETE", "/api/v0/user/tokens/" + strconv.FormatInt(current.ID, 10) + "?access_token=" + jwt, http.StatusOK, `{}`},
+
+		// when the current session was revoked
+		{"GET", "/api/v0/user/tokens?access_token=" + jwt, http.StatusUnauthorized, `{"error":"invalid_token","error_description":"token is not valid"}`},
+	}
+	for _, tc := range tCases {
+		status, b := do(tc.method, tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v: %s", tc.path, tc.statusCode, status, b)
+		}
+		if body := string(b); tc.body != "" && body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+
+	// the other user's token is untouched
+	if err := foreign.Get(db, foreign.ID); err != nil {
+		t.Fatal(err)
+	}
+	if foreign.RevokedAt != nil {
+		t.Error("Expected the token of another user to be kept")
+	}
+}
+
+func TestSessionsUntrustedProxy(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server, trusting no proxy
+	keys := keyring.NewHMAC([]byte("secret"))
+	r := router.New()
+	r.Default(handlers.SetConfig(db, keys))
+	r.GET("/api/v0/user/tokens", handlers.Auth("user"), handlers.ShowSessions)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// create a user with a token
+	u := &data.User{Username: "foo", Email: "foo@example.com", EncryptedPassword: "x"}
+	if err := u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	tok := &data.Token{UserID: u.ID, ExpiresIn: time.Hour.Nanoseconds(), Scope: "user"}
+	if err := tok.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := tok.EncodeJWT(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a forged X-Forwarded-For is ignored
+	req, err := http.NewRequest("GET", ts.URL+"/api/v0/user/tokens?access_token="+jwt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := []struct {
+		LastUsedIP *string `json:"last_used_ip"`
+	}{}
+	if err := json.Unmarshal(b, &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].LastUsedIP == nil || *sessions[0].LastUsedIP != "127.0.0.1" {
+		t.Errorf("Expected the last use from 127.0.0.1, Got %s", b)
+	}
+}
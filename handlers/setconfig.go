@@ -1,20 +1,61 @@
 package handlers
 
 import (
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/mailer"
 	"github.com/ripple-cloud/cloud/router"
 )
 
 // A Middleware handler usually changes the context and pass the request to the next handler.
 // It may decide to respond early if the request can't be fulfiled (eg: authentication failure).
 
-func SetConfig(db *sqlx.DB, tokenSecret []byte) router.Handle {
+func SetConfig(db *sqlx.DB, keys *keyring.Keyring) router.Handle {
 	return func(w http.ResponseWriter, r *http.Request, c router.Context) error {
 		c.Meta["db"] = db
-		c.Meta["tokenSecret"] = tokenSecret
+		c.Meta["keyring"] = keys
 		return c.Next(w, r, c)
 	}
 }
+
+// SetMailer sets the mailer used to email users. Links in emails point at
+// publicURL (eg: https://ripple.io/api).
+func SetMailer(m mailer.Mailer, publicURL string) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request, c router.Context) error {
+		c.Meta["mailer"] = m
+		c.Meta["public_url"] = strings.TrimRight(publicURL, "/")
+		return c.Next(w, r, c)
+	}
+}
+
+// SetPasswordPolicy sets the policy new passwords must follow.
+// Without it, data.DefaultPasswordPolicy applies.
+func SetPasswordPolicy(p *data.PasswordPolicy) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request, c router.Context) error {
+		c.Meta["password_policy"] = p
+		return c.Next(w, r, c)
+	}
+}
+
+// SetTrustedProxies sets the proxies whose X-Forwarded-For header is believed
+// when finding the client IP. Without it, the client IP is the address the
+// request came from.
+func SetTrustedProxies(proxies []*net.IPNet) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request, c router.Context) error {
+		c.Meta["trusted_proxies"] = proxies
+		return c.Next(w, r, c)
+	}
+}
+
+func passwordPolicy(c router.Context) *data.PasswordPolicy {
+	if p, ok := c.Meta["password_policy"].(*data.PasswordPolicy); ok {
+		return p
+	}
+	return data.DefaultPasswordPolicy
+}
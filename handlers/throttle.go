@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+// Failed password grants lock the login tried, and the client IP, for a while.
+// An IP gets more attempts since many users may share it.
+var (
+	loginThrottle = data.ThrottlePolicy{Threshold: 5, Base: time.Second, Max: 15 * time.Minute, Window: time.Hour}
+	ipThrottle    = data.ThrottlePolicy{Threshold: 20, Base: time.Second, Max: 15 * time.Minute, Window: time.Hour}
+)
+
+// throttleKeys returns the login and IP throttle keys of a password grant.
+// Logins are case-insensitive so that "Foo" and "foo" share their failures.
+func throttleKeys(login, ip string) map[string]string {
+	return map[string]string{
+		data.ThrottleLogin: strings.ToLower(login),
+		data.ThrottleIP:    ip,
+	}
+}
+
+// clientIP returns the IP address of the client. X-Forwarded-For is only
+// believed when the request comes from a trusted proxy (see
+// SetTrustedProxies): the client is then the last address in it that is not a
+// trusted proxy, since anything before that can be forged.
+func clientIP(r *http.Request, c router.Context) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	proxies, _ := c.Meta["trusted_proxies"].([]*net.IPNet)
+	if !trustedProxy(proxies, ip) {
+		return ip
+	}
+
+	fwd := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(fwd) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(fwd[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !trustedProxy(proxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func trustedProxy(proxies []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	for _, p := range proxies {
+		if parsed != nil && p.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// respondThrottled responds with 429 if any of the keys is locked.
+// Returns true if it did.
+func respondThrottled(w http.ResponseWriter, db *sqlx.DB, keys map[string]string) (bool, error) {
+	var wait time.Duration
+	for kind, key := range keys {
+		lt := data.LoginThrottle{}
+		if err := lt.Get(db, kind, key); err != nil {
+			if _, ok := err.(*data.Error); ok {
+				continue
+			}
+			return false, err
+		}
+		if d := lt.RetryAfter(); d > wait {
+			wait = d
+		}
+	}
+	if wait == 0 {
+		return false, nil
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return true, res.TooManyRequests(w, res.ErrorMsg{"too_many_attempts", "too many failed attempts, try again later"})
+}
+
+// failLogin records a failed password grant for each of the keys.
+func failLogin(db *sqlx.DB, keys map[string]string) error {
+	policies := map[string]data.ThrottlePolicy{
+		data.ThrottleLogin: loginThrottle,
+		data.ThrottleIP:    ipThrottle,
+	}
+	for kind, key := range keys {
+		lt := data.LoginThrottle{}
+		if err := lt.Fail(db, kind, key, policies[kind]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,37 +2,54 @@ package handlers
 
 import (
 	"errors"
+	"log"
 	"net/http"
-	"time"
 
 	"github.com/jmoiron/sqlx"
 
 	"github.com/ripple-cloud/cloud/data"
 	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/keyring"
 	"github.com/ripple-cloud/cloud/router"
 )
 
 // POST /signup
 // Params: username, email, password
+// verification_sent is false if the verification email could not be sent.
 func Signup(w http.ResponseWriter, r *http.Request, c router.Context) error {
 	db, ok := c.Meta["db"].(*sqlx.DB)
 	if !ok {
 		return errors.New("db not set in context")
 	}
 
+	invalid := fieldErrors{}
+
 	username := r.FormValue("username")
 	if username == "" {
-		return res.BadRequest(w, res.ErrorMsg{"username_required", "username required"})
+		invalid.add("username", &data.Error{"username_required", "username required"})
+	} else if err := invalid.validateUsername(db, username, 0); err != nil {
+		return err
 	}
 
 	email := r.FormValue("email")
 	if email == "" {
-		return res.BadRequest(w, res.ErrorMsg{"email_required", "email required"})
+		invalid.add("email", &data.Error{"email_required", "email required"})
+	} else {
+		var err error
+		if email, err = invalid.validateEmail(db, email, 0); err != nil {
+			return err
+		}
 	}
 
 	password := r.FormValue("password")
 	if password == "" {
-		return res.BadRequest(w, res.ErrorMsg{"password_required", "password required"})
+		invalid.add("password", &data.Error{"password_required", "password required"})
+	} else {
+		invalid.add("password", passwordPolicy(c).Check(password, username))
+	}
+
+	if len(invalid) > 0 {
+		return res.Invalid(w, invalid)
 	}
 
 	u := &data.User{
@@ -49,73 +66,67 @@ func Signup(w http.ResponseWriter, r *http.Request, c router.Context) error {
 		return err
 	}
 
-	return res.Respond(w, http.StatusCreated, u)
+	// the user can't get tokens until the email address is verified.
+	// The user exists by now, so a failed email doesn't fail the signup; the
+	// client is told to have it sent again (see ResendVerification).
+	sent := true
+	if err := sendVerification(c, u); err != nil {
+		log.Print("[error] Failed to send verification email: ", err)
+		sent = false
+	}
+
+	payload := struct {
+		*data.User
+		VerificationSent bool `json:"verification_sent"`
+	}{
+		u,
+		sent,
+	}
+
+	return res.Respond(w, http.StatusCreated, payload)
 }
 
 // POST /oauth/token
-// Params: grant_type, login, password
-// Requires a tokenSecret to be set in context
+// Params: grant_type, (scope) and the params of the grant type (see grant.go)
+// Requires a keyring to be set in context
 func UserToken(w http.ResponseWriter, r *http.Request, c router.Context) error {
 	db, ok := c.Meta["db"].(*sqlx.DB)
 	if !ok {
 		return errors.New("db not set in context")
 	}
-	tokenSecret, ok := c.Meta["tokenSecret"].([]byte)
+	keys, ok := c.Meta["keyring"].(*keyring.Keyring)
 	if !ok {
-		return errors.New("token secret not set in context")
-	}
-
-	if r.FormValue("grant_type") != "password" {
-		return res.BadRequest(w, res.ErrorMsg{"unsupported_grant_type", "supports only password grant type"})
-	}
-
-	login := r.FormValue("login")
-	if login == "" {
-		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "login required"})
-	}
-
-	password := r.FormValue("password")
-	if password == "" {
-		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "password required"})
-	}
-
-	u := data.User{}
-	if err := u.GetByLogin(db, login); err != nil {
-		if e, ok := err.(*data.Error); ok {
-			return res.BadRequest(w, res.ErrorMsg{"invalid_grant", e.Desc})
-		}
-		return err
-	}
-
-	if !u.VerifyPassword(password) {
-		return res.BadRequest(w, res.ErrorMsg{"invalid_grant", "failed to authenticate user"})
+		return errors.New("keyring not set in context")
+	}
+
+	switch r.FormValue("grant_type") {
+	case "password":
+		return passwordGrant(w, r, db, keys, clientIP(r, c))
+	case "refresh_token":
+		return refreshTokenGrant(w, r, db, keys)
+	case "client_credentials":
+		return clientCredentialsGrant(w, r, db, keys)
+	case "authorization_code":
+		return authorizationCodeGrant(w, r, db, keys)
+	case "hub_credentials":
+		return hubCredentialsGrant(w, r, db, keys)
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		return deviceCodeGrant(w, r, db, keys)
+	default:
+		return res.BadRequest(w, res.ErrorMsg{"unsupported_grant_type", "grant type is not supported"})
 	}
+}
 
-	// Since all is well, generate token and add to database
-	t := data.Token{
-		UserID:    u.ID,
-		ExpiresIn: (30 * 24 * time.Hour).Nanoseconds(), // 30 days
-	}
-	if err := t.Insert(db); err != nil {
-		return err
-	}
+// DELETE /api/v0/user/tokens
+// Params: access_token
+// Revokes every token of the current user, including the one used for this request.
+func RevokeTokens(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
 
-	// get the encoded JSON Web token
-	jwt, err := t.EncodeJWT(tokenSecret)
-	if err != nil {
+	u := data.User{ID: c.Meta["user_id"].(int64)}
+	if err := u.RevokeTokens(db); err != nil {
 		return err
 	}
 
-	// prepare oAuth2 access token payload
-	payload := struct {
-		AccessToken string `json:"access_token"`
-		TokenType   string `json:"token_type"`
-		ExpiresIn   string `json:"expires_in"`
-	}{
-		jwt,
-		"bearer",
-		time.Duration(t.ExpiresIn).String(),
-	}
-
-	return res.OK(w, payload)
+	return res.OK(w, struct{}{})
 }
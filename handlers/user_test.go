@@ -2,6 +2,7 @@ package handlers_test
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -11,15 +12,18 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/ripple-cloud/cloud/data"
 	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/mailer"
 	"github.com/ripple-cloud/cloud/router"
 	"github.com/ripple-cloud/cloud/testhelpers"
 )
 
-func setupServerUser(db *sqlx.DB, tokenSecret []byte) (*httptest.Server, error) {
+func setupServerUser(db *sqlx.DB, keys *keyring.Keyring) (*httptest.Server, error) {
 	r := router.New()
 
 	r.Default(
-		handlers.SetConfig(db, []byte(tokenSecret)),
+		handlers.SetConfig(db, keys),
+		handlers.SetMailer(&mailer.Outbox{}, "http://localhost"),
 	)
 
 	r.POST("/signup", handlers.Signup)
@@ -34,7 +38,8 @@ func TestSignup(t *testing.T) {
 	defer db.Close()
 
 	// setup server
-	ts, err := setupServerUser(db, []byte("secret"))
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerUser(db, keys)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -68,13 +73,25 @@ func TestSignup(t *testing.T) {
 	//test when invalid params are provided
 	tCases := []testCase{
 		// when username param is missing
-		{"?email=foo@example.com&password=password", http.StatusBadRequest, `{"error":"username_required","error_description":"username required"}`},
+		{"?email=foo2@example.com&password=password", http.StatusBadRequest, `{"error":"invalid_request","error_description":"request params are not valid","fields":[{"field":"username","error":"username_required","error_description":"username required"}]}`},
 
 		// when email param is missing
-		{"?username=foo&password=password", http.StatusBadRequest, `{"error":"email_required","error_description":"email required"}`},
+		{"?username=foo2&password=password", http.StatusBadRequest, `{"error":"invalid_request","error_description":"request params are not valid","fields":[{"field":"email","error":"email_required","error_description":"email required"}]}`},
 
 		// when password param is missing
-		{"?username=foo&email=foo@example.com", http.StatusBadRequest, `{"error":"password_required","error_description":"password required"}`},
+		{"?username=foo2&email=foo2@example.com", http.StatusBadRequest, `{"error":"invalid_request","error_description":"request params are not valid","fields":[{"field":"password","error":"password_required","error_description":"password required"}]}`},
+
+		// when password is too short
+		{"?username=foo2&email=foo2@example.com&password=secret", http.StatusBadRequest, `{"error":"invalid_request","error_description":"request params are not valid","fields":[{"field":"password","error":"weak_password","error_description":"password must be at least 8 characters"}]}`},
+
+		// when password contains the username
+		{"?username=foo2&email=foo2@example.com&password=foo2-password", http.StatusBadRequest, `{"error":"invalid_request","error_description":"request params are not valid","fields":[{"field":"password","error":"weak_password","error_description":"password must not contain the username"}]}`},
+
+		// when every param is invalid
+		{"?username=foo@bar&email=foo2&password=pw", http.StatusBadRequest, `{"error":"invalid_request","error_description":"request params are not valid","fields":[{"field":"username","error":"invalid_username","error_description":"username must be 3 to 32 letters, digits, - or _, starting with a letter or digit"},{"field":"email","error":"invalid_email","error_description":"email must be a valid address"},{"field":"password","error":"weak_password","error_description":"password must be at least 8 characters"}]}`},
+
+		// when username and email are taken, ignoring case
+		{"?username=FOO&email=Foo@Example.com&password=password", http.StatusBadRequest, `{"error":"invalid_request","error_description":"request params are not valid","fields":[{"field":"username","error":"unique_violation","error_description":"username exists"},{"field":"email","error":"unique_violation","error_description":"email exists"}]}`},
 	}
 
 	for _, tc := range tCases {
@@ -97,13 +114,61 @@ func TestSignup(t *testing.T) {
 	}
 }
 
+// brokenMailer fails to send every message.
+type brokenMailer struct{}
+
+func (brokenMailer) Send(m mailer.Message) error {
+	return errors.New("mail server unreachable")
+}
+
+func TestSignupMailFailure(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server, unable to send emails
+	r := router.New()
+	r.Default(
+		handlers.SetConfig(db, keyring.NewHMAC([]byte("secret"))),
+		handlers.SetMailer(brokenMailer{}, "http://localhost"),
+	)
+	r.POST("/signup", handlers.Signup)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// the signup succeeds, telling the email was not sent
+	res, err := http.Post(ts.URL+"/signup?username=foo&email=foo@example.com&password=password", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusCreated, res.StatusCode, b)
+	}
+	payload := struct {
+		Username         string `json:"username"`
+		VerificationSent *bool  `json:"verification_sent"`
+	}{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Username != "foo" || payload.VerificationSent == nil || *payload.VerificationSent {
+		t.Errorf("Expected the verification email not to be sent, Got %s", b)
+	}
+}
+
 func TestUserToken(t *testing.T) {
 	// setup DB
 	db := testhelpers.SetupDB(t)
 	defer db.Close()
 
 	// setup server
-	ts, err := setupServerUser(db, []byte("secret"))
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerUser(db, keys)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -130,7 +195,7 @@ func TestUserToken(t *testing.T) {
 	}
 
 	//	// get the encoded JSON Web Token
-	//	jwt, err := tok.EncodeJWT([]byte("secret"))
+	//	jwt, err := tok.EncodeJWT(keys)
 	//	if err != nil {
 	//		t.Fatal(err)
 	//	}
@@ -144,10 +209,10 @@ func TestUserToken(t *testing.T) {
 	tCases := []testCase{
 		// when valid params are provided
 		// FIXME: find out why signature in jwt is different from response
-		//		{"?grant_type=password&login=foo&password=password", http.StatusOK, `{"access_token":` + jwt + `","token_type":"bearer","expires_in":"720h0m0s"}`},
+		//		{"?grant_type=password&login=foo&password=password", http.StatusOK, `{"access_token":` + jwt + `","token_type":"bearer","expires_in":3600}`},
 
 		// when grant_type param is invalid/missing
-		{"?login=foo&password=password", http.StatusBadRequest, `{"error":"unsupported_grant_type","error_description":"supports only password grant type"}`},
+		{"?login=foo&password=password", http.StatusBadRequest, `{"error":"unsupported_grant_type","error_description":"grant type is not supported"}`},
 
 		// when login param is missing
 		{"?grant_type=password&password=password", http.StatusBadRequest, `{"error":"invalid_request","error_description":"login required"}`},
@@ -155,6 +220,9 @@ func TestUserToken(t *testing.T) {
 		// when password param is missing
 		{"?grant_type=password&login=foo", http.StatusBadRequest, `{"error":"invalid_request","error_description":"password required"}`},
 
+		// when scope param is invalid
+		{"?grant_type=password&login=foo&password=password&scope=admin", http.StatusBadRequest, `{"error":"invalid_scope","error_description":"requested scope is not valid"}`},
+
 		// when password value is incorrect
 		{"?grant_type=password&login=foo&password=abcd", http.StatusBadRequest, `{"error":"invalid_grant","error_description":"failed to authenticate user"}`},
 
@@ -181,3 +249,186 @@ func TestUserToken(t *testing.T) {
 		}
 	}
 }
+
+func TestRefreshTokenGrant(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerUser(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a verified user
+	now := time.Now()
+	u := &data.User{
+		Username:        "foo",
+		Email:           "foo@example.com",
+		EmailVerifiedAt: &now,
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	type tokenPayload struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	postToken := func(path string) (int, []byte) {
+		res, err := http.Post(ts.URL+"/oauth/token"+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	// get the initial tokens with the password grant
+	status, b := postToken("?grant_type=password&login=foo&password=password&scope=hub+app")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v", http.StatusOK, status)
+	}
+	first := tokenPayload{}
+	if err := json.Unmarshal(b, &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.RefreshToken == "" {
+		t.Fatal("Expected a refresh token to be issued")
+	}
+
+	// exchange the refresh token for a narrower scope
+	status, b = postToken("?grant_type=refresh_token&scope=hub&refresh_token=" + first.RefreshToken)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %v, Got %v", http.StatusOK, status)
+	}
+	second := tokenPayload{}
+	if err := json.Unmarshal(b, &second); err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Error("Expected the refresh token to be rotated")
+	}
+	if second.Scope != "hub" {
+		t.Errorf("Expected scope to be hub, Got %v", second.Scope)
+	}
+
+	type testCase struct {
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when refresh_token param is missing
+		{"?grant_type=refresh_token", http.StatusBadRequest, `{"error":"invalid_request","error_description":"refresh_token required"}`},
+
+		// when refresh token is unknown
+		{"?grant_type=refresh_token&refresh_token=invalid", http.StatusBadRequest, `{"error":"invalid_grant","error_description":"refresh token is not valid"}`},
+
+		// when requested scope exceeds the original grant
+		{"?grant_type=refresh_token&scope=user&refresh_token=" + second.RefreshToken, http.StatusBadRequest, `{"error":"invalid_scope","error_description":"requested scope exceeds the granted scope"}`},
+
+		// when a rotated refresh token is reused
+		{"?grant_type=refresh_token&refresh_token=" + first.RefreshToken, http.StatusBadRequest, `{"error":"invalid_grant","error_description":"refresh token is not valid"}`},
+
+		// when the family was revoked due to reuse
+		{"?grant_type=refresh_token&refresh_token=" + second.RefreshToken, http.StatusBadRequest, `{"error":"invalid_grant","error_description":"refresh token is not valid"}`},
+	}
+
+	for _, tc := range tCases {
+		status, b := postToken(tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v", tc.path, tc.statusCode, status)
+		}
+		if body := string(b); body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+}
+
+func TestUserTokenThrottle(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	ts, err := setupServerUser(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// create a verified user
+	now := time.Now()
+	u := &data.User{
+		Username:        "foo",
+		Email:           "foo@example.com",
+		EmailVerifiedAt: &now,
+	}
+	if err := u.EncryptPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	postToken := func(path string) (*http.Response, string) {
+		res, err := http.Post(ts.URL+"/oauth/token"+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(b)
+	}
+
+	// failures under the threshold are reported as usual
+	for i := 0; i < 4; i++ {
+		res, _ := postToken("?grant_type=password&login=foo&password=wrong")
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status code %v, Got %v", http.StatusBadRequest, res.StatusCode)
+		}
+	}
+
+	// logins are throttled case-insensitively
+	res, _ := postToken("?grant_type=password&login=FOO&password=wrong")
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code %v, Got %v", http.StatusBadRequest, res.StatusCode)
+	}
+
+	// the login is now locked, even with the right password
+	res, body := postToken("?grant_type=password&login=foo&password=password")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status code %v, Got %v", http.StatusTooManyRequests, res.StatusCode)
+	}
+	if want := `{"error":"too_many_attempts","error_description":"too many failed attempts, try again later"}`; body != want {
+		t.Errorf("Expected response body to be %v, Got %v", want, body)
+	}
+	if res.Header.Get("Retry-After") != "1" {
+		t.Errorf("Expected Retry-After to be 1, Got %v", res.Header.Get("Retry-After"))
+	}
+
+	// the lockout expires
+	time.Sleep(1100 * time.Millisecond)
+	res, _ = postToken("?grant_type=password&login=foo&password=password")
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %v, Got %v", http.StatusOK, res.StatusCode)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+)
+
+// fieldErrors collects the invalid params of a request, so they can all be
+// reported at once (see jsonrespond.Invalid).
+type fieldErrors []res.FieldError
+
+func (fe *fieldErrors) add(field string, err error) {
+	if e, ok := err.(*data.Error); ok {
+		*fe = append(*fe, res.FieldError{field, e.Code, e.Desc})
+	}
+}
+
+// addTaken adds an error for the field if another user than the one with
+// exceptID already has its value as username or email.
+func (fe *fieldErrors) addTaken(db *sqlx.DB, field, value string, exceptID int64) error {
+	taken, err := data.LoginTaken(db, value, exceptID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		fe.add(field, &data.Error{"unique_violation", field + " exists"})
+	}
+	return nil
+}
+
+// validateUsername adds an error for the username if it's not valid or taken.
+func (fe *fieldErrors) validateUsername(db *sqlx.DB, username string, exceptID int64) error {
+	if err := data.ValidateUsername(username); err != nil {
+		fe.add("username", err)
+		return nil
+	}
+	return fe.addTaken(db, "username", username, exceptID)
+}
+
+// validateEmail returns the normalized email, or adds an error for it if
+// it's not valid or taken.
+func (fe *fieldErrors) validateEmail(db *sqlx.DB, email string, exceptID int64) (string, error) {
+	email, err := data.NormalizeEmail(email)
+	if err != nil {
+		fe.add("email", err)
+		return "", nil
+	}
+	return email, fe.addTaken(db, "email", email, exceptID)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ripple-cloud/cloud/data"
+	res "github.com/ripple-cloud/cloud/jsonrespond"
+	"github.com/ripple-cloud/cloud/mailer"
+	"github.com/ripple-cloud/cloud/router"
+)
+
+const emailVerificationExpiry = 24 * time.Hour
+
+// sendVerification mails the user a link to verify their email address.
+func sendVerification(c router.Context, u *data.User) error {
+	db, ok := c.Meta["db"].(*sqlx.DB)
+	if !ok {
+		return errors.New("db not set in context")
+	}
+	m, ok := c.Meta["mailer"].(mailer.Mailer)
+	if !ok {
+		return errors.New("mailer not set in context")
+	}
+	publicURL, _ := c.Meta["public_url"].(string)
+
+	ev := data.EmailVerification{
+		UserID:    u.ID,
+		Email:     u.Email,
+		ExpiresIn: emailVerificationExpiry.Nanoseconds(),
+	}
+	token, err := ev.Generate()
+	if err != nil {
+		return err
+	}
+	if err := ev.Insert(db); err != nil {
+		return err
+	}
+
+	return m.Send(mailer.Message{
+		To:      u.Email,
+		Subject: "Verify your Ripple email address",
+		Body: "Hi " + u.Username + ",\n\n" +
+			"Please verify your email address by opening the link below within 24 hours:\n\n" +
+			publicURL + "/signup/verify?token=" + url.QueryEscape(token) + "\n",
+	})
+}
+
+// GET|POST /signup/verify
+// Params: token
+// Marks the email address the token was sent to as verified.
+func VerifyEmail(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	token := r.FormValue("token")
+	if token == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "token required"})
+	}
+
+	ev := data.EmailVerification{}
+	if err := ev.GetByToken(db, token); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_token", "verification token is not valid"})
+		}
+		return err
+	}
+	if ev.Expired() {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_token", "verification token is expired"})
+	}
+
+	u := data.User{}
+	if err := ev.Verify(db, &u); err != nil {
+		if _, ok := err.(*data.Error); ok {
+			return res.BadRequest(w, res.ErrorMsg{"invalid_token", "verification token is not valid"})
+		}
+		return err
+	}
+
+	return res.OK(w, u)
+}
+
+// POST /signup/verify/resend
+// Params: login
+// Always responds 202, so it can't be used to find out who signed up.
+func ResendVerification(w http.ResponseWriter, r *http.Request, c router.Context) error {
+	db, _ := c.Meta["db"].(*sqlx.DB)
+
+	login := r.FormValue("login")
+	if login == "" {
+		return res.BadRequest(w, res.ErrorMsg{"invalid_request", "login required"})
+	}
+
+	u := data.User{}
+	err := u.GetByLogin(db, login)
+	if _, ok := err.(*data.Error); err != nil && !ok {
+		return err
+	}
+	if err == nil && !u.EmailVerified() {
+		// failing here would tell that the login exists
+		if err := sendVerification(c, &u); err != nil {
+			log.Print("[error] Failed to send verification email: ", err)
+		}
+	}
+
+	return res.Respond(w, http.StatusAccepted, struct{}{})
+}
@@ -0,0 +1,191 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ripple-cloud/cloud/data"
+	"github.com/ripple-cloud/cloud/handlers"
+	"github.com/ripple-cloud/cloud/keyring"
+	"github.com/ripple-cloud/cloud/mailer"
+	"github.com/ripple-cloud/cloud/router"
+	"github.com/ripple-cloud/cloud/testhelpers"
+)
+
+func setupServerVerify(db *sqlx.DB, keys *keyring.Keyring, outbox *mailer.Outbox) (*httptest.Server, error) {
+	r := router.New()
+
+	r.Default(
+		handlers.SetConfig(db, keys),
+		handlers.SetMailer(outbox, "http://localhost"),
+	)
+
+	r.POST("/signup", handlers.Signup)
+	r.GET("/signup/verify", handlers.VerifyEmail)
+	r.POST("/signup/verify", handlers.VerifyEmail)
+	r.POST("/signup/verify/resend", handlers.ResendVerification)
+	r.POST("/oauth/token", handlers.UserToken)
+
+	return httptest.NewServer(r), nil
+}
+
+func TestVerifyEmail(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	keys := keyring.NewHMAC([]byte("secret"))
+	outbox := &mailer.Outbox{}
+	ts, err := setupServerVerify(db, keys, outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	do := func(method, path string) (int, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, b
+	}
+
+	// extracts the verification token from the last email
+	lastToken := func() string {
+		m, ok := outbox.Last()
+		if !ok {
+			t.Fatal("Expected a verification email")
+		}
+		if m.To != "foo@example.com" {
+			t.Errorf("Expected the email to be sent to foo@example.com, Got %s", m.To)
+		}
+		i := strings.Index(m.Body, "http://localhost/signup/verify?token=")
+		if i < 0 {
+			t.Fatalf("Expected a verification link in %s", m.Body)
+		}
+		link := strings.Fields(m.Body[i:])[0]
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Query().Get("token")
+	}
+
+	// sign up sends a verification email
+	status, b := do("POST", "/signup?username=foo&email=foo@example.com&password=password")
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status code %v, Got %v: %s", http.StatusCreated, status, b)
+	}
+	first := lastToken()
+
+	// resending sends another verification email
+	status, b = do("POST", "/signup/verify/resend?login=foo")
+	if status != http.StatusAccepted {
+		t.Errorf("Expected status code %v, Got %v: %s", http.StatusAccepted, status, b)
+	}
+	if n := len(outbox.Messages()); n != 2 {
+		t.Errorf("Expected 2 emails, Got %d", n)
+	}
+	second := lastToken()
+
+	type testCase struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}
+
+	tCases := []testCase{
+		// when the email is not verified yet
+		{"POST", "/oauth/token?grant_type=password&login=foo&password=password", http.StatusBadRequest, `{"error":"email_not_verified","error_description":"email address is not verified"}`},
+
+		// when the user is unknown, resending looks the same
+		{"POST", "/signup/verify/resend?login=bar", http.StatusAccepted, `{}`},
+
+		// when the token is missing
+		{"GET", "/signup/verify", http.StatusBadRequest, `{"error":"invalid_request","error_description":"token required"}`},
+
+		// when the token is unknown
+		{"GET", "/signup/verify?token=invalid", http.StatusBadRequest, `{"error":"invalid_token","error_description":"verification token is not valid"}`},
+
+		// when the link is opened
+		{"GET", "/signup/verify?token=" + first, http.StatusOK, ""},
+
+		// when the link is opened again
+		{"GET", "/signup/verify?token=" + first, http.StatusBadRequest, `{"error":"invalid_token","error_description":"verification token is not valid"}`},
+
+		// when another token is used once verified
+		{"POST", "/signup/verify?token=" + second, http.StatusOK, ""},
+
+		// when the email is verified
+		{"POST", "/oauth/token?grant_type=password&login=foo&password=password", http.StatusOK, ""},
+	}
+	for _, tc := range tCases {
+		status, b := do(tc.method, tc.path)
+		if status != tc.statusCode {
+			t.Errorf("%s - Expected status code %v, Got %v: %s", tc.path, tc.statusCode, status, b)
+		}
+		if body := string(b); tc.body != "" && body != tc.body {
+			t.Errorf("%s - Expected response body to be %v, Got %v", tc.path, tc.body, body)
+		}
+	}
+
+	// once verified, no more verification emails are sent
+	do("POST", "/signup/verify/resend?login=foo")
+	if n := len(outbox.Messages()); n != 2 {
+		t.Errorf("Expected 2 emails, Got %d", n)
+	}
+}
+
+func TestResendVerificationMailFailure(t *testing.T) {
+	// setup DB
+	db := testhelpers.SetupDB(t)
+	defer db.Close()
+
+	// setup server
+	r := router.New()
+	r.Default(
+		handlers.SetConfig(db, keyring.NewHMAC([]byte("secret"))),
+		handlers.SetMailer(brokenMailer{}, "http://localhost"),
+	)
+	r.POST("/signup/verify/resend", handlers.ResendVerification)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// create an unverified user
+	u := &data.User{Username: "foo", Email: "foo@example.com", EncryptedPassword: "x"}
+	if err := u.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// known and unknown logins get the same response
+	for _, login := range []string{"foo", "bar"} {
+		res, err := http.Post(ts.URL+"/signup/verify/resend?login="+login, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusAccepted || string(b) != `{}` {
+			t.Errorf("%s - Expected %v {}, Got %v %s", login, http.StatusAccepted, res.StatusCode, b)
+		}
+	}
+}
@@ -10,6 +10,19 @@ type ErrorMsg struct {
 	ErrorDesc string `json:"error_description"`
 }
 
+// FieldError tells why a request param is not valid.
+type FieldError struct {
+	Field     string `json:"field"`
+	Error     string `json:"error"`
+	ErrorDesc string `json:"error_description"`
+}
+
+// ValidationErrorMsg lists every invalid param of a request.
+type ValidationErrorMsg struct {
+	ErrorMsg
+	Fields []FieldError `json:"fields"`
+}
+
 func Respond(w http.ResponseWriter, code int, payload interface{}) error {
 	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
 	w.WriteHeader(code)
@@ -49,3 +62,12 @@ func Forbidden(w http.ResponseWriter, err ErrorMsg) error {
 func ServerError(w http.ResponseWriter, err ErrorMsg) error {
 	return Respond(w, http.StatusInternalServerError, err)
 }
+
+func TooManyRequests(w http.ResponseWriter, err ErrorMsg) error {
+	return Respond(w, http.StatusTooManyRequests, err)
+}
+
+// Invalid responds with a 400 listing the invalid params.
+func Invalid(w http.ResponseWriter, fields []FieldError) error {
+	return Respond(w, http.StatusBadRequest, ValidationErrorMsg{ErrorMsg{"invalid_request", "request params are not valid"}, fields})
+}
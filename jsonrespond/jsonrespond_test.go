@@ -79,3 +79,35 @@ func TestRespondWithNilPointer(t *testing.T) {
 		t.Errorf("expected null response, but got %s", output)
 	}
 }
+
+func TestInvalid(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		jsonrespond.Invalid(w, []jsonrespond.FieldError{
+			{"username", "username_required", "username required"},
+			{"email", "invalid_email", "email must be a valid address"},
+		})
+	}
+	ts := setupServer(h)
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// check the status
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status code %v, received %v", http.StatusBadRequest, res.StatusCode)
+	}
+
+	// check the response
+	output, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	expected := `{"error":"invalid_request","error_description":"request params are not valid","fields":[` +
+		`{"field":"username","error":"username_required","error_description":"username required"},` +
+		`{"field":"email","error":"invalid_email","error_description":"email must be a valid address"}]}`
+	if string(output) != expected {
+		t.Errorf("expected %s, but got %s", expected, output)
+	}
+}
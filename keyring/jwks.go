@@ -0,0 +1,47 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set.
+func (k *Keyring) JWKS() map[string][]JWK {
+	jwks := []JWK{}
+	for _, key := range k.Keys() {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch p := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = jwt.EncodeSegment(p.N.Bytes())
+			jwk.E = jwt.EncodeSegment(big.NewInt(int64(p.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = jwt.EncodeSegment(p)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return map[string][]JWK{"keys": jwks}
+}
@@ -0,0 +1,174 @@
+// Package keyring holds the keys used to sign and verify access tokens.
+//
+// Keys are loaded from a directory of PEM files named after their key ID
+// (eg: 2015-10-01.pem). A private key can sign and verify; a public key only
+// verifies, which lets a retired key keep validating the tokens it signed
+// until they expire. Every issued token carries the `kid` of its signing key.
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	// Private is nil for keys that can only verify.
+	Private interface{}
+	Public  interface{}
+}
+
+type Keyring struct {
+	signing *Key
+	keys    map[string]*Key
+
+	// secret verifies tokens issued before key IDs were introduced.
+	secret []byte
+}
+
+// NewHMAC returns a keyring signing and verifying with a shared secret (HS256).
+func NewHMAC(secret []byte) *Keyring {
+	return &Keyring{keys: map[string]*Key{}, secret: secret}
+}
+
+// Load reads every *.pem file in dir. The signing key is the private key with
+// the given ID, or the last one in ID order if signingID is empty.
+// A non-empty legacy secret keeps verifying HS256 tokens without a `kid`.
+func Load(dir, signingID string, legacySecret []byte) (*Keyring, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{keys: map[string]*Key{}, secret: legacySecret}
+	ids := []string{}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(filepath.Base(f), ".pem")
+		key, err := parseKey(id, b)
+		if err != nil {
+			return nil, fmt.Errorf("keyring: %s: %v", f, err)
+		}
+		k.keys[id] = key
+		if key.Private != nil {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("keyring: no private key found in %s", dir)
+	}
+	if signingID == "" {
+		sort.Strings(ids)
+		signingID = ids[len(ids)-1]
+	}
+	signing, ok := k.keys[signingID]
+	if !ok || signing.Private == nil {
+		return nil, fmt.Errorf("keyring: no private key with id %q", signingID)
+	}
+	k.signing = signing
+
+	return k, nil
+}
+
+func parseKey(id string, b []byte) (*Key, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("not PEM encoded")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch p := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{id, jwt.SigningMethodRS256, p, &p.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{id, jwt.SigningMethodRS256, nil, p}, nil
+	case ed25519.PrivateKey:
+		return &Key{id, SigningMethodEd25519, p, p.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{id, SigningMethodEd25519, nil, p}, nil
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+}
+
+// Sign signs the claims with the signing key, setting the `kid` header.
+func (k *Keyring) Sign(claims map[string]interface{}) (string, error) {
+	if k.signing == nil {
+		j := jwt.New(jwt.SigningMethodHS256)
+		j.Claims = claims
+		return j.SignedString(k.secret)
+	}
+
+	j := jwt.New(k.signing.Method)
+	j.Header["kid"] = k.signing.ID
+	j.Claims = claims
+	return j.SignedString(k.signing.Private)
+}
+
+// Keyfunc picks the verification key for a token by its `kid` header.
+// It is meant to be passed to jwt.Parse.
+func (k *Keyring) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		if len(k.secret) == 0 || t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, errors.New("token key is unknown")
+		}
+		return k.secret, nil
+	}
+
+	key, ok := k.keys[kid]
+	// the algorithm must match the key, or a public key could be used as
+	// an HMAC secret to forge tokens
+	if !ok || t.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("token key is unknown")
+	}
+	return key.Public, nil
+}
+
+// Keys returns the keys used for verification, ordered by ID.
+// Shared secrets are never included.
+func (k *Keyring) Keys() []*Key {
+	keys := []*Key{}
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	sort.Sort(byID(keys))
+	return keys
+}
+
+type byID []*Key
+
+func (s byID) Len() int           { return len(s) }
+func (s byID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byID) Less(i, j int) bool { return s[i].ID < s[j].ID }
@@ -0,0 +1,178 @@
+package keyring_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ripple-cloud/cloud/keyring"
+)
+
+func writeKey(t *testing.T, dir, id, blockType string, der []byte) {
+	b := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, id+".pem"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupKeyDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "keyring-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a retired RSA key, only its public part is kept
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "2015-01", "PUBLIC KEY", der)
+
+	// the current RSA key
+	rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "2015-06", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	// the next key, an Ed25519 one
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "2015-12", "PRIVATE KEY", der)
+
+	return dir
+}
+
+func TestLoadAndSign(t *testing.T) {
+	dir := setupKeyDir(t)
+	defer os.RemoveAll(dir)
+
+	type testCase struct {
+		signingID string
+		alg       string
+	}
+
+	tCases := []testCase{
+		// defaults to the last private key
+		{"", "EdDSA"},
+		{"2015-12", "EdDSA"},
+		{"2015-06", "RS256"},
+	}
+	for _, tc := range tCases {
+		k, err := keyring.Load(dir, tc.signingID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := k.Sign(map[string]interface{}{"jti": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		j, err := jwt.Parse(s, k.Keyfunc)
+		if err != nil {
+			t.Fatalf("%q - Expected signed token to verify, Got %v", tc.signingID, err)
+		}
+		if j.Method.Alg() != tc.alg {
+			t.Errorf("%q - Expected alg %v, Got %v", tc.signingID, tc.alg, j.Method.Alg())
+		}
+		if kid, _ := j.Header["kid"].(string); tc.signingID != "" && kid != tc.signingID {
+			t.Errorf("%q - Expected kid %v, Got %v", tc.signingID, tc.signingID, kid)
+		}
+	}
+
+	// a public key can't be chosen for signing
+	if _, err := keyring.Load(dir, "2015-01", nil); err == nil {
+		t.Error("Load must fail when the signing key has no private part")
+	}
+}
+
+func TestKeyfunc(t *testing.T) {
+	dir := setupKeyDir(t)
+	defer os.RemoveAll(dir)
+
+	k, err := keyring.Load(dir, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tokens issued before key IDs keep verifying with the legacy secret
+	legacy, err := keyring.NewHMAC([]byte("secret")).Sign(map[string]interface{}{"jti": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(legacy, k.Keyfunc); err != nil {
+		t.Errorf("Expected legacy token to verify, Got %v", err)
+	}
+
+	// an HMAC token claiming a public key's kid must not verify
+	j := jwt.New(jwt.SigningMethodHS256)
+	j.Header["kid"] = "2015-06"
+	forged, err := j.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(forged, k.Keyfunc); err == nil {
+		t.Error("Expected token with mismatched alg to be rejected")
+	}
+
+	// a token with an unknown kid must not verify
+	j = jwt.New(jwt.SigningMethodHS256)
+	j.Header["kid"] = "unknown"
+	unknown, err := j.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(unknown, k.Keyfunc); err == nil {
+		t.Error("Expected token with unknown kid to be rejected")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := setupKeyDir(t)
+	defer os.RemoveAll(dir)
+
+	k, err := keyring.Load(dir, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := k.JWKS()["keys"]
+	if len(keys) != 3 {
+		t.Fatalf("Expected 3 public keys, Got %v", len(keys))
+	}
+
+	expected := []keyring.JWK{
+		{Kty: "RSA", Kid: "2015-01", Alg: "RS256"},
+		{Kty: "RSA", Kid: "2015-06", Alg: "RS256"},
+		{Kty: "OKP", Kid: "2015-12", Alg: "EdDSA", Crv: "Ed25519"},
+	}
+	for i, e := range expected {
+		got := keys[i]
+		if got.Kty != e.Kty || got.Kid != e.Kid || got.Alg != e.Alg || got.Crv != e.Crv || got.Use != "sig" {
+			t.Errorf("Expected key %+v, Got %+v", e, got)
+		}
+	}
+
+	// shared secrets are never published
+	if len(keyring.NewHMAC([]byte("secret")).JWKS()["keys"]) != 0 {
+		t.Error("Expected no keys to be published for a shared secret")
+	}
+}
@@ -0,0 +1,30 @@
+// Package mailer sends the emails of the service (eg: address verification).
+package mailer
+
+import (
+	"errors"
+	"strings"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// A Mailer delivers messages.
+type Mailer interface {
+	Send(m Message) error
+}
+
+// validate rejects headers that would let a value inject more headers.
+func (m Message) validate() error {
+	if m.To == "" {
+		return errors.New("mailer: message has no recipient")
+	}
+	if strings.ContainsAny(m.To+m.Subject, "\r\n") {
+		return errors.New("mailer: invalid header value")
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outbox keeps sent messages instead of delivering them, for tests and
+// development. If Dir is set, each message is also written to a file there.
+type Outbox struct {
+	Dir string
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (o *Outbox) Send(m Message) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, m)
+
+	if o.Dir == "" {
+		return nil
+	}
+	name := fmt.Sprintf("%d-%03d.eml", time.Now().UnixNano(), len(o.messages))
+	content := "To: " + m.To + "\r\nSubject: " + m.Subject + "\r\n\r\n" + m.Body
+	return ioutil.WriteFile(filepath.Join(o.Dir, name), []byte(content), 0600)
+}
+
+// Messages returns the messages sent so far.
+func (o *Outbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Message(nil), o.messages...)
+}
+
+// Last returns the last message sent, or false if none was.
+func (o *Outbox) Last() (Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.messages) == 0 {
+		return Message{}, false
+	}
+	return o.messages[len(o.messages)-1], true
+}
@@ -0,0 +1,53 @@
+package mailer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ripple-cloud/cloud/mailer"
+)
+
+func TestOutbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o := &mailer.Outbox{Dir: dir}
+	if _, ok := o.Last(); ok {
+		t.Error("Expected an empty outbox")
+	}
+
+	m := mailer.Message{To: "foo@example.com", Subject: "Hello", Body: "Hello Foo"}
+	if err := o.Send(m); err != nil {
+		t.Fatal(err)
+	}
+	if last, ok := o.Last(); !ok || last != m {
+		t.Errorf("Expected last message to be %+v, Got %+v", m, last)
+	}
+	if n := len(o.Messages()); n != 1 {
+		t.Errorf("Expected 1 message, Got %d", n)
+	}
+
+	// the message is also written to Dir
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 message file, Got %v", files)
+	}
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "Hello Foo") {
+		t.Errorf("Unexpected message file %s", b)
+	}
+
+	// header injection is rejected
+	if err := o.Send(mailer.Message{To: "foo@example.com\r\nBcc: bar@example.com"}); err == nil {
+		t.Error("Expected an error for a recipient with a line break")
+	}
+}
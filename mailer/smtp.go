@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"bytes"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTP sends messages through an SMTP server.
+type SMTP struct {
+	Addr string // host:port
+	From string
+	Auth smtp.Auth // nil if the server does not require authentication
+}
+
+// NewSMTP returns a mailer authenticating with PLAIN auth, unless username
+// is empty.
+func NewSMTP(addr, username, password, from string) (*SMTP, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &SMTP{Addr: addr, From: from}
+	if username != "" {
+		m.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+func (s *SMTP) Send(m Message) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("From: " + s.From + "\r\n")
+	b.WriteString("To: " + m.To + "\r\n")
+	b.WriteString("Subject: " + m.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(m.Body)
+
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{m.To}, b.Bytes())
+}
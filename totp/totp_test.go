@@ -0,0 +1,76 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ripple-cloud/cloud/totp"
+)
+
+// RFC 6238 appendix B test vectors for SHA1, truncated to 6 digits.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	tCases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tc := range tCases {
+		code, err := totp.Code(rfcSecret, totp.Counter(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tc.code {
+			t.Errorf("%d - Expected code %s, Got %s", tc.unix, tc.code, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	code, err := totp.Code(secret, totp.Counter(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c, ok := totp.Validate(secret, code, now); !ok || c != totp.Counter(now) {
+		t.Error("Expected the current code to be valid")
+	}
+	// allows for clock drift of a period
+	if _, ok := totp.Validate(secret, code, now.Add(totp.Period)); !ok {
+		t.Error("Expected the code of the previous period to be valid")
+	}
+	if _, ok := totp.Validate(secret, code, now.Add(3*totp.Period)); ok {
+		t.Error("Expected an old code to be invalid")
+	}
+	if _, ok := totp.Validate(secret, "abc", now); ok {
+		t.Error("Expected a malformed code to be invalid")
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(totp.URI("Ripple", "foo@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Ripple:foo@example.com" {
+		t.Errorf("Unexpected URI %s", u)
+	}
+	if q := u.Query(); q.Get("secret") != "JBSWY3DPEHPK3PXP" || q.Get("issuer") != "Ripple" {
+		t.Errorf("Unexpected URI params %s", u.RawQuery)
+	}
+}